@@ -3,100 +3,200 @@ package main
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"fmt"
 	"golang.org/x/oauth2"
 	"log"
 	"net/http"
+	"net/url"
+	"newproj/app/vcs"
+	"newproj/ci"
 	"os"
 )
 
-const (
-	githubAuthorizeURL = "https://github.com/login/oauth/authorize"
-	githubTokenURL     = "https://github.com/login/oauth/access_token"
-)
-
-var (
-	githubOAuth *oauth2.Config
-	scopes      = []string{"repo"}
-)
-
-func setupGithubOAuth() {
-	githubOAuth = &oauth2.Config{
-		ClientID:     os.Getenv("GITHUB_CLIENT_ID"),
-		ClientSecret: os.Getenv("GITHUB_CLIENT_SECRET"),
-		Endpoint: oauth2.Endpoint{
-			AuthURL:  githubAuthorizeURL,
-			TokenURL: githubTokenURL,
-		},
-		Scopes: scopes,
+// setupProviders registers Github, and GitLab and Gitea if their OAuth applications are set in the env
+// Github is a Github Enterprise server if its URL is set, and each of its endpoints can be set on its own
+// Github runs as a Github App if the app's ID and private key are set
+// The provider of the projects built from plain git repositories is registered if its access key is set
+func setupProviders() {
+	github := vcs.NewGithubProvider(
+		os.Getenv("GITHUB_CLIENT_ID"),
+		os.Getenv("GITHUB_CLIENT_SECRET"),
+		os.Getenv("GITHUB_WEBHOOK_SECRET"),
+		os.Getenv("GITHUB_TOKEN"),
+	)
+	if baseURL := os.Getenv("GITHUB_URL"); baseURL != "" {
+		u, err := url.Parse(baseURL)
+		if err != nil || u.Host == "" {
+			log.Fatalf("Error parsing the Github Enterprise url %s: %v", baseURL, err)
+		}
+		github.SetEnterpriseURL(baseURL)
+		ci.GithubHost = u.Host
 	}
-}
-
-func ghAuthHandler(w http.ResponseWriter, r *http.Request) {
-	b := make([]byte, 16)
-	rand.Read(b)
-
-	state := base64.URLEncoding.EncodeToString(b)
-	session, err := fetchSession(r)
-	if err != nil {
-		log.Println("Error occurred while fetching session: ", err)
-		renderTemplate(w, "error", "Your browser session is invalid. Please try again.")
-		return
+	for env, endpoint := range map[string]*string{
+		"GITHUB_API_URL":       &github.APIURL,
+		"GITHUB_UPLOAD_URL":    &github.UploadURL,
+		"GITHUB_AUTHORIZE_URL": &github.AuthURL,
+		"GITHUB_TOKEN_URL":     &github.TokenURL,
+		"GITHUB_SSH_HOST":      &github.SSHHost,
+	} {
+		if value := os.Getenv(env); value != "" {
+			*endpoint = value
+		}
+	}
+	if appID := os.Getenv("GITHUB_APP_ID"); appID != "" {
+		app, err := vcs.NewGithubApp(appID, os.Getenv("GITHUB_APP_PRIVATE_KEY"), github.APIURL)
+		if err != nil {
+			log.Fatalf("Error setting up the Github App: %s", err)
+		}
+		github.App = app
+	}
+	vcs.RegisterProvider(github)
+
+	if os.Getenv("GITLAB_CLIENT_ID") != "" {
+		baseURL := os.Getenv("GITLAB_URL")
+		if baseURL == "" {
+			baseURL = "https://gitlab.com"
+		}
+		vcs.RegisterProvider(vcs.NewGitlabProvider(
+			baseURL,
+			os.Getenv("GITLAB_CLIENT_ID"),
+			os.Getenv("GITLAB_CLIENT_SECRET"),
+			os.Getenv("GITLAB_WEBHOOK_SECRET"),
+			os.Getenv("GITLAB_TOKEN"),
+		))
 	}
 
-	session.Values["state"] = state
-	err = session.Save(r, w)
-	if err != nil {
-		log.Println("Error occurred while saving state code to session: ", err)
-		renderTemplate(w, "error", "We encountered an error while saving your browser session. Please try again.")
-		return
+	if os.Getenv("GITEA_CLIENT_ID") != "" {
+		baseURL := os.Getenv("GITEA_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost:3000"
+		}
+		vcs.RegisterProvider(vcs.NewGiteaProvider(
+			baseURL,
+			os.Getenv("GITEA_CLIENT_ID"),
+			os.Getenv("GITEA_CLIENT_SECRET"),
+			os.Getenv("GITEA_WEBHOOK_SECRET"),
+			os.Getenv("GITEA_TOKEN"),
+		))
 	}
 
-	url := githubOAuth.AuthCodeURL(state)
-	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	if os.Getenv("GIT_ACCESS_KEY") != "" {
+		vcs.RegisterProvider(vcs.NewGitProvider(os.Getenv("GIT_ACCESS_KEY")))
+	}
 }
 
-func ghAuthCallbackHandler(w http.ResponseWriter, r *http.Request) {
-	session, err := fetchSession(r)
-	if err != nil {
-		log.Println("Error occurred while fetching session: ", err)
-		renderTemplate(w, "error", "Oops! Login request didn't complete successfully. Please try again.")
-		return
+// webhookSecret returns the secret the webhooks of the provider are created with
+func webhookSecret(p vcs.Provider) string {
+	switch p.ID() {
+	case vcs.GitlabID:
+		return os.Getenv("GITLAB_WEBHOOK_SECRET")
+	case vcs.GiteaID:
+		return os.Getenv("GITEA_WEBHOOK_SECRET")
 	}
+	return os.Getenv("GITHUB_WEBHOOK_SECRET")
+}
 
-	if r.URL.Query().Get("state") != session.Values["state"] {
-		renderTemplate(w, "error", "Hmm ... your login request seems fishy. Possible CSRF or maybe cookies not enabled. Please try again")
-		return
-	}
+// oauthConfig returns the OAuth config of the provider redirecting back to the host
+func oauthConfig(p vcs.Provider, host string) *oauth2.Config {
+	return p.OAuthConfig(fmt.Sprintf("http://%s%s", host, providerPath(p, callbackPath)))
+}
 
-	tkn, err := githubOAuth.Exchange(oauth2.NoContext, r.URL.Query().Get("code"))
-	if err != nil {
-		log.Println("Error occurred while exchanging Github Access Token: ", err)
-		renderTemplate(w, "error", "We couldn't retrieve your Github Access Token. Please try again")
-		return
+func authHandler(p vcs.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 16)
+		rand.Read(b)
+
+		state := base64.URLEncoding.EncodeToString(b)
+		session, err := fetchSession(r)
+		if err != nil {
+			log.Println("Error occurred while fetching session: ", err)
+			renderTemplate(w, "error", "Your browser session is invalid. Please try again.")
+			return
+		}
+
+		session.Values["state"] = state
+		err = session.Save(r, w)
+		if err != nil {
+			log.Println("Error occurred while saving state code to session: ", err)
+			renderTemplate(w, "error", "We encountered an error while saving your browser session. Please try again.")
+			return
+		}
+
+		url := oauthConfig(p, r.Host).AuthCodeURL(state)
+		http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 	}
+}
 
-	if !tkn.Valid() {
-		log.Println("Error retrieved token is invalid")
-		renderTemplate(w, "error", "The token we got from Github is invalid. Please try again")
-		return
+func authCallbackHandler(p vcs.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, err := fetchSession(r)
+		if err != nil {
+			log.Println("Error occurred while fetching session: ", err)
+			renderTemplate(w, "error", "Oops! Login request didn't complete successfully. Please try again.")
+			return
+		}
+
+		if r.URL.Query().Get("state") != session.Values["state"] {
+			renderTemplate(w, "error", "Hmm ... your login request seems fishy. Possible CSRF or maybe cookies not enabled. Please try again")
+			return
+		}
+
+		tkn, err := oauthConfig(p, r.Host).Exchange(oauth2.NoContext, r.URL.Query().Get("code"))
+		if err != nil {
+			log.Printf("Error occurred while exchanging %s Access Token: %s\n", p.Name(), err)
+			renderTemplate(w, "error", fmt.Sprintf("We couldn't retrieve your %s Access Token. Please try again", p.Name()))
+			return
+		}
+
+		if !tkn.Valid() {
+			log.Println("Error retrieved token is invalid")
+			renderTemplate(w, "error", fmt.Sprintf("The token we got from %s is invalid. Please try again", p.Name()))
+			return
+		}
+
+		session.Values[sessionKey(accessTokenKey, p)] = tkn.AccessToken
+		// the cached login may be another account's, that signed in with the same browser
+		delete(session.Values, loginKey)
+		err = session.Save(r, w)
+		if err != nil {
+			log.Println("Error occurred while saving access token: ", err)
+			renderTemplate(w, "error", "Something went wrong while handling your token. Please try again")
+		}
+
+		http.Redirect(w, r, dashboardURL(p), http.StatusTemporaryRedirect)
 	}
+}
 
-	// client := github.NewClient(githubOAuth.Client(oauth2.NoContext, tkn))
-
-	// user, _, err := client.Users.Get(context.Background(), "")
-	// if err != nil {
-	// 	log.Println("Error occurred while getting user name: ", err)
-	// 	fmt.Println(w, "error getting name")
-	// 	return
-	// }
-
-	// session.Values["name"] = user.Name
-	session.Values[accessTokenKey] = tkn.AccessToken
-	err = session.Save(r, w)
-	if err != nil {
-		log.Println("Error occurred while saving access token: ", err)
-		renderTemplate(w, "error", "Something went wrong while handling your token. Please try again")
+// gitAuthHandler signs users in to the git projects with the access key
+// The key takes the place of the access token of the OAuth providers
+func gitAuthHandler(p *vcs.GitProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			renderTemplate(w, "gitauth", nil)
+			return
+		}
+
+		session, err := fetchSession(r)
+		if err != nil {
+			log.Println("Error occurred while fetching session: ", err)
+			renderTemplate(w, "error", "Your browser session is invalid. Please try again.")
+			return
+		}
+
+		key := r.PostFormValue("access_key")
+		if !p.ValidAccessKey(key) {
+			renderTemplate(w, "gitauth", "The access key is invalid. Please try again.")
+			return
+		}
+
+		session.Values[sessionKey(accessTokenKey, p)] = key
+		err = session.Save(r, w)
+		if err != nil {
+			log.Println("Error occurred while saving access key: ", err)
+			renderTemplate(w, "error", "Something went wrong while handling your access key. Please try again")
+			return
+		}
+
+		http.Redirect(w, r, dashboardURL(p), http.StatusSeeOther)
 	}
-
-	http.Redirect(w, r, dashboardPath, http.StatusTemporaryRedirect)
 }
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"newproj/app/vcs"
+	"newproj/ci"
+)
+
+const (
+	// repoCacheTTL is how long the repos listed on the dashboard are served before they are refreshed
+	// The stale repos are still served while they are refreshed in the background
+	repoCacheTTL = time.Minute
+	// repoCacheExpiry is how long the repos of the users who don't open their dashboard are kept
+	repoCacheExpiry = 24 * time.Hour
+	// maxCachedDashboards is the most dashboards kept, the least recently opened ones are dropped first
+	maxCachedDashboards = 500
+
+	// hookCheckWorkers is how many repos have their webhook checked at once
+	hookCheckWorkers = 8
+)
+
+// cachedRepos are the repos listed on the dashboard of a user
+type cachedRepos struct {
+	repos      []repoWithSubscriptionInfo
+	fetchedAt  time.Time
+	refreshing bool
+	// usedAt is when the dashboard was last opened
+	usedAt time.Time
+}
+
+// repoCache holds the repos listed on the dashboards, keyed by the provider and the hash of the user's token
+var repoCache = struct {
+	sync.Mutex
+	entries map[string]*cachedRepos
+}{entries: map[string]*cachedRepos{}}
+
+// repoGroup is the repos of an owner e.g the user or one of the user's organizations
+type repoGroup struct {
+	Owner string
+	Repos []repoWithSubscriptionInfo
+}
+
+// repoFilters are the search and filters of the dashboard
+type repoFilters struct {
+	// Query is matched against the full names of the repos, ignoring case
+	Query string
+	Owner string
+	// Status is either subscribed or unsubscribed, or empty for all repos
+	Status   string
+	Language string
+}
+
+// cachedUserRepos returns the repos of the owner of the token with their subscription info, and when they were listed
+// The repos are listed on the first request, or if refresh is set, and served from the cache afterwards
+// Once they are older than the repoCacheTTL, they are refreshed in the background
+func cachedUserRepos(p vcs.Provider, token, webhookPath string, refresh bool) ([]repoWithSubscriptionInfo, time.Time) {
+	sum := sha256.Sum256([]byte(token))
+	key := p.ID() + " " + hex.EncodeToString(sum[:])
+
+	repoCache.Lock()
+	entry, ok := repoCache.entries[key]
+	if !ok || refresh {
+		repoCache.Unlock()
+		entry = &cachedRepos{
+			repos:     getUserProjectsWithSubscriptionInfo(p, p.NewClient(token), webhookPath),
+			fetchedAt: time.Now(),
+			usedAt:    time.Now(),
+		}
+		repoCache.Lock()
+		repoCache.entries[key] = entry
+		evictCachedRepos()
+		repoCache.Unlock()
+		return entry.repos, entry.fetchedAt
+	}
+
+	entry.usedAt = time.Now()
+	if time.Since(entry.fetchedAt) > repoCacheTTL && !entry.refreshing {
+		entry.refreshing = true
+		go func() {
+			repos := getUserProjectsWithSubscriptionInfo(p, p.NewClient(token), webhookPath)
+			repoCache.Lock()
+			entry.repos, entry.fetchedAt, entry.refreshing = repos, time.Now(), false
+			repoCache.Unlock()
+		}()
+	}
+	repos, fetchedAt := entry.repos, entry.fetchedAt
+	repoCache.Unlock()
+	return repos, fetchedAt
+}
+
+// evictCachedRepos drops the expired dashboards, and the least recently opened ones over maxCachedDashboards
+// The repoCache must be locked
+func evictCachedRepos() {
+	if len(repoCache.entries) <= maxCachedDashboards {
+		return
+	}
+
+	keys := make([]string, 0, len(repoCache.entries))
+	for key, entry := range repoCache.entries {
+		if time.Since(entry.usedAt) > repoCacheExpiry {
+			delete(repoCache.entries, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) <= maxCachedDashboards {
+		return
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return repoCache.entries[keys[i]].usedAt.Before(repoCache.entries[keys[j]].usedAt)
+	})
+	for _, key := range keys[:len(keys)-maxCachedDashboards] {
+		delete(repoCache.entries, key)
+	}
+}
+
+// forgetCachedRepos drops the cached repos of the owner of the token e.g once a repo is subscribed,
+// so that the next dashboard lists them again
+func forgetCachedRepos(p vcs.Provider, token string) {
+	sum := sha256.Sum256([]byte(token))
+	repoCache.Lock()
+	delete(repoCache.entries, p.ID()+" "+hex.EncodeToString(sum[:]))
+	repoCache.Unlock()
+}
+
+// getUserProjectsWithSubscriptionInfo returns the repos of the client's user with their subscription info
+// Only the repos subscribed on the server can have the sicuro webhook, so only theirs are checked,
+// a few at a time. The polled repos don't need a webhook
+func getUserProjectsWithSubscriptionInfo(p vcs.Provider, client vcs.Client, webhookPath string) []repoWithSubscriptionInfo {
+	repos := []repoWithSubscriptionInfo{}
+	for _, repo := range client.UserRepos() {
+		repos = append(repos, repoWithSubscriptionInfo{false, repo})
+	}
+
+	checks := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < hookCheckWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range checks {
+				params := vcs.RequestParams{Owner: repos[i].Owner, Repo: repos[i].Name, CallbackURL: webhookPath}
+				repos[i].IsSubscribed = client.IsRepoSubscribed(params)
+			}
+		}()
+	}
+
+	for i, repo := range repos {
+		if !isProjectOf(p, repo.Owner, repo.Name) {
+			continue
+		}
+		if isProjectPolled(repo.Owner, repo.Name) {
+			repos[i].IsSubscribed = true
+			continue
+		}
+		checks <- i
+	}
+	close(checks)
+	wg.Wait()
+
+	return repos
+}
+
+// isProjectOf returns true if the project is subscribed on the server from the given provider
+// The projects subscribed before the providers were added don't record theirs, they are Github projects
+func isProjectOf(p vcs.Provider, owner, project string) bool {
+	projectDir := filepath.Join(owner, project)
+	if _, err := os.Stat(filepath.Join(ci.LogDIR, projectDir)); err != nil {
+		return false
+	}
+	settings, err := ci.LoadProjectSettings(projectDir)
+	if err != nil {
+		return false
+	}
+	return settings.Provider == p.ID() || (settings.Provider == "" && p.ID() == vcs.GithubID)
+}
+
+// filterRepos returns the repos matching the filters
+func filterRepos(repos []repoWithSubscriptionInfo, filters repoFilters) []repoWithSubscriptionInfo {
+	query := strings.ToLower(filters.Query)
+	filtered := []repoWithSubscriptionInfo{}
+	for _, repo := range repos {
+		switch {
+		case query != "" && !strings.Contains(strings.ToLower(repo.FullName), query):
+		case filters.Owner != "" && repo.Owner != filters.Owner:
+		case filters.Language != "" && !strings.EqualFold(repo.Language, filters.Language):
+		case filters.Status == "subscribed" && !repo.IsSubscribed:
+		case filters.Status == "unsubscribed" && repo.IsSubscribed:
+		default:
+			filtered = append(filtered, repo)
+		}
+	}
+	return filtered
+}
+
+// groupReposByOwner returns the repos grouped by owner, sorted by owner and then by name
+func groupReposByOwner(repos []repoWithSubscriptionInfo) []repoGroup {
+	groups := []repoGroup{}
+	index := map[string]int{}
+	for _, repo := range repos {
+		i, ok := index[repo.Owner]
+		if !ok {
+			i = len(groups)
+			index[repo.Owner] = i
+			groups = append(groups, repoGroup{Owner: repo.Owner})
+		}
+		groups[i].Repos = append(groups[i].Repos, repo)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return strings.ToLower(groups[i].Owner) < strings.ToLower(groups[j].Owner)
+	})
+	for _, group := range groups {
+		sort.Slice(group.Repos, func(i, j int) bool {
+			return strings.ToLower(group.Repos[i].Name) < strings.ToLower(group.Repos[j].Name)
+		})
+	}
+	return groups
+}
+
+// repoOwnersAndLanguages returns the owners and the languages of the repos, sorted
+// They are the options of the dashboard filters
+func repoOwnersAndLanguages(repos []repoWithSubscriptionInfo) (owners, languages []string) {
+	seenOwners, seenLanguages := map[string]bool{}, map[string]bool{}
+	for _, repo := range repos {
+		if !seenOwners[repo.Owner] {
+			seenOwners[repo.Owner] = true
+			owners = append(owners, repo.Owner)
+		}
+		if repo.Language != "" && !seenLanguages[repo.Language] {
+			seenLanguages[repo.Language] = true
+			languages = append(languages, repo.Language)
+		}
+	}
+	sort.Strings(owners)
+	sort.Strings(languages)
+	return
+}
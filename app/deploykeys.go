@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"newproj/app/vcs"
+	"newproj/ci"
+)
+
+// addDeployKey generates a deploy key for the project and registers it on the repo with the given client
+// It returns nil if the deploy keys are disabled or the provider's projects don't get one
+func addDeployKey(client vcs.Client, owner, project string) (*ci.DeployKey, error) {
+	if !ci.DeployKeysEnabled() {
+		return nil, nil
+	}
+
+	key, err := ci.NewDeployKey(fmt.Sprintf("sicuro-ci@%s/%s", owner, project))
+	if err != nil {
+		return nil, err
+	}
+	title := fmt.Sprintf("SicuroCI (%s)", key.CreatedAt.Format(time.RFC3339))
+	key.ID, err = client.AddDeployKey(vcs.RequestParams{Owner: owner, Repo: project}, title, key.PublicKey)
+	if err == vcs.ErrNotSupported {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// deployKeysSupported returns true if the projects of the provider get their own deploy key
+func deployKeysSupported(p vcs.Provider) bool {
+	return ci.DeployKeysEnabled() && p.ID() == vcs.GithubID
+}
@@ -12,6 +12,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/gorilla/sessions"
 	"github.com/gorilla/websocket"
 	"newproj/app/vcs"
 	"newproj/app/webhook"
@@ -34,51 +35,180 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 	go writer(ws, lastMod, logFile)
 }
 
-func githubWebhookHandler(w http.ResponseWriter, r *http.Request) {
-	webhook.GithubWebhookHandler(r)
+func webhookHandler(p vcs.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		webhook.HandleWebhook(p, w, r)
+	}
 }
 
-func githubSubscriptionHandler() http.HandlerFunc {
+// subscriptionHandler subscribes the project, by creating its webhook or with polling if the mode is poll
+// Polling is for the repos the user can push to but can't create webhooks on, as that needs admin rights
+func subscriptionHandler(p vcs.Provider) http.HandlerFunc {
 	self := func(w http.ResponseWriter, r *http.Request) {
 		project := r.URL.Query().Get("project")
 		owner := r.URL.Query().Get("owner")
-		redirPath := dashboardPath
+		polled := r.URL.Query().Get("mode") == pollMode
+		redirPath := dashboardURL(p)
 		session, _ := fetchSession(r)
-		token := r.Context().Value(accessTokenCtxKey).(string)
-		client := newGithubClient(token)
+		client := clientFromRequest(r)
+		projectDir := filepath.Join(owner, project)
 
-		payload := vcs.GithubRequestParams{
+		payload := vcs.RequestParams{
 			Owner:       owner,
 			Repo:        project,
-			CallbackURL: ghCallbackURL(r.Host),
-			Creds:       os.Getenv("GITHUB_WEBHOOK_SECRET"),
+			CallbackURL: webhookURL(p, r.Host),
+			Creds:       webhookSecret(p),
 		}
 
-		err := client.Subscribe(payload)
+		settings, err := ci.LoadProjectSettings(projectDir)
 		if err != nil {
-			log.Println("Error while creating webhook", err)
-			session.AddFlash("An error occurred. The project might have already been subscribed.")
-		} else {
-			session.AddFlash("Sicro is now watching: ", project)
-			err = os.MkdirAll(filepath.Join(ci.LogDIR, owner, project), 0755)
+			log.Println("Error while loading project settings", err)
+		}
+
+		if strings.Contains(owner, "/") {
+			session.AddFlash("Sorry, projects in subgroups are not supported yet.")
+		} else if _, err := os.Stat(filepath.Join(ci.LogDIR, projectDir)); err == nil && settings.Provider != p.ID() && !(settings.Provider == "" && p.ID() == vcs.GithubID) {
+			session.AddFlash("A project with the same name is already subscribed from another provider.")
+		} else if polled {
+			repo, err := client.Repo(payload)
 			if err != nil {
-				log.Println("Error while creating log directory", err)
+				log.Println("Error while looking up project", err)
+				session.AddFlash("An error occurred while looking up the project. Please confirm that the project exists")
+			} else if !hasWriteAccess(r, owner, project) {
+				// the server clones and builds the polled repos, only the users who can push to them can subscribe them
+				session.AddFlash(fmt.Sprintf("Only the users who can push to %s can subscribe it with polling.", project))
+			} else {
+				settings.Polling = &ci.Polling{
+					URL:           repo.CloneURL,
+					Language:      repo.Language,
+					DefaultBranch: repo.DefaultBranch,
+				}
+				redirPath = subscribeProject(p, repoClient(p, client, owner, project), projectDir, settings, session)
 			}
-
-			redirPath = fmt.Sprintf("%s?project=%s&owner=%s", showPath, project, owner)
+		} else if repoClient(p, client, owner, project).IsRepoSubscribed(payload) {
+			redirPath = subscribeProject(p, repoClient(p, client, owner, project), projectDir, settings, session)
+		} else if err := repoClient(p, client, owner, project).Subscribe(payload); err != nil {
+			log.Println("Error while creating webhook", err)
+			session.AddFlash(fmt.Sprintf("We couldn't create the webhook of %s, which needs admin rights on the repo. You can subscribe with polling instead.", project))
+		} else {
+			settings.WebhookSecretID = webhook.SecretID(payload.Creds)
+			redirPath = subscribeProject(p, repoClient(p, client, owner, project), projectDir, settings, session)
 		}
 
+		forgetCachedRepos(p, r.Context().Value(accessTokenCtxKey).(string))
 		session.Save(r, w)
 		http.Redirect(w, r, redirPath, http.StatusTemporaryRedirect)
 	}
 
 	middlewares := []middleware{
+		providerMiddleware(p),
 		authenticationMiddleware,
 	}
 
 	return buildMiddlewareChain(self, middlewares...)
 }
 
+// newGitProjectHandler registers a project built from a plain git repository
+// The project is subscribed once registered, and its settings page has the post-receive hook to install
+func newGitProjectHandler(p *vcs.GitProvider) http.HandlerFunc {
+	self := func(w http.ResponseWriter, r *http.Request) {
+		session, _ := fetchSession(r)
+		if r.Method != "POST" {
+			info := struct {
+				FlashMsgs []interface{}
+				Languages []string
+			}{session.Flashes(), ci.SupportedLanguages()}
+			session.Save(r, w)
+			renderTemplate(w, "gitproject", info)
+			return
+		}
+
+		owner := strings.TrimSpace(r.PostFormValue("owner"))
+		project := strings.TrimSpace(r.PostFormValue("project"))
+		projectDir := filepath.Join(owner, project)
+		source := &ci.GitSource{
+			URL:           strings.TrimSpace(r.PostFormValue("url")),
+			Language:      r.PostFormValue("language"),
+			DefaultBranch: strings.TrimSpace(r.PostFormValue("default_branch")),
+			Secret:        newSecret(),
+		}
+		polling := &ci.Polling{Branches: splitLines(r.PostFormValue("branches"))}
+		if source.DefaultBranch == "" {
+			source.DefaultBranch = "master"
+		}
+
+		if !validProjectName(owner) || !validProjectName(project) {
+			addFlashMsg("The owner and project names may only have letters, digits, dots, dashes and underscores.", w, r)
+			http.Redirect(w, r, providerPath(p, newProjectPath), http.StatusSeeOther)
+			return
+		}
+		if err := ci.ValidateRepoURL(source.URL); err != nil {
+			addFlashMsg(fmt.Sprintf("The repository url is invalid: %s.", err), w, r)
+			http.Redirect(w, r, providerPath(p, newProjectPath), http.StatusSeeOther)
+			return
+		}
+		if strings.HasPrefix(source.DefaultBranch, "-") || strings.ContainsAny(source.DefaultBranch, " \t'\"") {
+			addFlashMsg("The default branch is not a valid branch name.", w, r)
+			http.Redirect(w, r, providerPath(p, newProjectPath), http.StatusSeeOther)
+			return
+		}
+		if _, err := os.Stat(filepath.Join(ci.LogDIR, projectDir)); err == nil {
+			addFlashMsg("A project with the same name is already subscribed.", w, r)
+			http.Redirect(w, r, providerPath(p, newProjectPath), http.StatusSeeOther)
+			return
+		}
+
+		if err := os.MkdirAll(filepath.Join(ci.LogDIR, projectDir), 0755); err != nil {
+			log.Println("Error while creating log directory", err)
+		}
+		settings := &ci.ProjectSettings{Provider: p.ID(), Git: source, Polling: polling}
+		if err := ci.SaveProjectSettings(projectDir, settings); err != nil {
+			log.Println("Error while saving project settings", err)
+			addFlashMsg("An error occurred while registering the project. Please try again.", w, r)
+			http.Redirect(w, r, providerPath(p, newProjectPath), http.StatusSeeOther)
+			return
+		}
+
+		forgetCachedRepos(p, r.Context().Value(accessTokenCtxKey).(string))
+		addFlashMsg(fmt.Sprintf("Sicro is now watching: %s. Install the post-receive hook from the project settings.", project), w, r)
+		http.Redirect(w, r, fmt.Sprintf("%s?project=%s&owner=%s", showPath, project, owner), http.StatusSeeOther)
+	}
+
+	middlewares := []middleware{
+		providerMiddleware(p),
+		authenticationMiddleware,
+	}
+
+	return buildMiddlewareChain(self, middlewares...)
+}
+
+// subscribeProject creates the log directory of the project and saves its settings with the provider
+// The project gets a deploy key registered on the repo with the client, if it has none yet
+// It returns the path of the project page
+func subscribeProject(p vcs.Provider, client vcs.Client, projectDir string, settings *ci.ProjectSettings, session *sessions.Session) string {
+	session.AddFlash("Sicro is now watching: ", filepath.Base(projectDir))
+	err := os.MkdirAll(filepath.Join(ci.LogDIR, projectDir), 0755)
+	if err != nil {
+		log.Println("Error while creating log directory", err)
+	}
+	owner, project := filepath.Split(projectDir)
+	owner = filepath.Clean(owner)
+	if settings.DeployKey == nil {
+		if key, err := addDeployKey(client, owner, project); err != nil {
+			log.Println("Error while adding deploy key", err)
+			session.AddFlash("We couldn't add a deploy key to the repo, its builds clone it with the server's SSH key. You can add one from the project page.")
+		} else {
+			settings.DeployKey = key
+		}
+	}
+	settings.Provider = p.ID()
+	if err := ci.SaveProjectSettings(projectDir, settings); err != nil {
+		log.Println("Error while saving project settings", err)
+	}
+
+	return fmt.Sprintf("%s?project=%s&owner=%s", showPath, project, owner)
+}
+
 func ciPageHandler() http.HandlerFunc {
 	self := func(w http.ResponseWriter, r *http.Request) {
 		logFile := logFilePathFromRequest(ciPath, r)
@@ -97,6 +227,21 @@ func ciPageHandler() http.HandlerFunc {
 		projectPath, _ := filepath.Rel(ciPath, r.URL.Path)
 		details := strings.Split(projectPath, "/")
 
+		var failedTests []ci.TestResult
+		var coverage *ci.Coverage
+		var baseBuild *ci.Build
+		var coverageDelta float64
+		commit := details[2]
+		build := ci.LatestBuild(filepath.Join(details[0], details[1]), details[2])
+		if build != nil {
+			commit = build.Commit
+			failedTests = build.FailedTests()
+			coverage = build.Coverage
+			if baseBuild = ci.BaseBuild(build); baseBuild != nil {
+				coverageDelta = build.CoverageDelta(baseBuild)
+			}
+		}
+
 		var v = struct {
 			Owner         string
 			Project       string
@@ -105,15 +250,25 @@ func ciPageHandler() http.HandlerFunc {
 			Data          template.HTML
 			LastMod       string
 			ProjectPath   string
+			Build         *ci.Build
+			FailedTests   []ci.TestResult
+			Coverage      *ci.Coverage
+			BaseBuild     *ci.Build
+			CoverageDelta float64
 			Notifications []interface{}
 		}{
 			Owner:         details[0],
 			Project:       details[1],
-			Commit:        details[2],
+			Commit:        commit,
 			Host:          r.Host,
 			Data:          template.HTML(p),
 			LastMod:       strconv.FormatInt(lastMod.UnixNano(), 16),
 			ProjectPath:   projectPath,
+			Build:         build,
+			FailedTests:   failedTests,
+			Coverage:      coverage,
+			BaseBuild:     baseBuild,
+			CoverageDelta: coverageDelta,
 			Notifications: session.Flashes(),
 		}
 		renderTemplate(w, "ci", &v)
@@ -126,18 +281,73 @@ func ciPageHandler() http.HandlerFunc {
 	return buildMiddlewareChain(self, middlewares...)
 }
 
+// artifactHandler serves the release artifacts of tag builds
+// The path is the project followed by the tag and the artifact name i.e /artifacts/owner/project/tag/name
+// Only the users who can read the project's repo can download them
+func artifactHandler() http.HandlerFunc {
+	self := func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		project := filepath.Join(query.Get("owner"), query.Get("project"))
+		path := ci.ArtifactPath(project, query.Get("tag"), query.Get("name"))
+		if path == "" {
+			http.Error(w, "Not found", 404)
+			return
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(path)))
+		http.ServeFile(w, r, path)
+	}
+
+	middlewares := []middleware{
+		validateRequestMethod("GET"),
+		parseArtifactPathMiddleware,
+		authenticationMiddleware,
+		authorizationMiddleware,
+		projectSubscriptionMiddleware,
+	}
+
+	return buildMiddlewareChain(self, middlewares...)
+}
+
+// dashboardPageHandler lists the user's repos grouped by owner, matching the search and filters in the query
+// The repos are served from the cache, and listed again if refresh is set
 func dashboardPageHandler() http.HandlerFunc {
 	self := func(w http.ResponseWriter, r *http.Request) {
+		p := r.Context().Value(providerCtxKey).(vcs.Provider)
 		token := r.Context().Value(accessTokenCtxKey).(string)
-		repos := getUserProjectsWithSubscriptionInfo(token, ghCallbackURL(r.Host))
+		query := r.URL.Query()
+		repos, fetchedAt := cachedUserRepos(p, token, webhookURL(p, r.Host), query.Get("refresh") != "")
+		filters := repoFilters{
+			Query:    strings.TrimSpace(query.Get("q")),
+			Owner:    query.Get("org"),
+			Status:   query.Get("status"),
+			Language: query.Get("language"),
+		}
+		filtered := filterRepos(repos, filters)
+		owners, languages := repoOwnersAndLanguages(repos)
 		session, _ := fetchSession(r)
 
 		info := struct {
 			FlashMsgs []interface{}
-			Repos     []repoWithSubscriptionInfo
+			Provider  vcs.Provider
+			Providers []vcs.Provider
+			Groups    []repoGroup
+			Filters   repoFilters
+			Owners    []string
+			Languages []string
+			Total     int
+			Shown     int
+			FetchedAt time.Time
 		}{
 			FlashMsgs: session.Flashes(),
-			Repos:     repos,
+			Provider:  p,
+			Providers: vcs.Providers(),
+			Groups:    groupReposByOwner(filtered),
+			Filters:   filters,
+			Owners:    owners,
+			Languages: languages,
+			Total:     len(repos),
+			Shown:     len(filtered),
+			FetchedAt: fetchedAt,
 		}
 		session.Save(r, w)
 		renderTemplate(w, "dashboard", info)
@@ -158,7 +368,7 @@ func indexPageHandler() http.HandlerFunc {
 			http.Redirect(w, r, dashboardPath, http.StatusTemporaryRedirect)
 			return
 		}
-		renderTemplate(w, "index", nil)
+		renderTemplate(w, "index", vcs.Providers())
 	}
 
 	middlewares := []middleware{
@@ -175,9 +385,27 @@ func showPageHandler() http.HandlerFunc {
 		logDir := filepath.Join(ci.LogDIR, owner, project)
 
 		logs := listProjectLogsInDir(logDir)
+		session, _ := fetchSession(r)
+		settings, err := ci.LoadProjectSettings(filepath.Join(owner, project))
+		if err != nil {
+			log.Println("Error while loading project settings", err)
+		}
 		info := struct {
-			Logs []projectLogListing
-		}{logs}
+			FlashMsgs []interface{}
+			Owner     string
+			Project   string
+			Logs      []projectLogListing
+			Skipped   []*ci.Build
+			// Polling is the polling state of the project, if its branches are polled
+			Polling      *ci.Polling
+			PollInterval time.Duration
+			// DeployKey is the project's deploy key, if it has one, and DeployKeys is true if it can be rotated
+			DeployKey  *ci.DeployKey
+			DeployKeys bool
+			CSRFToken  string
+		}{session.Flashes(), owner, project, logs, ci.SkippedBuilds(filepath.Join(owner, project)), settings.Polling, pollInterval,
+			settings.DeployKey, deployKeysSupported(r.Context().Value(providerCtxKey).(vcs.Provider)), csrfToken(session)}
+		session.Save(r, w)
 		renderTemplate(w, "show", info)
 	}
 
@@ -191,13 +419,435 @@ func showPageHandler() http.HandlerFunc {
 	return buildMiddlewareChain(self, middlewares...)
 }
 
+func analyticsPageHandler() http.HandlerFunc {
+	self := func(w http.ResponseWriter, r *http.Request) {
+		project := r.URL.Query().Get("project")
+		owner := r.URL.Query().Get("owner")
+
+		branches, steps := ci.ProjectAnalytics(filepath.Join(owner, project))
+		info := struct {
+			Owner    string
+			Project  string
+			Branches []ci.BranchAnalytics
+			Steps    []ci.StepTrend
+		}{owner, project, branches, steps}
+		renderTemplate(w, "analytics", info)
+	}
+
+	middlewares := []middleware{
+		validateRequestMethod("GET"),
+		authenticationMiddleware,
+		authorizationMiddleware,
+		projectSubscriptionMiddleware,
+	}
+
+	return buildMiddlewareChain(self, middlewares...)
+}
+
+func settingsPageHandler() http.HandlerFunc {
+	self := func(w http.ResponseWriter, r *http.Request) {
+		project := r.URL.Query().Get("project")
+		owner := r.URL.Query().Get("owner")
+
+		settings, err := ci.LoadProjectSettings(filepath.Join(owner, project))
+		if err != nil {
+			log.Println("Error while loading project settings", err)
+		}
+		var hook string
+		if settings.Git != nil {
+			hook = gitHookScript(webhookURL(vcs.FindProvider(vcs.GitID), r.Host), owner, project, settings.Git.Secret)
+		}
+		session, _ := fetchSession(r)
+		info := struct {
+			Owner    string
+			Project  string
+			Settings *ci.ProjectSettings
+			// Hook is the post-receive hook of the projects built from plain git repositories
+			Hook      string
+			CSRFToken string
+		}{owner, project, settings, hook, csrfToken(session)}
+		session.Save(r, w)
+		renderTemplate(w, "settings", info)
+	}
+
+	middlewares := []middleware{
+		validateRequestMethod("GET"),
+		authenticationMiddleware,
+		authorizationMiddleware,
+		projectSubscriptionMiddleware,
+	}
+
+	return buildMiddlewareChain(self, middlewares...)
+}
+
+func updateSettingsHandler() http.HandlerFunc {
+	self := func(w http.ResponseWriter, r *http.Request) {
+		project := r.URL.Query().Get("project")
+		owner := r.URL.Query().Get("owner")
+		projectDir := filepath.Join(owner, project)
+
+		// the settings decide what is built, only the users who can push to the repo can change them
+		if !hasWriteAccess(r, owner, project) {
+			addFlashMsg(fmt.Sprintf("Only the users who can push to %s can change its settings.", project), w, r)
+			http.Redirect(w, r, fmt.Sprintf("%s?project=%s&owner=%s", showPath, project, owner), http.StatusSeeOther)
+			return
+		}
+
+		err := ci.UpdateProjectSettings(projectDir, func(settings *ci.ProjectSettings) {
+			settings.Filters = ci.PipelineConfig{
+				Branches: ci.Filter{
+					Include: splitLines(r.PostFormValue("branches_include")),
+					Exclude: splitLines(r.PostFormValue("branches_exclude")),
+				},
+				Paths: ci.Filter{
+					Include: splitLines(r.PostFormValue("paths_include")),
+					Exclude: splitLines(r.PostFormValue("paths_exclude")),
+				},
+			}
+			settings.PullRequestComments = r.PostFormValue("pull_request_comments") != ""
+			if settings.Polling != nil {
+				settings.Polling.Branches = splitLines(r.PostFormValue("polled_branches"))
+			}
+		})
+		if err != nil {
+			log.Println("Error while saving project settings", err)
+			addFlashMsg("An error occurred while saving the settings. Please try again.", w, r)
+		} else {
+			addFlashMsg("The settings have been saved.", w, r)
+		}
+		http.Redirect(w, r, fmt.Sprintf("%s?project=%s&owner=%s", showPath, project, owner), http.StatusSeeOther)
+	}
+
+	middlewares := []middleware{
+		validateRequestMethod("POST"),
+		authenticationMiddleware,
+		csrfMiddleware,
+		authorizationMiddleware,
+		projectSubscriptionMiddleware,
+	}
+
+	return buildMiddlewareChain(self, middlewares...)
+}
+
+// healthPageHandler shows the health of the project's webhook: its events, content type and secret,
+// and its recent deliveries as reported by the provider
+func healthPageHandler() http.HandlerFunc {
+	self := func(w http.ResponseWriter, r *http.Request) {
+		project := r.URL.Query().Get("project")
+		owner := r.URL.Query().Get("owner")
+		p := r.Context().Value(providerCtxKey).(vcs.Provider)
+		settings, err := ci.LoadProjectSettings(filepath.Join(owner, project))
+		if err != nil {
+			log.Println("Error while loading project settings", err)
+		}
+
+		var health *vcs.WebhookHealth
+		var checks []webhook.HealthCheck
+		var healthErr string
+		if settings.Polling == nil {
+			payload := vcs.RequestParams{Owner: owner, Repo: project, CallbackURL: webhookURL(p, r.Host)}
+			health, err = repoClient(p, clientFromRequest(r), owner, project).WebhookHealth(payload)
+			if err != nil {
+				log.Println("Error while checking webhook", err)
+				healthErr = fmt.Sprintf("We couldn't read the webhooks of %s, which needs admin rights on the repo.", project)
+			} else {
+				checks = webhook.HealthChecks(p, health, settings, webhookSecret(p))
+			}
+		}
+
+		session, _ := fetchSession(r)
+		info := struct {
+			FlashMsgs []interface{}
+			Owner     string
+			Project   string
+			Provider  vcs.Provider
+			// Polling is the polling state of the project, if its branches are polled instead of pushed by a webhook
+			Polling     *ci.Polling
+			Health      *vcs.WebhookHealth
+			Checks      []webhook.HealthCheck
+			NeedsRepair bool
+			Error       string
+			CSRFToken   string
+		}{
+			FlashMsgs:   session.Flashes(),
+			Owner:       owner,
+			Project:     project,
+			Provider:    p,
+			Polling:     settings.Polling,
+			Health:      health,
+			Checks:      checks,
+			NeedsRepair: webhook.HealthFailed(checks),
+			Error:       healthErr,
+			CSRFToken:   csrfToken(session),
+		}
+		session.Save(r, w)
+		renderTemplate(w, "health", info)
+	}
+
+	middlewares := []middleware{
+		validateRequestMethod("GET"),
+		authenticationMiddleware,
+		authorizationMiddleware,
+		projectSubscriptionMiddleware,
+	}
+
+	return buildMiddlewareChain(self, middlewares...)
+}
+
+// repairWebhookHandler sets the project's webhook back to the events, content type and server's current secret
+// it's created with, or creates it if it's missing
+func repairWebhookHandler() http.HandlerFunc {
+	self := func(w http.ResponseWriter, r *http.Request) {
+		project := r.URL.Query().Get("project")
+		owner := r.URL.Query().Get("owner")
+		projectDir := filepath.Join(owner, project)
+		p := r.Context().Value(providerCtxKey).(vcs.Provider)
+		redirPath := fmt.Sprintf("%s?project=%s&owner=%s", healthPath, project, owner)
+
+		settings, err := ci.LoadProjectSettings(projectDir)
+		if err != nil {
+			log.Println("Error while loading project settings", err)
+		}
+		if settings.Polling != nil {
+			addFlashMsg("The project is polled, it has no webhook to repair.", w, r)
+			http.Redirect(w, r, redirPath, http.StatusSeeOther)
+			return
+		}
+
+		payload := vcs.RequestParams{
+			Owner:       owner,
+			Repo:        project,
+			CallbackURL: webhookURL(p, r.Host),
+			Creds:       webhookSecret(p),
+		}
+		if err := repoClient(p, clientFromRequest(r), owner, project).RepairWebhook(payload); err != nil {
+			log.Println("Error while repairing webhook", err)
+			addFlashMsg(fmt.Sprintf("We couldn't repair the webhook of %s, which needs admin rights on the repo.", project), w, r)
+			http.Redirect(w, r, redirPath, http.StatusSeeOther)
+			return
+		}
+
+		err = ci.UpdateProjectSettings(projectDir, func(settings *ci.ProjectSettings) {
+			settings.WebhookSecretID = webhook.SecretID(payload.Creds)
+		})
+		if err != nil {
+			log.Println("Error while saving project settings", err)
+		}
+		addFlashMsg("The webhook has been repaired.", w, r)
+		http.Redirect(w, r, redirPath, http.StatusSeeOther)
+	}
+
+	middlewares := []middleware{
+		validateRequestMethod("POST"),
+		authenticationMiddleware,
+		csrfMiddleware,
+		authorizationMiddleware,
+		projectSubscriptionMiddleware,
+	}
+
+	return buildMiddlewareChain(self, middlewares...)
+}
+
+// rotateDeployKeyHandler replaces the project's deploy key with a new one, or adds one to the projects without
+// The new key is registered on the repo before the old one is removed, so that the running builds can still clone it
+func rotateDeployKeyHandler() http.HandlerFunc {
+	self := func(w http.ResponseWriter, r *http.Request) {
+		project := r.URL.Query().Get("project")
+		owner := r.URL.Query().Get("owner")
+		projectDir := filepath.Join(owner, project)
+		p := r.Context().Value(providerCtxKey).(vcs.Provider)
+		redirPath := fmt.Sprintf("%s?project=%s&owner=%s", showPath, project, owner)
+
+		if !deployKeysSupported(p) {
+			addFlashMsg(fmt.Sprintf("The %s projects are cloned with the server's SSH key.", p.Name()), w, r)
+			http.Redirect(w, r, redirPath, http.StatusSeeOther)
+			return
+		}
+		settings, err := ci.LoadProjectSettings(projectDir)
+		if err != nil {
+			log.Println("Error while loading project settings", err)
+			addFlashMsg("An error occurred while loading the project settings. Please try again.", w, r)
+			http.Redirect(w, r, redirPath, http.StatusSeeOther)
+			return
+		}
+
+		client := repoClient(p, clientFromRequest(r), owner, project)
+		key, err := addDeployKey(client, owner, project)
+		if err != nil {
+			log.Println("Error while adding deploy key", err)
+			addFlashMsg(fmt.Sprintf("We couldn't add a deploy key to %s, which needs admin rights on the repo.", project), w, r)
+			http.Redirect(w, r, redirPath, http.StatusSeeOther)
+			return
+		}
+
+		old := settings.DeployKey
+		settings.DeployKey = key
+		params := vcs.RequestParams{Owner: owner, Repo: project}
+		if err := ci.SaveProjectSettings(projectDir, settings); err != nil {
+			log.Println("Error while saving project settings", err)
+			client.RemoveDeployKey(params, key.ID)
+			addFlashMsg("An error occurred while saving the deploy key. Please try again.", w, r)
+			http.Redirect(w, r, redirPath, http.StatusSeeOther)
+			return
+		}
+
+		if old == nil {
+			addFlashMsg("The deploy key has been added, the builds clone the repo with it.", w, r)
+		} else if err := client.RemoveDeployKey(params, old.ID); err != nil {
+			log.Println("Error while removing deploy key", err)
+			addFlashMsg(fmt.Sprintf("The deploy key has been rotated, but we couldn't remove the old one. Please remove %s from the repo settings.", old.Fingerprint), w, r)
+		} else {
+			addFlashMsg("The deploy key has been rotated.", w, r)
+		}
+		http.Redirect(w, r, redirPath, http.StatusSeeOther)
+	}
+
+	middlewares := []middleware{
+		validateRequestMethod("POST"),
+		authenticationMiddleware,
+		csrfMiddleware,
+		authorizationMiddleware,
+		projectSubscriptionMiddleware,
+	}
+
+	return buildMiddlewareChain(self, middlewares...)
+}
+
+// unsubscribeHandler shows what unsubscribing the project removes, and unsubscribes it once confirmed
+// The webhook is deleted and the active jobs are canceled, then the project's data is archived or deleted
+// as chosen. The cached data e.g the checked out repository is deleted either way
+func unsubscribeHandler() http.HandlerFunc {
+	self := func(w http.ResponseWriter, r *http.Request) {
+		project := r.URL.Query().Get("project")
+		owner := r.URL.Query().Get("owner")
+		projectDir := filepath.Join(owner, project)
+		p := r.Context().Value(providerCtxKey).(vcs.Provider)
+		settings, err := ci.LoadProjectSettings(projectDir)
+		if err != nil {
+			log.Println("Error while loading project settings", err)
+		}
+
+		if r.Method != "POST" {
+			session, _ := fetchSession(r)
+			info := struct {
+				FlashMsgs  []interface{}
+				Owner      string
+				Project    string
+				Provider   vcs.Provider
+				WebhookURL string
+				Polled     bool
+				ActiveJobs int
+				Data       []ci.StoredData
+				CSRFToken  string
+			}{
+				FlashMsgs:  session.Flashes(),
+				Owner:      owner,
+				Project:    project,
+				Provider:   p,
+				WebhookURL: webhookURL(p, r.Host),
+				Polled:     settings.Polling != nil,
+				ActiveJobs: ci.ProjectActiveJobs(projectDir),
+				Data:       ci.ProjectStoredData(projectDir),
+				CSRFToken:  csrfToken(session),
+			}
+			session.Save(r, w)
+			renderTemplate(w, "unsubscribe", info)
+			return
+		}
+
+		// the builds of the project are deleted or archived, only the users who can push to the repo can do it
+		if !hasWriteAccess(r, owner, project) {
+			addFlashMsg(fmt.Sprintf("Only the users who can push to %s can unsubscribe it.", project), w, r)
+			http.Redirect(w, r, fmt.Sprintf("%s?project=%s&owner=%s", unsubscribePath, project, owner), http.StatusSeeOther)
+			return
+		}
+
+		archive := r.PostFormValue("data") == "archive"
+		payload := vcs.RequestParams{Owner: owner, Repo: project, CallbackURL: webhookURL(p, r.Host)}
+		if err := repoClient(p, clientFromRequest(r), owner, project).Unsubscribe(payload); err != nil {
+			log.Println("Error while deleting webhook", err)
+			if vcs.IsPermissionError(err) {
+				addFlashMsg(fmt.Sprintf("You don't have the permission to delete the webhook of %s. Nothing was deleted.", project), w, r)
+				http.Redirect(w, r, fmt.Sprintf("%s?project=%s&owner=%s", unsubscribePath, project, owner), http.StatusSeeOther)
+				return
+			}
+			addFlashMsg(fmt.Sprintf("We couldn't delete the webhook of %s, please remove it from the repo settings.", project), w, r)
+		}
+
+		if n := ci.CancelProjectJobs(projectDir, 30*time.Second); n > 0 {
+			log.Printf("Canceled %d jobs of %s\n", n, projectDir)
+		}
+		if settings.DeployKey != nil {
+			if err := repoClient(p, clientFromRequest(r), owner, project).RemoveDeployKey(payload, settings.DeployKey.ID); err != nil {
+				log.Println("Error while removing deploy key", err)
+				addFlashMsg(fmt.Sprintf("We couldn't remove the deploy key of %s, please remove %s from the repo settings.", project, settings.DeployKey.Fingerprint), w, r)
+			}
+		}
+
+		if archive {
+			path, err := ci.ArchiveProject(projectDir)
+			if err != nil {
+				log.Println("Error while archiving project", err)
+				addFlashMsg("An error occurred while archiving the project. Nothing was deleted, please try again.", w, r)
+				http.Redirect(w, r, fmt.Sprintf("%s?project=%s&owner=%s", unsubscribePath, project, owner), http.StatusSeeOther)
+				return
+			}
+			addFlashMsg(fmt.Sprintf("Sicuro stopped watching %s. Its builds were archived to %s", project, path), w, r)
+		} else if err := ci.DeleteProject(projectDir); err != nil {
+			log.Println("Error while deleting project", err)
+			addFlashMsg("An error occurred while deleting the project. Please try again.", w, r)
+			http.Redirect(w, r, fmt.Sprintf("%s?project=%s&owner=%s", unsubscribePath, project, owner), http.StatusSeeOther)
+			return
+		} else {
+			addFlashMsg(fmt.Sprintf("Sicuro stopped watching %s. Its builds were deleted", project), w, r)
+		}
+
+		forgetCachedRepos(p, r.Context().Value(accessTokenCtxKey).(string))
+		http.Redirect(w, r, dashboardURL(p), http.StatusSeeOther)
+	}
+
+	middlewares := []middleware{
+		authenticationMiddleware,
+		csrfMiddleware,
+		authorizationMiddleware,
+		projectSubscriptionMiddleware,
+	}
+
+	return buildMiddlewareChain(self, middlewares...)
+}
+
+func testsPageHandler() http.HandlerFunc {
+	self := func(w http.ResponseWriter, r *http.Request) {
+		project := r.URL.Query().Get("project")
+		owner := r.URL.Query().Get("owner")
+
+		builds, tests := ci.ProjectTestHistory(filepath.Join(owner, project))
+		info := struct {
+			Owner   string
+			Project string
+			Builds  []*ci.Build
+			Tests   []ci.TestHistory
+		}{owner, project, builds, tests}
+		renderTemplate(w, "tests", info)
+	}
+
+	middlewares := []middleware{
+		validateRequestMethod("GET"),
+		authenticationMiddleware,
+		authorizationMiddleware,
+		projectSubscriptionMiddleware,
+	}
+
+	return buildMiddlewareChain(self, middlewares...)
+}
+
 func runCIHandler() http.HandlerFunc {
 	self := func(w http.ResponseWriter, r *http.Request) {
 		params := r.URL.Query()
 		repo := params.Get("repo")
 		redirectURL := fmt.Sprintf("ci/%s", repo)
 
-		payload := vcs.GithubRequestParams{
+		payload := vcs.RequestParams{
 			Repo:        params.Get("project"),
 			Owner:       params.Get("owner"),
 			Ref:         params.Get("sha"),
@@ -206,11 +856,19 @@ func runCIHandler() http.HandlerFunc {
 		revert := params.Get("revert")
 		lang := params.Get("language")
 		url := params.Get("url")
-		token := r.Context().Value(accessTokenCtxKey).(string)
 
-		updateBuildStatusFunc := newGithubClient(token).UpdateBuildStatus(payload)
+		baseBranch := params.Get("default_branch")
+		p := r.Context().Value(providerCtxKey).(vcs.Provider)
+		cloneToken := p.CloneToken(payload.Owner, payload.Repo)
+		client := repoClient(p, clientFromRequest(r), payload.Owner, payload.Repo)
+		updateCheckRunFunc := client.UpdateCheckRun(payload)
+		var updateBuildStatusFunc func(string)
+		if updateCheckRunFunc == nil {
+			updateBuildStatusFunc = client.UpdateBuildStatus(payload)
+		}
+		updateCoverageStatusFunc := client.UpdateCoverageStatus(payload)
 
-		webhook.ManualTrigger(payload.Repo, payload.Owner, payload.Ref, lang, revert, url, updateBuildStatusFunc)
+		webhook.ManualTrigger(payload.Repo, payload.Owner, payload.Ref, lang, revert, url, baseBranch, cloneToken, updateBuildStatusFunc, updateCoverageStatusFunc, updateCheckRunFunc)
 		http.Redirect(w, r, redirectURL, 302)
 	}
 
@@ -224,3 +882,97 @@ func runCIHandler() http.HandlerFunc {
 
 	return buildMiddlewareChain(self, middlewares...)
 }
+
+func deliveriesPageHandler() http.HandlerFunc {
+	self := func(w http.ResponseWriter, r *http.Request) {
+		session, _ := fetchSession(r)
+		info := struct {
+			FlashMsgs  []interface{}
+			Deliveries []*webhook.Delivery
+			CSRFToken  string
+		}{
+			FlashMsgs:  session.Flashes(),
+			Deliveries: webhook.Deliveries(),
+			CSRFToken:  csrfToken(session),
+		}
+		session.Save(r, w)
+		renderTemplate(w, "deliveries", info)
+	}
+
+	middlewares := []middleware{
+		validateRequestMethod("GET"),
+		authenticationMiddleware,
+		adminMiddleware,
+	}
+
+	return buildMiddlewareChain(self, middlewares...)
+}
+
+func deliveryPageHandler() http.HandlerFunc {
+	self := func(w http.ResponseWriter, r *http.Request) {
+		delivery, err := webhook.FindDelivery(r.URL.Query().Get("key"))
+		if err != nil {
+			http.Error(w, "Not found", 404)
+			return
+		}
+		session, _ := fetchSession(r)
+		info := struct {
+			*webhook.Delivery
+			CSRFToken string
+		}{delivery, csrfToken(session)}
+		session.Save(r, w)
+		renderTemplate(w, "delivery", info)
+	}
+
+	middlewares := []middleware{
+		validateRequestMethod("GET"),
+		authenticationMiddleware,
+		adminMiddleware,
+	}
+
+	return buildMiddlewareChain(self, middlewares...)
+}
+
+func replayDeliveryHandler() http.HandlerFunc {
+	self := func(w http.ResponseWriter, r *http.Request) {
+		delivery, err := webhook.ReplayDelivery(r.FormValue("key"), r.Host)
+		if err != nil {
+			log.Println("Error while replaying delivery", err)
+			addFlashMsg("The delivery could not be replayed.", w, r)
+		} else {
+			addFlashMsg(fmt.Sprintf("Replayed delivery %s: %s", delivery.ID, delivery.Status), w, r)
+		}
+		http.Redirect(w, r, adminDeliveriesPath, http.StatusSeeOther)
+	}
+
+	middlewares := []middleware{
+		validateRequestMethod("POST"),
+		authenticationMiddleware,
+		csrfMiddleware,
+		adminMiddleware,
+	}
+
+	return buildMiddlewareChain(self, middlewares...)
+}
+
+// githubMetricsPageHandler shows the calls made to each endpoint of the Github API
+// and the rate limit budget of each token
+func githubMetricsPageHandler() http.HandlerFunc {
+	self := func(w http.ResponseWriter, r *http.Request) {
+		endpoints, budgets := vcs.GithubMetrics()
+		info := struct {
+			Endpoints []vcs.GithubEndpointMetrics
+			Budgets   []vcs.GithubBudget
+			Reserve   int
+		}{endpoints, budgets, vcs.GithubBudgetReserve}
+		renderTemplate(w, "githubmetrics", info)
+	}
+
+	middlewares := []middleware{
+		validateRequestMethod("GET"),
+		authenticationMiddleware,
+		adminMiddleware,
+	}
+
+	return buildMiddlewareChain(self, middlewares...)
+}
@@ -43,8 +43,10 @@ func init() {
 }
 
 func main() {
-	setupGithubOAuth()
+	setupProviders()
+	setupNotifiers()
 	registerRoutes()
+	startPolling()
 
 	fmt.Printf("Starting server on port: %s\n", port)
 	err := http.ListenAndServe(fmt.Sprintf(":%s", port), context.ClearHandler(http.DefaultServeMux))
@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"newproj/app/vcs"
 	"newproj/ci"
 )
 
@@ -15,6 +17,58 @@ type middleware func(http.HandlerFunc) http.HandlerFunc
 
 const accessTokenKey = "AccessToken"
 const accessTokenCtxKey ctxKey = accessTokenKey
+const providerCtxKey ctxKey = "Provider"
+const loginKey = "Login"
+const csrfTokenKey = "CSRFToken"
+
+// sessionKey returns the key of the session value for the provider
+// Github values keep the keys they had before there were other providers
+func sessionKey(key string, p vcs.Provider) string {
+	if p.ID() == vcs.GithubID {
+		return key
+	}
+	return p.ID() + ":" + key
+}
+
+// providerFromRequest returns the provider of the subscribed project in the request query,
+// or the provider set in the request query. It defaults to Github
+// The projects subscribed before there were other providers are Github projects
+// It returns nil if the query sets another provider than the subscribed project's, so that the users
+// of a provider can't reach the project of the same name on another one
+func providerFromRequest(r *http.Request) vcs.Provider {
+	requested := vcs.FindProvider(r.URL.Query().Get("provider"))
+
+	project := r.URL.Query().Get("project")
+	owner := r.URL.Query().Get("owner")
+	if project != "" && owner != "" {
+		if _, err := os.Stat(filepath.Join(ci.LogDIR, owner, project)); err == nil {
+			settings, err := ci.LoadProjectSettings(filepath.Join(owner, project))
+			if err != nil {
+				return nil
+			}
+			id := settings.Provider
+			if id == "" {
+				id = vcs.GithubID
+			}
+			if requested != nil && requested.ID() != id {
+				return nil
+			}
+			return vcs.FindProvider(id)
+		}
+	}
+
+	if requested != nil {
+		return requested
+	}
+	return vcs.FindProvider(vcs.GithubID)
+}
+
+// clientFromRequest returns a client of the request's provider with the signed in user's token
+// The request must have gone through the authenticationMiddleware
+func clientFromRequest(r *http.Request) vcs.Client {
+	p := r.Context().Value(providerCtxKey).(vcs.Provider)
+	return p.NewClient(r.Context().Value(accessTokenCtxKey).(string))
+}
 
 func buildMiddlewareChain(f http.HandlerFunc, m ...middleware) http.HandlerFunc {
 	if len(m) == 0 {
@@ -37,6 +91,20 @@ func validateRequestMethod(mtd string) middleware {
 	return mware
 }
 
+// providerMiddleware sets the provider of the request to the given one
+func providerMiddleware(p vcs.Provider) middleware {
+	mware := func(f http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			values := r.URL.Query()
+			values.Set("provider", p.ID())
+			r.URL.RawQuery = values.Encode()
+			f.ServeHTTP(w, r)
+		}
+	}
+
+	return mware
+}
+
 func authenticationMiddleware(f http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		session, err := fetchSession(r)
@@ -45,10 +113,16 @@ func authenticationMiddleware(f http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		if tkn, ok := session.Values[accessTokenKey]; !ok {
-			http.Redirect(w, r, ghAuthPath, 302)
+		p := providerFromRequest(r)
+		if p == nil {
+			http.Error(w, "The project is hosted on another provider", http.StatusForbidden)
+			return
+		}
+		if tkn, ok := session.Values[sessionKey(accessTokenKey, p)]; !ok {
+			http.Redirect(w, r, providerPath(p, authPath), 302)
 		} else {
 			ctx := context.WithValue(r.Context(), accessTokenCtxKey, tkn.(string))
+			ctx = context.WithValue(ctx, providerCtxKey, p)
 			f.ServeHTTP(w, r.WithContext(ctx))
 		}
 	}
@@ -56,25 +130,27 @@ func authenticationMiddleware(f http.HandlerFunc) http.HandlerFunc {
 
 func authorizationMiddleware(f http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		accessTkn := r.Context().Value(accessTokenCtxKey).(string)
 		project := r.URL.Query().Get("project")
 		owner := r.URL.Query().Get("owner")
 
-		repo, err := getProject(accessTkn, owner, project)
+		repo, err := getProject(clientFromRequest(r), owner, project)
 		if err != nil {
 			flashMsg := "An error occurred while looking up the project. Please confirm that the project exists"
 			addFlashMsg(flashMsg, w, r)
-			http.Redirect(w, r, dashboardPath, http.StatusTemporaryRedirect)
+			http.Redirect(w, r, dashboardURL(r.Context().Value(providerCtxKey).(vcs.Provider)), http.StatusTemporaryRedirect)
 			return
 		}
 
 		values := r.URL.Query()
-		if repo.Language != nil {
-			values.Add("language", *(repo.Language))
+		if repo.Language != "" {
+			values.Add("language", repo.Language)
 		} else {
 			values.Add("language", "go")
 		}
-		values.Add("url", *(repo.HTMLURL))
+		values.Add("url", repo.URL)
+		if repo.DefaultBranch != "" {
+			values.Add("default_branch", repo.DefaultBranch)
+		}
 		r.URL.RawQuery = values.Encode()
 
 		f.ServeHTTP(w, r)
@@ -91,7 +167,7 @@ func projectSubscriptionMiddleware(f http.HandlerFunc) http.HandlerFunc {
 			flashMsg := "Oops! Looks like the project is not subscribed. Please subscribe and try again."
 			addFlashMsg(flashMsg, w, r)
 
-			http.Redirect(w, r, dashboardPath, 302)
+			http.Redirect(w, r, dashboardURL(r.Context().Value(providerCtxKey).(vcs.Provider)), 302)
 			return
 		}
 
@@ -120,3 +196,74 @@ func parseProjectDetailsMiddleware(f http.HandlerFunc) http.HandlerFunc {
 		f.ServeHTTP(w, r)
 	}
 }
+
+// csrfMiddleware rejects the POST requests whose form doesn't have the csrf_token of the session
+func csrfMiddleware(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			session, _ := fetchSession(r)
+			token, ok := session.Values[csrfTokenKey].(string)
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(r.PostFormValue("csrf_token"))) != 1 {
+				http.Error(w, "Invalid CSRF token, please reload the page and try again", http.StatusForbidden)
+				return
+			}
+		}
+		f.ServeHTTP(w, r)
+	}
+}
+
+// parseArtifactPathMiddleware sets the owner, project, tag and name query values
+// from the path of an artifact i.e /artifacts/owner/project/tag/name
+func parseArtifactPathMiddleware(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		artifactPath, _ := filepath.Rel(artifactsPath, r.URL.Path)
+		details := strings.Split(artifactPath, "/")
+		if len(details) < 4 {
+			http.Error(w, "Not found", 404)
+			return
+		}
+
+		values := r.URL.Query()
+		values.Set("owner", details[0])
+		values.Set("project", details[1])
+		values.Set("tag", strings.Join(details[2:len(details)-1], "/"))
+		values.Set("name", details[len(details)-1])
+		r.URL.RawQuery = values.Encode()
+
+		f.ServeHTTP(w, r)
+	}
+}
+
+// adminMiddleware only lets through users listed in the ADMIN_USERS env variable
+// ADMIN_USERS is a comma separated list of github logins
+func adminMiddleware(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := r.Context().Value(providerCtxKey).(vcs.Provider)
+		if p.ID() != vcs.GithubID {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		session, _ := fetchSession(r)
+		login, ok := session.Values[loginKey].(string)
+		if !ok {
+			var err error
+			if login, err = clientFromRequest(r).Login(); err != nil {
+				addFlashMsg("We couldn't look up your Github account. Please try again.", w, r)
+				http.Redirect(w, r, dashboardPath, http.StatusTemporaryRedirect)
+				return
+			}
+			session.Values[loginKey] = login
+			session.Save(r, w)
+		}
+
+		for _, admin := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+			if strings.TrimSpace(admin) != "" && strings.EqualFold(strings.TrimSpace(admin), login) {
+				f.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}
+}
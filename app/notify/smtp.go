@@ -0,0 +1,80 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+
+	"newproj/ci"
+)
+
+// sendMail sends the emails. It's replaced in the tests
+var sendMail = smtp.SendMail
+
+// SMTPNotifier emails build notifications through an SMTP server
+type SMTPNotifier struct {
+	// Addr is the host:port of the SMTP server
+	Addr string
+	// From is the sender address of the emails
+	From string
+	// Auth is used to authenticate with the SMTP server. It's nil for servers without auth
+	// such as a local SMTP sink
+	Auth smtp.Auth
+	// BaseURL is the URL the app is served at. It's used to link to the build page
+	BaseURL string
+}
+
+// NewSMTPNotifier creates a new SMTPNotifier for the given server
+// Plain auth is used if a username is given
+func NewSMTPNotifier(host, port, username, password, from, baseURL string) *SMTPNotifier {
+	notifier := &SMTPNotifier{
+		Addr:    net.JoinHostPort(host, port),
+		From:    from,
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+	if username != "" {
+		notifier.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return notifier
+}
+
+// Notify emails the given notification to its recipients
+func (s *SMTPNotifier) Notify(n ci.Notification) error {
+	return sendMail(s.Addr, s.Auth, s.From, n.Recipients, s.message(n))
+}
+
+func (s *SMTPNotifier) message(n ci.Notification) []byte {
+	b := n.Build
+	subject := fmt.Sprintf("[SicuroCI] %s: build failed on %s", b.Project, b.Commit)
+	summary := fmt.Sprintf("The build of %s failed.", b.Commit)
+	if n.Event == ci.NotifyRecovered {
+		subject = fmt.Sprintf("[SicuroCI] %s: build fixed on %s", b.Project, b.Commit)
+		summary = fmt.Sprintf("The build of %s passed after a failure.", b.Commit)
+	}
+
+	body := &bytes.Buffer{}
+	fmt.Fprintf(body, "From: %s\r\n", s.From)
+	fmt.Fprintf(body, "To: %s\r\n", strings.Join(n.Recipients, ", "))
+	fmt.Fprintf(body, "Subject: %s\r\n", subject)
+	fmt.Fprintf(body, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	fmt.Fprintf(body, "%s\r\n\r\n", summary)
+	fmt.Fprintf(body, "Project: %s\r\n", b.Project)
+	if b.Branch != "" {
+		fmt.Fprintf(body, "Branch: %s\r\n", b.Branch)
+	}
+	fmt.Fprintf(body, "Commit: %s\r\n", b.Commit)
+
+	if failed := b.FailedTests(); len(failed) > 0 {
+		fmt.Fprintf(body, "\r\nFailed tests:\r\n")
+		for _, t := range failed {
+			fmt.Fprintf(body, "  %s %s\r\n", t.Package, t.Name)
+		}
+	}
+
+	if s.BaseURL != "" {
+		fmt.Fprintf(body, "\r\n%s/ci/%s\r\n", s.BaseURL, b.LogFileName)
+	}
+	return body.Bytes()
+}
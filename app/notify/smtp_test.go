@@ -0,0 +1,127 @@
+package notify
+
+import (
+	"errors"
+	"net/smtp"
+	"reflect"
+	"strings"
+	"testing"
+
+	"newproj/ci"
+)
+
+// sentMail is an email sent through the fake sendMail
+type sentMail struct {
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+	msg  string
+}
+
+// useFakeSendMail replaces sendMail for the duration of the test
+// The returned function lists the emails sent so far
+func useFakeSendMail(t *testing.T, err error) func() []sentMail {
+	sent := []sentMail{}
+	send := sendMail
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sent = append(sent, sentMail{addr: addr, auth: a, from: from, to: to, msg: string(msg)})
+		return err
+	}
+	t.Cleanup(func() { sendMail = send })
+	return func() []sentMail { return sent }
+}
+
+func TestNewSMTPNotifier(t *testing.T) {
+	withAuth := NewSMTPNotifier("smtp.example.com", "587", "user", "secret", "ci@example.com", "https://ci.example.com/")
+	if withAuth.Addr != "smtp.example.com:587" || withAuth.BaseURL != "https://ci.example.com" || withAuth.Auth == nil {
+		t.Errorf("NewSMTPNotifier() = %+v, want the joined address, the base URL without trailing slash and plain auth", withAuth)
+	}
+
+	withoutAuth := NewSMTPNotifier("localhost", "1025", "", "", "ci@example.com", "")
+	if withoutAuth.Auth != nil {
+		t.Errorf("NewSMTPNotifier() without a username has auth %v, want none", withoutAuth.Auth)
+	}
+}
+
+func TestSMTPNotifierNotify(t *testing.T) {
+	build := &ci.Build{
+		Project:     "owner/repo",
+		Commit:      "abc123",
+		Branch:      "master",
+		LogFileName: "owner/repo/abc123",
+		Tests: []ci.TestResult{
+			{Package: "pkg", Name: "TestPass", Status: ci.TestPassed},
+			{Package: "pkg", Name: "TestFail", Status: ci.TestFailed},
+		},
+	}
+	recipients := []string{"author@example.com", "pusher@example.com"}
+
+	tests := []struct {
+		name     string
+		event    string
+		baseURL  string
+		want     []string
+		wantNone []string
+	}{
+		{
+			name:    "failed",
+			event:   ci.NotifyFailed,
+			baseURL: "https://ci.example.com",
+			want: []string{
+				"From: ci@example.com\r\n",
+				"To: author@example.com, pusher@example.com\r\n",
+				"Subject: [SicuroCI] owner/repo: build failed on abc123\r\n",
+				"The build of abc123 failed.",
+				"Branch: master\r\n",
+				"Failed tests:\r\n  pkg TestFail\r\n",
+				"https://ci.example.com/ci/owner/repo/abc123\r\n",
+			},
+			wantNone: []string{"TestPass"},
+		},
+		{
+			name:     "recovered",
+			event:    ci.NotifyRecovered,
+			want:     []string{"Subject: [SicuroCI] owner/repo: build fixed on abc123\r\n", "The build of abc123 passed after a failure."},
+			wantNone: []string{"/ci/owner/repo"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sent := useFakeSendMail(t, nil)
+			notifier := NewSMTPNotifier("smtp.example.com", "25", "", "", "ci@example.com", test.baseURL)
+			if err := notifier.Notify(ci.Notification{Event: test.event, Build: build, Recipients: recipients}); err != nil {
+				t.Fatalf("Notify() returned %s", err)
+			}
+
+			mails := sent()
+			if len(mails) != 1 {
+				t.Fatalf("Notify() sent %d emails, want 1", len(mails))
+			}
+			mail := mails[0]
+			if mail.addr != "smtp.example.com:25" || mail.from != "ci@example.com" || !reflect.DeepEqual(mail.to, recipients) {
+				t.Errorf("Notify() sent to %s from %s through %s, want %v from ci@example.com through smtp.example.com:25", mail.to, mail.from, mail.addr, recipients)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(mail.msg, want) {
+					t.Errorf("email %q doesn't contain %q", mail.msg, want)
+				}
+			}
+			for _, unwanted := range test.wantNone {
+				if strings.Contains(mail.msg, unwanted) {
+					t.Errorf("email %q contains %q", mail.msg, unwanted)
+				}
+			}
+		})
+	}
+}
+
+func TestSMTPNotifierNotifyError(t *testing.T) {
+	useFakeSendMail(t, errors.New("connection refused"))
+	notifier := NewSMTPNotifier("smtp.example.com", "25", "", "", "ci@example.com", "")
+	err := notifier.Notify(ci.Notification{Event: ci.NotifyFailed, Build: &ci.Build{}, Recipients: []string{"author@example.com"}})
+	if err == nil || err.Error() != "connection refused" {
+		t.Errorf("Notify() returned %v, want the send error", err)
+	}
+}
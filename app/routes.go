@@ -3,38 +3,92 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"newproj/app/vcs"
 )
 
 const (
-	runCIPath       = "/run"
-	showPath        = "/show"
-	indexPath       = "/index"
-	dashboardPath   = "/dashboard"
-	ciPath          = "/ci/"
-	ghAuthPath      = "/gh/auth"
-	ghSubscribePath = "/gh/subscribe"
-	ghCallbackPath  = "/gh/callback"
-	ghWebhookPath   = "/gh/webhook"
-	websocketPath   = "/ws/"
+	runCIPath          = "/run"
+	showPath           = "/show"
+	testsPath          = "/tests"
+	analyticsPath      = "/analytics"
+	settingsPath       = "/settings"
+	updateSettingsPath = "/settings/update"
+	unsubscribePath    = "/unsubscribe"
+	healthPath         = "/health"
+	repairWebhookPath  = "/health/repair"
+	rotateKeyPath      = "/deploykey/rotate"
+	indexPath          = "/index"
+	dashboardPath      = "/dashboard"
+	ciPath             = "/ci/"
+	artifactsPath      = "/artifacts/"
+	websocketPath      = "/ws/"
+
+	// the provider paths are prefixed with the provider ID e.g /gh/auth
+	authPath      = "/auth"
+	subscribePath = "/subscribe"
+	callbackPath  = "/callback"
+	webhookPath   = "/webhook"
+	// newProjectPath registers a project built from a plain git repository i.e /git/new
+	newProjectPath = "/new"
+
+	// pollMode is the subscription mode of the projects whose branches are polled instead of pushed by a webhook
+	pollMode = "poll"
+
+	adminDeliveriesPath = "/admin/deliveries"
+	adminDeliveryPath   = "/admin/delivery"
+	adminReplayPath     = "/admin/replay"
+	adminGithubPath     = "/admin/github"
 )
 
-var ghCallbackURL = func(hostAddr string) string {
-	return fmt.Sprintf("http://%s%s", hostAddr, ghWebhookPath)
+// providerPath returns the path of the provider's route e.g /gh/auth
+func providerPath(p vcs.Provider, path string) string {
+	return "/" + p.ID() + path
+}
+
+var webhookURL = func(p vcs.Provider, hostAddr string) string {
+	return fmt.Sprintf("http://%s%s", hostAddr, providerPath(p, webhookPath))
+}
+
+// dashboardURL returns the URL of the dashboard listing the repos of the provider
+func dashboardURL(p vcs.Provider) string {
+	return fmt.Sprintf("%s?provider=%s", dashboardPath, p.ID())
 }
 
 func registerRoutes() {
 	http.HandleFunc(ciPath, ciPageHandler())
+	http.HandleFunc(artifactsPath, artifactHandler())
 	http.HandleFunc(runCIPath, runCIHandler())
 	http.HandleFunc(showPath, showPageHandler())
+	http.HandleFunc(testsPath, testsPageHandler())
+	http.HandleFunc(analyticsPath, analyticsPageHandler())
+	http.HandleFunc(settingsPath, settingsPageHandler())
+	http.HandleFunc(updateSettingsPath, updateSettingsHandler())
+	http.HandleFunc(unsubscribePath, unsubscribeHandler())
+	http.HandleFunc(healthPath, healthPageHandler())
+	http.HandleFunc(repairWebhookPath, repairWebhookHandler())
+	http.HandleFunc(rotateKeyPath, rotateDeployKeyHandler())
 	http.HandleFunc(indexPath, indexPageHandler())
 	http.HandleFunc(dashboardPath, dashboardPageHandler())
-	http.HandleFunc(ghSubscribePath, githubSubscriptionHandler())
 
 	http.HandleFunc(websocketPath, wsHandler)
 
-	http.HandleFunc(ghAuthPath, ghAuthHandler)
-	http.HandleFunc(ghCallbackPath, ghAuthCallbackHandler)
-	http.HandleFunc(ghWebhookPath, githubWebhookHandler)
+	http.HandleFunc(adminDeliveriesPath, deliveriesPageHandler())
+	http.HandleFunc(adminDeliveryPath, deliveryPageHandler())
+	http.HandleFunc(adminReplayPath, replayDeliveryHandler())
+	http.HandleFunc(adminGithubPath, githubMetricsPageHandler())
+
+	for _, p := range vcs.Providers() {
+		if p, ok := p.(*vcs.GitProvider); ok {
+			http.HandleFunc(providerPath(p, authPath), gitAuthHandler(p))
+			http.HandleFunc(providerPath(p, newProjectPath), newGitProjectHandler(p))
+			http.HandleFunc(providerPath(p, webhookPath), webhookHandler(p))
+			continue
+		}
+		http.HandleFunc(providerPath(p, authPath), authHandler(p))
+		http.HandleFunc(providerPath(p, callbackPath), authCallbackHandler(p))
+		http.HandleFunc(providerPath(p, subscribePath), subscriptionHandler(p))
+		http.HandleFunc(providerPath(p, webhookPath), webhookHandler(p))
+	}
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, indexPath, http.StatusPermanentRedirect)
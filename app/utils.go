@@ -1,16 +1,22 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
-	"github.com/google/go-github/github"
 	"github.com/gorilla/sessions"
+	"newproj/app/notify"
 	"newproj/app/vcs"
+	"newproj/app/webhook"
 	"newproj/ci"
 )
 
@@ -21,7 +27,7 @@ type projectLogListing struct {
 
 type repoWithSubscriptionInfo struct {
 	IsSubscribed bool
-	*github.Repository
+	vcs.Repo
 }
 
 func renderTemplate(w http.ResponseWriter, tmpl string, data interface{}) {
@@ -86,7 +92,7 @@ func listProjectLogsInDir(dirName string) []projectLogListing {
 		fileFullName := filepath.Join(dirName, file.Name())
 		if file.IsDir() {
 			//logs = append(logs, listProjectLogsInDir(fileFullName+"/")...)
-		} else {
+		} else if strings.HasSuffix(file.Name(), ci.LogFileExt) {
 			name, _ := filepath.Rel(ci.LogDIR, fileFullName)
 			name = strings.Replace(name, ci.LogFileExt, "", 1)
 			logs = append(logs, projectLogListing{Name: name, Active: ci.ActiveCISession(fileFullName)})
@@ -95,24 +101,14 @@ func listProjectLogsInDir(dirName string) []projectLogListing {
 	return logs
 }
 
-func getUserProjectsWithSubscriptionInfo(token, webhookPath string) []repoWithSubscriptionInfo {
-	client := newGithubClient(token)
-	repos := []repoWithSubscriptionInfo{}
-	params := vcs.GithubRequestParams{CallbackURL: webhookPath}
-
-	for _, repo := range client.UserRepos() {
-		params.Owner = *(repo.Owner.Login)
-		params.Repo = *(repo.Name)
-
-		repos = append(repos, repoWithSubscriptionInfo{client.IsRepoSubscribed(params), repo})
-	}
-
-	return repos
+// isProjectPolled returns true if the branches of the project are polled for new commits
+func isProjectPolled(owner, project string) bool {
+	settings, err := ci.LoadProjectSettings(filepath.Join(owner, project))
+	return err == nil && settings.Polling != nil
 }
 
-func getProject(token, owner, project string) (*github.Repository, error) {
-	client := newGithubClient(token)
-	payload := vcs.GithubRequestParams{
+func getProject(client vcs.Client, owner, project string) (*vcs.Repo, error) {
+	payload := vcs.RequestParams{
 		Owner: owner,
 		Repo:  project,
 	}
@@ -130,6 +126,120 @@ func addFlashMsg(msg string, w http.ResponseWriter, r *http.Request) {
 	session.Save(r, w)
 }
 
-func newGithubClient(token string) *vcs.GithubClient {
-	return vcs.NewGithubClient(token)
+func setupNotifiers() {
+	if os.Getenv("SMTP_HOST") == "" {
+		return
+	}
+
+	ci.RegisterNotifier(notify.NewSMTPNotifier(
+		os.Getenv("SMTP_HOST"),
+		os.Getenv("SMTP_PORT"),
+		os.Getenv("SMTP_USERNAME"),
+		os.Getenv("SMTP_PASSWORD"),
+		os.Getenv("SMTP_FROM"),
+		os.Getenv("APP_URL"),
+	))
+}
+
+// pollInterval is how often the branches of the polled projects are checked for new commits
+var pollInterval = 5 * time.Minute
+
+// repoClient returns the provider's server client for the repo if the server is installed on it as an app
+// e.g a Github App, so that the webhooks and statuses are owned by the app. It returns the user's client otherwise
+func repoClient(p vcs.Provider, client vcs.Client, owner, repo string) vcs.Client {
+	if p.CloneToken(owner, repo) != "" {
+		return p.ServerClient(owner, repo)
+	}
+	return client
+}
+
+// startPolling polls the branches of the polled projects every POLL_INTERVAL, or 5 minutes
+// The build statuses of the polled commits link to the host of the APP_URL
+func startPolling() {
+	if interval, err := time.ParseDuration(os.Getenv("POLL_INTERVAL")); err == nil && interval > 0 {
+		pollInterval = interval
+	}
+
+	var host string
+	if u, err := url.Parse(os.Getenv("APP_URL")); err == nil {
+		host = u.Host
+	}
+	go webhook.PollProjects(pollInterval, host)
+}
+
+// splitLines returns the non empty trimmed lines of the given text
+func splitLines(text string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// projectNameRegex matches the owner and project names of the projects registered on the server
+var projectNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// validProjectName returns true if the name is safe to use as a directory of the LogDIR
+func validProjectName(name string) bool {
+	return projectNameRegex.MatchString(name) && name != "." && name != ".."
+}
+
+// newSecret returns a random hex encoded secret
+func newSecret() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// csrfToken returns the token the session's forms are posted with, setting one if the session has none
+// The session must be saved afterwards
+func csrfToken(session *sessions.Session) string {
+	token, ok := session.Values[csrfTokenKey].(string)
+	if !ok {
+		token = newSecret()
+		session.Values[csrfTokenKey] = token
+	}
+	return token
+}
+
+// hasWriteAccess returns true if the signed in user can push to the project's repo
+// The users signed in to the git projects with the server's access key can change all of them
+func hasWriteAccess(r *http.Request, owner, project string) bool {
+	p := r.Context().Value(providerCtxKey).(vcs.Provider)
+	if p.ID() == vcs.GitID {
+		return true
+	}
+
+	client := clientFromRequest(r)
+	login, err := client.Login()
+	if err != nil {
+		log.Println("Error while looking up the user", err)
+		return false
+	}
+	allowed, err := client.HasWriteAccess(vcs.RequestParams{Owner: owner, Repo: project}, login)
+	if err != nil {
+		log.Println("Error while checking the user's permission", err)
+		return false
+	}
+	return allowed
+}
+
+// gitHookScript returns the post-receive hook of a project built from a plain git repository
+// It sends a signed trigger request for each pushed ref
+func gitHookScript(triggerURL, owner, project, secret string) string {
+	return fmt.Sprintf(`#!/bin/sh
+# post-receive hook of %[2]s/%[3]s triggering its builds on SicuroCI
+SICURO_URL=%[1]s
+SICURO_SECRET=%[4]s
+
+while read before after ref; do
+  payload=$(printf '{"owner":"%[2]s","project":"%[3]s","ref":"%%s","before":"%%s","after":"%%s"}' "$ref" "$before" "$after")
+  signature=$(printf '%%s' "$payload" | openssl dgst -sha256 -hmac "$SICURO_SECRET" | sed 's/^.* //')
+  curl -fsS -X POST -H "Content-Type: application/json" -H "X-Sicuro-Event: push" \
+    -H "X-Sicuro-Signature: $signature" -d "$payload" "$SICURO_URL" > /dev/null ||
+    echo "SicuroCI could not be triggered for $ref, the push will be picked up when the branches are polled"
+done
+`, triggerURL, owner, project, secret)
 }
@@ -0,0 +1,193 @@
+package vcs
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+
+	"newproj/ci"
+)
+
+// GitClient is the client of the projects built from plain git repositories
+// The projects are registered on the server, so there's no API to call
+// and no commit statuses to update
+type GitClient struct{}
+
+// gitRepo returns the repo of the project built from the given git repository
+func gitRepo(owner, name string, source *ci.GitSource) Repo {
+	return Repo{
+		Owner:         owner,
+		Name:          name,
+		FullName:      owner + "/" + name,
+		Language:      source.Language,
+		URL:           source.URL,
+		CloneURL:      source.URL,
+		DefaultBranch: source.DefaultBranch,
+	}
+}
+
+// gitSource returns the git repository of the project with the given params
+// The error is a not found error if the project is not built from a git repository
+func gitSource(params RequestParams) (*ci.GitSource, error) {
+	settings, err := ci.LoadProjectSettings(filepath.Join(params.Owner, params.Repo))
+	if err != nil {
+		return nil, err
+	}
+	if settings.Git == nil {
+		return nil, &APIError{StatusCode: http.StatusNotFound, Message: "git project not found"}
+	}
+	return settings.Git, nil
+}
+
+// UpdateBuildStatus returns a function that does nothing, plain git repositories have no commit statuses
+func (client *GitClient) UpdateBuildStatus(params RequestParams) func(string) {
+	return func(string) {}
+}
+
+// UpdateCoverageStatus returns a function that does nothing, plain git repositories have no commit statuses
+func (client *GitClient) UpdateCoverageStatus(params RequestParams) func(string) {
+	return func(string) {}
+}
+
+// UpdateCheckRun returns nil, plain git repositories have no check runs
+func (client *GitClient) UpdateCheckRun(params RequestParams) func(*ci.CheckRun) {
+	return nil
+}
+
+// UpdatePullRequestComment returns nil, plain git repositories have no pull requests
+func (client *GitClient) UpdatePullRequestComment(params RequestParams, number int) func(string) {
+	return nil
+}
+
+// Subscribe returns an error, git projects are registered with their repository url instead
+func (client *GitClient) Subscribe(params RequestParams) error {
+	return errors.New("git projects are registered with their repository url")
+}
+
+// Unsubscribe does nothing, the post-receive hooks are installed and removed by the owners of the repositories
+func (client *GitClient) Unsubscribe(params RequestParams) error {
+	return nil
+}
+
+// AddDeployKey returns ErrNotSupported, git projects are cloned with the server's SSH key
+func (client *GitClient) AddDeployKey(params RequestParams, title, publicKey string) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+// RemoveDeployKey returns ErrNotSupported, git projects are cloned with the server's SSH key
+func (client *GitClient) RemoveDeployKey(params RequestParams, id int64) error {
+	return ErrNotSupported
+}
+
+// HasWriteAccess returns ErrNotSupported, plain git repositories have no comments
+func (client *GitClient) HasWriteAccess(params RequestParams, login string) (bool, error) {
+	return false, ErrNotSupported
+}
+
+// PullRequest returns ErrNotSupported, plain git repositories have no comments
+func (client *GitClient) PullRequest(params RequestParams, number int) (*Event, error) {
+	return nil, ErrNotSupported
+}
+
+// ReactToComment returns ErrNotSupported, plain git repositories have no comments
+func (client *GitClient) ReactToComment(params RequestParams, comment *Comment, reaction string) error {
+	return ErrNotSupported
+}
+
+// ReplyToComment returns ErrNotSupported, plain git repositories have no comments
+func (client *GitClient) ReplyToComment(params RequestParams, comment *Comment, body string) error {
+	return ErrNotSupported
+}
+
+// WebhookHealth returns an error, git projects are triggered by the post-receive hooks of their repositories
+func (client *GitClient) WebhookHealth(params RequestParams) (*WebhookHealth, error) {
+	return nil, errors.New("git projects have no webhook")
+}
+
+// RepairWebhook returns an error, the post-receive hooks are installed by the owners of the repositories
+func (client *GitClient) RepairWebhook(params RequestParams) error {
+	return errors.New("git projects have no webhook")
+}
+
+// UserRepos returns the registered git projects
+func (client *GitClient) UserRepos() []Repo {
+	repos := []Repo{}
+	for _, projectDir := range ci.GitProjects() {
+		owner, name := filepath.Split(projectDir)
+		if repo, err := client.Repo(RequestParams{Owner: filepath.Clean(owner), Repo: name}); err == nil {
+			repos = append(repos, *repo)
+		}
+	}
+	return repos
+}
+
+// Repo returns the registered git project with the given params
+func (client *GitClient) Repo(params RequestParams) (*Repo, error) {
+	source, err := gitSource(params)
+	if err != nil {
+		return nil, err
+	}
+	repo := gitRepo(params.Owner, params.Repo, source)
+	return &repo, nil
+}
+
+// FileContent returns the content of the file at the given path in the repository at the params ref
+// Only the files of local repositories can be read, the files of remote ones are reported as not found
+func (client *GitClient) FileContent(params RequestParams, path string) ([]byte, error) {
+	source, err := gitSource(params)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ci.RepoFileContent(source.URL, params.Ref, path)
+	if err != nil {
+		return nil, &APIError{StatusCode: http.StatusNotFound, Message: err.Error()}
+	}
+	return data, nil
+}
+
+// CommitSHA returns the hash of the commit the given ref e.g a tag points to
+func (client *GitClient) CommitSHA(params RequestParams, ref string) (string, error) {
+	source, err := gitSource(params)
+	if err != nil {
+		return "", err
+	}
+
+	heads, err := ci.RemoteHeads(source.URL, ref, ref+"^{}")
+	if err != nil {
+		log.Printf("Error %s occurred fetching commit for ref %s with params %v", err, ref, params)
+		return "", err
+	}
+	// annotated tags point to the tag object, the commit is the peeled ref
+	if sha, ok := heads[ref+"^{}"]; ok {
+		return sha, nil
+	}
+	if sha, ok := heads[ref]; ok {
+		return sha, nil
+	}
+	return "", &APIError{StatusCode: http.StatusNotFound, Message: "ref " + ref + " not found"}
+}
+
+// BranchHeads returns the commits the branches of the repository point to, keyed by branch
+// They are listed with git ls-remote, which has no conditional requests, so the ETag is not used
+func (client *GitClient) BranchHeads(params RequestParams, etag string) (map[string]string, string, error) {
+	source, err := gitSource(params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	heads, err := ci.RemoteBranches(source.URL)
+	return heads, "", err
+}
+
+// Login returns an error, git projects are signed in to with the server's access key
+func (client *GitClient) Login() (string, error) {
+	return "", errors.New("git projects have no users")
+}
+
+// IsRepoSubscribed returns true as all the listed git projects are registered
+func (client *GitClient) IsRepoSubscribed(params RequestParams) bool {
+	_, err := gitSource(params)
+	return err == nil
+}
@@ -0,0 +1,160 @@
+package vcs
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// GitID is the ID of the provider of the projects built from plain git repositories
+	GitID = "git"
+
+	// GitPushEvent is the event of the trigger requests of the post-receive hooks
+	GitPushEvent = "push"
+
+	gitNullSHA = "0000000000000000000000000000000000000000"
+)
+
+// GitProvider is the provider of the projects built from plain git repositories,
+// for servers that can't reach a VCS e.g in air-gapped networks
+// Users sign in with the server's access key instead of OAuth, and the projects are
+// triggered by signed requests from their post-receive hooks or by polling their branches
+type GitProvider struct {
+	// AccessKey is the key users sign in with
+	AccessKey string
+}
+
+// NewGitProvider creates a new GitProvider users sign in to with the given access key
+func NewGitProvider(accessKey string) *GitProvider {
+	return &GitProvider{AccessKey: accessKey}
+}
+
+// ID returns the GitID
+func (p *GitProvider) ID() string {
+	return GitID
+}
+
+// Name returns the name of the provider shown to users
+func (p *GitProvider) Name() string {
+	return "Git"
+}
+
+// OAuthConfig returns nil, users sign in with the access key
+func (p *GitProvider) OAuthConfig(callbackURL string) *oauth2.Config {
+	return nil
+}
+
+// ValidAccessKey returns true if the given key is the access key
+func (p *GitProvider) ValidAccessKey(key string) bool {
+	return key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(p.AccessKey)) == 1
+}
+
+// NewClient returns a GitClient
+func (p *GitProvider) NewClient(token string) Client {
+	return &GitClient{}
+}
+
+// ServerClient returns a GitClient
+func (p *GitProvider) ServerClient(owner, repo string) Client {
+	return &GitClient{}
+}
+
+// CloneToken returns an empty string, the repositories are cloned with the server's SSH key
+func (p *GitProvider) CloneToken(owner, repo string) string {
+	return ""
+}
+
+// GitPushPayload is the payload of the trigger requests, one for each pushed ref
+// It has the fields of the lines post-receive hooks read, along with the project
+type GitPushPayload struct {
+	Owner   string `json:"owner"`
+	Project string `json:"project"`
+	Ref     string `json:"ref"`
+	Before  string `json:"before"`
+	After   string `json:"after"`
+}
+
+// signGitPayload returns the hex encoded HMAC-SHA256 of the payload with the project's secret
+// It's sent in the X-Sicuro-Signature header of the trigger requests
+func signGitPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDelivery returns the delivery ID and the event name of a trigger request
+// The hooks don't send an ID, so the trigger requests are identified by the hash of their payload
+func (p *GitProvider) WebhookDelivery(headers http.Header, payload []byte) (id, event string) {
+	if len(payload) > 0 {
+		sum := sha1.Sum(payload)
+		id = hex.EncodeToString(sum[:])
+	}
+	return id, headers.Get("X-Sicuro-Event")
+}
+
+// VerifyWebhook returns true if the trigger request is signed with the secret of its project
+func (p *GitProvider) VerifyWebhook(headers http.Header, payload []byte) bool {
+	evt := GitPushPayload{}
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return false
+	}
+
+	source, err := gitSource(RequestParams{Owner: evt.Owner, Repo: evt.Project})
+	if err != nil || source.Secret == "" {
+		return false
+	}
+
+	signature := strings.ToLower(headers.Get("X-Sicuro-Signature"))
+	return hmac.Equal([]byte(signature), []byte(signGitPayload(source.Secret, payload)))
+}
+
+// ParseWebhook parses the payload of the push trigger requests
+func (p *GitProvider) ParseWebhook(event string, payload []byte) (*Event, error) {
+	if event != GitPushEvent {
+		return nil, nil
+	}
+	return parseGitPushEvent(payload)
+}
+
+// parseGitPushEvent returns the event of the pushed commit of a branch or a tag
+// The changed files are not known, so the paths filters are not applied
+// It returns a nil event when a branch or a tag is deleted
+func parseGitPushEvent(payload []byte) (*Event, error) {
+	evt := GitPushPayload{}
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, err
+	}
+
+	if evt.After == "" || evt.After == gitNullSHA {
+		return nil, nil
+	}
+
+	source, err := gitSource(RequestParams{Owner: evt.Owner, Repo: evt.Project})
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Event{
+		Type:   EventPush,
+		Commit: evt.After,
+		Repo:   gitRepo(evt.Owner, evt.Project, source),
+	}
+
+	if strings.HasPrefix(evt.Ref, "refs/tags/") {
+		// the pushed commit of an annotated tag is the tag object, so it's resolved when the job is built
+		e.Type = EventTag
+		e.Commit = ""
+		e.Tag = strings.TrimPrefix(evt.Ref, "refs/tags/")
+		return e, nil
+	}
+	e.Branch = strings.TrimPrefix(evt.Ref, "refs/heads/")
+	return e, nil
+}
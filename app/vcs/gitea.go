@@ -0,0 +1,433 @@
+package vcs
+
+import (
+	"io/ioutil"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"newproj/ci"
+)
+
+// GiteaClient is a client of the Gitea REST API
+// Forgejo serves the same API, so it's also a Forgejo client
+type GiteaClient struct {
+	// BaseURL is the URL of the Gitea instance e.g https://gitea.example.com
+	BaseURL string
+	*restClient
+}
+
+// NewGiteaClient creates a new GiteaClient for the Gitea instance at the base URL with the given token
+func NewGiteaClient(baseURL, token string) *GiteaClient {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	return &GiteaClient{
+		BaseURL:    baseURL,
+		restClient: newRESTClient(baseURL+"/api/v1", token),
+	}
+}
+
+// giteaRepo is the repository returned by the Gitea API and sent in its webhook payloads
+type giteaRepo struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Owner    struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+	HTMLURL       string `json:"html_url"`
+	SSHURL        string `json:"ssh_url"`
+	DefaultBranch string `json:"default_branch"`
+	Language      string `json:"language"`
+}
+
+func (repo giteaRepo) repo() Repo {
+	return Repo{
+		Owner:         repo.Owner.Login,
+		Name:          repo.Name,
+		FullName:      repo.FullName,
+		Language:      repo.Language,
+		URL:           repo.HTMLURL,
+		CloneURL:      repo.SSHURL,
+		DefaultBranch: repo.DefaultBranch,
+	}
+}
+
+// giteaRepoPath returns the API path of the repo with the given params
+func giteaRepoPath(params RequestParams) string {
+	return "/repos/" + url.PathEscape(params.Owner) + "/" + url.PathEscape(params.Repo)
+}
+
+// UpdateBuildStatus returns a function that when executed updates the commit status with the given status
+// Gitea has the same commit states as Github
+func (client *GiteaClient) UpdateBuildStatus(params RequestParams) func(string) {
+	return func(state string) {
+		var description string
+
+		switch state {
+		case "success":
+			description = "Your tests passed on Sicuro"
+		case "flaky":
+			description = "Your tests passed on Sicuro with flaky retries"
+			state = "success"
+		case "pending":
+			description = "Sicuro is running your tests"
+		case "failure":
+			description = "Your tests failed on Sicuro"
+		case "error":
+			description = "Sicuro couldn't run your tests. An error occurred"
+		case "skipped":
+			description = "Sicuro skipped your tests"
+			state = "success"
+		case "canceled":
+			description = "Sicuro canceled your tests. A newer commit has been pushed"
+			state = "error"
+		}
+
+		err := client.createStatus(params, "SicuroCI", state, description)
+		if err != nil {
+			log.Println("Error occurred while updating repo status on the project: ", err)
+			return
+		}
+		log.Println("Successfully update project status to:", state)
+	}
+}
+
+// UpdateCoverageStatus returns a function that when executed sets the given coverage description
+// on the commit under a separate coverage context
+func (client *GiteaClient) UpdateCoverageStatus(params RequestParams) func(string) {
+	return func(description string) {
+		err := client.createStatus(params, "SicuroCI/coverage", "success", description)
+		if err != nil {
+			log.Println("Error occurred while updating coverage status on the project: ", err)
+			return
+		}
+		log.Println("Successfully update project coverage status to:", description)
+	}
+}
+
+// UpdateCheckRun returns nil, Gitea has no check runs, the builds are reported with commit statuses
+func (client *GiteaClient) UpdateCheckRun(params RequestParams) func(*ci.CheckRun) {
+	return nil
+}
+
+// UpdatePullRequestComment returns nil, the build summary comments are only posted on Github
+func (client *GiteaClient) UpdatePullRequestComment(params RequestParams, number int) func(string) {
+	return nil
+}
+
+func (client *GiteaClient) createStatus(params RequestParams, context, state, description string) error {
+	status := map[string]string{
+		"state":       state,
+		"context":     context,
+		"target_url":  params.CallbackURL,
+		"description": description,
+	}
+	return client.do("POST", giteaRepoPath(params)+"/statuses/"+url.PathEscape(params.Ref), nil, status, nil)
+}
+
+// Subscribe adds the sicuro webhook to the given repo
+func (client *GiteaClient) Subscribe(params RequestParams) error {
+	hook := giteaHookSettings(params)
+	hook["type"] = "gitea"
+
+	err := client.do("POST", giteaRepoPath(params)+"/hooks", nil, hook, nil)
+	if err != nil {
+		log.Printf("Error %s occurred while creating webhook with params %v", err, params)
+	}
+	return err
+}
+
+// UserRepos returns the repos the owner of the token has access to, listing all the pages
+func (client *GiteaClient) UserRepos() []Repo {
+	repos := []Repo{}
+	for page := 1; ; page++ {
+		giteaRepos := []giteaRepo{}
+		query := url.Values{"limit": {"50"}, "page": {strconv.Itoa(page)}}
+		if err := client.do("GET", "/user/repos", query, nil, &giteaRepos); err != nil {
+			log.Println("Error fetching users repo: ", err)
+			break
+		}
+
+		for _, repo := range giteaRepos {
+			repos = append(repos, repo.repo())
+		}
+		if len(giteaRepos) < 50 {
+			break
+		}
+	}
+	return repos
+}
+
+// Repo fetches and returns the repo with the given params
+// The language of the repo is looked up if the Gitea version doesn't send it with the repo
+func (client *GiteaClient) Repo(params RequestParams) (*Repo, error) {
+	giteaRepo := giteaRepo{}
+	if err := client.do("GET", giteaRepoPath(params), nil, nil, &giteaRepo); err != nil {
+		log.Printf("Error %s occurred fetching repo with params %v", err, params)
+		return nil, err
+	}
+	repo := giteaRepo.repo()
+	if repo.Language != "" {
+		return &repo, nil
+	}
+
+	languages := map[string]int64{}
+	if err := client.do("GET", giteaRepoPath(params)+"/languages", nil, nil, &languages); err != nil {
+		log.Printf("Error %s occurred fetching repo languages with params %v", err, params)
+	}
+	var size int64
+	for language, bytes := range languages {
+		if bytes > size {
+			repo.Language, size = language, bytes
+		}
+	}
+	return &repo, nil
+}
+
+// FileContent returns the content of the file at the given path in the repo at the params ref
+// A missing file is reported with an error for which IsNotFound returns true
+func (client *GiteaClient) FileContent(params RequestParams, path string) ([]byte, error) {
+	query := url.Values{"ref": {params.Ref}}
+	resp, err := client.send("GET", giteaRepoPath(params)+"/raw/"+path, query, nil)
+	if err != nil {
+		if !isAPINotFound(err) {
+			log.Printf("Error %s occurred fetching file %s with params %v", err, path, params)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// CommitSHA returns the hash of the commit the given ref e.g a tag points to
+func (client *GiteaClient) CommitSHA(params RequestParams, ref string) (string, error) {
+	var sha string
+	var err error
+	if strings.HasPrefix(ref, "refs/tags/") {
+		tag := struct {
+			Commit struct {
+				SHA string `json:"sha"`
+			} `json:"commit"`
+		}{}
+		err = client.do("GET", giteaRepoPath(params)+"/tags/"+url.PathEscape(strings.TrimPrefix(ref, "refs/tags/")), nil, nil, &tag)
+		sha = tag.Commit.SHA
+	} else {
+		commits := []struct {
+			SHA string `json:"sha"`
+		}{}
+		err = client.do("GET", giteaRepoPath(params)+"/commits", url.Values{"sha": {ref}, "limit": {"1"}}, nil, &commits)
+		if len(commits) > 0 {
+			sha = commits[0].SHA
+		}
+	}
+
+	if err != nil {
+		log.Printf("Error %s occurred fetching commit for ref %s with params %v", err, ref, params)
+	}
+	return sha, err
+}
+
+// Login returns the login of the user owning the token
+func (client *GiteaClient) Login() (string, error) {
+	user := struct {
+		Login string `json:"login"`
+	}{}
+	if err := client.do("GET", "/user", nil, nil, &user); err != nil {
+		log.Println("Error fetching user: ", err)
+		return "", err
+	}
+	return user.Login, nil
+}
+
+// IsRepoSubscribed checks if the given repo has the sicuro webhook set
+func (client *GiteaClient) IsRepoSubscribed(params RequestParams) bool {
+	hooks := []struct {
+		Active bool              `json:"active"`
+		Config map[string]string `json:"config"`
+	}{}
+	if err := client.do("GET", giteaRepoPath(params)+"/hooks", nil, nil, &hooks); err != nil {
+		log.Printf("Error %s occurred checking repo subscription status with params %v", err, params)
+		return false
+	}
+
+	for _, hook := range hooks {
+		if hook.Active && hook.Config["url"] == params.CallbackURL {
+			return true
+		}
+	}
+	return false
+}
+
+// Unsubscribe deletes the sicuro webhooks of the given repo
+func (client *GiteaClient) Unsubscribe(params RequestParams) error {
+	hooks, err := client.sicuroHooks(params)
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range hooks {
+		err := client.do("DELETE", giteaRepoPath(params)+"/hooks/"+strconv.FormatInt(hook.ID, 10), nil, nil, nil)
+		if err != nil && !isAPINotFound(err) {
+			log.Printf("Error %s occurred deleting webhook with params %v", err, params)
+			return err
+		}
+	}
+	return nil
+}
+
+// AddDeployKey returns ErrNotSupported, only the Github projects get deploy keys
+func (client *GiteaClient) AddDeployKey(params RequestParams, title, publicKey string) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+// RemoveDeployKey returns ErrNotSupported, only the Github projects get deploy keys
+func (client *GiteaClient) RemoveDeployKey(params RequestParams, id int64) error {
+	return ErrNotSupported
+}
+
+// HasWriteAccess returns true if the user with the given login has the write, admin or owner permission on the repo
+func (client *GiteaClient) HasWriteAccess(params RequestParams, login string) (bool, error) {
+	permission := struct {
+		Permission string `json:"permission"`
+	}{}
+	err := client.do("GET", giteaRepoPath(params)+"/collaborators/"+url.PathEscape(login)+"/permission", nil, nil, &permission)
+	if err != nil {
+		log.Printf("Error %s occurred checking the permission of %s with params %v", err, login, params)
+		return false, err
+	}
+	switch permission.Permission {
+	case "write", "admin", "owner":
+		return true, nil
+	}
+	return false, nil
+}
+
+// PullRequest returns ErrNotSupported, the comment commands are only read from Github
+func (client *GiteaClient) PullRequest(params RequestParams, number int) (*Event, error) {
+	return nil, ErrNotSupported
+}
+
+// ReactToComment returns ErrNotSupported, the comment commands are only read from Github
+func (client *GiteaClient) ReactToComment(params RequestParams, comment *Comment, reaction string) error {
+	return ErrNotSupported
+}
+
+// ReplyToComment returns ErrNotSupported, the comment commands are only read from Github
+func (client *GiteaClient) ReplyToComment(params RequestParams, comment *Comment, body string) error {
+	return ErrNotSupported
+}
+
+// giteaHookEvents are the events the sicuro webhooks are subscribed to
+var giteaHookEvents = []string{"push", "pull_request", "release"}
+
+// giteaHook is a repo webhook returned by the Gitea API
+// Its config has the url and the content type, Gitea doesn't return the secret
+type giteaHook struct {
+	ID     int64             `json:"id"`
+	Active bool              `json:"active"`
+	Events []string          `json:"events"`
+	Config map[string]string `json:"config"`
+}
+
+// giteaHookSettings returns the settings of the sicuro webhook of the repo
+func giteaHookSettings(params RequestParams) map[string]interface{} {
+	return map[string]interface{}{
+		"active": true,
+		"events": giteaHookEvents,
+		"config": map[string]string{
+			"content_type": "json",
+			"url":          params.CallbackURL,
+			"secret":       params.Creds,
+		},
+	}
+}
+
+// sicuroHooks returns the webhooks of the repo delivering to the params callback URL, the active ones first
+func (client *GiteaClient) sicuroHooks(params RequestParams) ([]giteaHook, error) {
+	hooks := []giteaHook{}
+	if err := client.do("GET", giteaRepoPath(params)+"/hooks", url.Values{"limit": {"50"}}, nil, &hooks); err != nil {
+		log.Printf("Error %s occurred listing webhooks with params %v", err, params)
+		return nil, err
+	}
+
+	active, inactive := []giteaHook{}, []giteaHook{}
+	for _, hook := range hooks {
+		if hook.Config["url"] != params.CallbackURL {
+			continue
+		}
+		if hook.Active {
+			active = append(active, hook)
+		} else {
+			inactive = append(inactive, hook)
+		}
+	}
+	return append(active, inactive...), nil
+}
+
+// WebhookHealth returns the state of the sicuro webhook of the repo
+// Gitea neither reports the secret of the webhook nor its deliveries, the secret is assumed to be set
+// and a wrong one shows up as deliveries rejected by the server
+func (client *GiteaClient) WebhookHealth(params RequestParams) (*WebhookHealth, error) {
+	hooks, err := client.sicuroHooks(params)
+	if err != nil {
+		return nil, err
+	}
+	health := &WebhookHealth{Hooks: len(hooks)}
+	if len(hooks) == 0 {
+		return health, nil
+	}
+
+	hook := hooks[0]
+	health.Found, health.Active, health.Events = true, hook.Active, hook.Events
+	health.MissingEvents = missingEvents(hook.Events, giteaHookEvents)
+	health.ContentType = hook.Config["content_type"]
+	health.HasSecret = true
+	return health, nil
+}
+
+// RepairWebhook sets the sicuro webhook of the repo back to the events, content type and secret it's created with
+// The first webhook is updated and the duplicates are deleted, or the webhook is created if the repo has none
+func (client *GiteaClient) RepairWebhook(params RequestParams) error {
+	hooks, err := client.sicuroHooks(params)
+	if err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		return client.Subscribe(params)
+	}
+
+	hooksPath := giteaRepoPath(params) + "/hooks/"
+	if err := client.do("PATCH", hooksPath+strconv.FormatInt(hooks[0].ID, 10), nil, giteaHookSettings(params), nil); err != nil {
+		log.Printf("Error %s occurred while updating webhook with params %v", err, params)
+		return err
+	}
+	for _, hook := range hooks[1:] {
+		err := client.do("DELETE", hooksPath+strconv.FormatInt(hook.ID, 10), nil, nil, nil)
+		if err != nil && !isAPINotFound(err) {
+			log.Printf("Error %s occurred deleting webhook with params %v", err, params)
+			return err
+		}
+	}
+	return nil
+}
+
+// BranchHeads returns the commits the first 50 branches of the repo point to, keyed by branch
+func (client *GiteaClient) BranchHeads(params RequestParams, etag string) (map[string]string, string, error) {
+	branches := []struct {
+		Name   string `json:"name"`
+		Commit struct {
+			ID string `json:"id"`
+		} `json:"commit"`
+	}{}
+	query := url.Values{"limit": {"50"}}
+	newETag, err := client.getIfChanged(giteaRepoPath(params)+"/branches", query, etag, &branches)
+	if err != nil {
+		return nil, newETag, err
+	}
+
+	heads := map[string]string{}
+	for _, branch := range branches {
+		heads[branch.Name] = branch.Commit.ID
+	}
+	return heads, newETag, nil
+}
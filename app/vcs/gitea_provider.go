@@ -0,0 +1,283 @@
+package vcs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// GiteaID is the ID of the Gitea provider
+	GiteaID = "gt"
+
+	// giteaNullSHA is the commit hash Gitea sends for deleted branches and tags
+	giteaNullSHA = "0000000000000000000000000000000000000000"
+)
+
+// GiteaProvider is the provider of a Gitea or Forgejo instance
+type GiteaProvider struct {
+	// BaseURL is the URL of the Gitea instance e.g https://gitea.example.com
+	BaseURL      string
+	ClientID     string
+	ClientSecret string
+	// WebhookSecret is the secret the webhook requests are signed with
+	WebhookSecret string
+	// Token is the server's token, used for offline actions such as build status updates
+	Token string
+}
+
+// NewGiteaProvider creates a new GiteaProvider for the Gitea instance at the base URL
+// with the given OAuth2 application credentials, webhook secret and server token
+func NewGiteaProvider(baseURL, clientID, clientSecret, webhookSecret, token string) *GiteaProvider {
+	return &GiteaProvider{
+		BaseURL:       strings.TrimSuffix(baseURL, "/"),
+		ClientID:      clientID,
+		ClientSecret:  clientSecret,
+		WebhookSecret: webhookSecret,
+		Token:         token,
+	}
+}
+
+// ID returns the GiteaID
+func (p *GiteaProvider) ID() string {
+	return GiteaID
+}
+
+// Name returns the name of the provider shown to users
+func (p *GiteaProvider) Name() string {
+	return "Gitea"
+}
+
+// OAuthConfig returns the config users sign in with
+// Gitea requires the callback URL to match one of the redirect URIs of the Gitea application
+func (p *GiteaProvider) OAuthConfig(callbackURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  p.BaseURL + "/login/oauth/authorize",
+			TokenURL: p.BaseURL + "/login/oauth/access_token",
+		},
+		RedirectURL: callbackURL,
+	}
+}
+
+// NewClient returns a GiteaClient with the given token
+func (p *GiteaProvider) NewClient(token string) Client {
+	return NewGiteaClient(p.BaseURL, token)
+}
+
+// ServerClient returns a GiteaClient with the server's token, which is the same for every repo
+// It returns nil if the token is not set
+func (p *GiteaProvider) ServerClient(owner, repo string) Client {
+	if p.Token == "" {
+		return nil
+	}
+	return NewGiteaClient(p.BaseURL, p.Token)
+}
+
+// CloneToken returns an empty string, the repos are cloned with the server's SSH key
+func (p *GiteaProvider) CloneToken(owner, repo string) string {
+	return ""
+}
+
+// giteaHeader returns the value of the Gitea header with the given name
+// Forgejo sends the same headers with its own prefix as well
+func giteaHeader(headers http.Header, name string) string {
+	if value := headers.Get("X-Gitea-" + name); value != "" {
+		return value
+	}
+	return headers.Get("X-Forgejo-" + name)
+}
+
+// WebhookDelivery returns the delivery ID and the event name of a webhook request
+func (p *GiteaProvider) WebhookDelivery(headers http.Header, payload []byte) (id, event string) {
+	return giteaHeader(headers, "Delivery"), giteaHeader(headers, "Event")
+}
+
+// VerifyWebhook returns true if the webhook request is signed with the webhook secret
+// Gitea signs the payload with HMAC-SHA256 and sends the hex encoded signature
+func (p *GiteaProvider) VerifyWebhook(headers http.Header, payload []byte) bool {
+	signature, err := hex.DecodeString(giteaHeader(headers, "Signature"))
+	if err != nil || len(signature) == 0 {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(p.WebhookSecret))
+	mac.Write(payload)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+// giteaCommitPayload is a commit sent in the Gitea push payloads
+type giteaCommitPayload struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	Author  struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"author"`
+	Added    []string `json:"added"`
+	Modified []string `json:"modified"`
+	Removed  []string `json:"removed"`
+}
+
+func (c giteaCommitPayload) commit() Commit {
+	commit := Commit{
+		ID:      c.ID,
+		Message: c.Message,
+		Author:  Person{Name: c.Author.Name, Email: c.Author.Email},
+	}
+	commit.Files = append(commit.Files, c.Added...)
+	commit.Files = append(commit.Files, c.Modified...)
+	commit.Files = append(commit.Files, c.Removed...)
+	return commit
+}
+
+// giteaPushPayload is the payload of the push events of branches and tags
+type giteaPushPayload struct {
+	Ref        string               `json:"ref"`
+	After      string               `json:"after"`
+	Repository giteaRepo            `json:"repository"`
+	HeadCommit *giteaCommitPayload  `json:"head_commit"`
+	Commits    []giteaCommitPayload `json:"commits"`
+	Pusher     struct {
+		Login    string `json:"login"`
+		FullName string `json:"full_name"`
+		Email    string `json:"email"`
+	} `json:"pusher"`
+}
+
+// giteaPRPayload is the payload of the pull request events
+type giteaPRPayload struct {
+	Action      string    `json:"action"`
+	Number      int       `json:"number"`
+	Repository  giteaRepo `json:"repository"`
+	PullRequest struct {
+		Head struct {
+			Ref    string `json:"ref"`
+			SHA    string `json:"sha"`
+			RepoID int64  `json:"repo_id"`
+		} `json:"head"`
+		Base struct {
+			Ref    string `json:"ref"`
+			RepoID int64  `json:"repo_id"`
+		} `json:"base"`
+	} `json:"pull_request"`
+}
+
+// giteaReleasePayload is the payload of the release events
+type giteaReleasePayload struct {
+	Action     string    `json:"action"`
+	Repository giteaRepo `json:"repository"`
+	Release    struct {
+		TagName string `json:"tag_name"`
+	} `json:"release"`
+}
+
+// ParseWebhook parses the payload of the push, pull request and release events
+func (p *GiteaProvider) ParseWebhook(event string, payload []byte) (*Event, error) {
+	switch event {
+	case "push":
+		return parseGiteaPushEvent(payload)
+	case "pull_request":
+		return parseGiteaPREvent(payload)
+	case "release":
+		return parseGiteaReleaseEvent(payload)
+	}
+	return nil, nil
+}
+
+// parseGiteaPushEvent returns the event of the pushed commit of a branch or a tag
+// It returns a nil event when a branch or a tag is deleted
+func parseGiteaPushEvent(payload []byte) (*Event, error) {
+	evt := giteaPushPayload{}
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, err
+	}
+
+	if evt.After == "" || evt.After == giteaNullSHA {
+		return nil, nil
+	}
+
+	pusher := evt.Pusher.FullName
+	if pusher == "" {
+		pusher = evt.Pusher.Login
+	}
+	e := &Event{
+		Type:   EventPush,
+		Commit: evt.After,
+		Repo:   evt.Repository.repo(),
+		Pusher: Person{Name: pusher, Email: evt.Pusher.Email},
+	}
+
+	for _, c := range evt.Commits {
+		e.Commits = append(e.Commits, c.commit())
+	}
+	if evt.HeadCommit != nil {
+		e.HeadCommit = evt.HeadCommit.commit()
+	}
+
+	if strings.HasPrefix(evt.Ref, "refs/tags/") {
+		e.Type = EventTag
+		e.Tag = strings.TrimPrefix(evt.Ref, "refs/tags/")
+		return e, nil
+	}
+	e.Branch = strings.TrimPrefix(evt.Ref, "refs/heads/")
+	return e, nil
+}
+
+// parseGiteaPREvent returns the event of a pull request when it's opened, reopened or has new commits
+// Gitea doesn't keep a merge ref of pull requests, so the head ref is built
+// It returns a nil event for the other pull request actions, and ErrForkPullRequest for the pull requests from forks
+func parseGiteaPREvent(payload []byte) (*Event, error) {
+	evt := giteaPRPayload{}
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, err
+	}
+
+	switch evt.Action {
+	case "opened", "reopened", "synchronized":
+	default:
+		return nil, nil
+	}
+	if evt.PullRequest.Head.RepoID != evt.PullRequest.Base.RepoID {
+		return nil, ErrForkPullRequest
+	}
+
+	e := &Event{
+		Type:        EventPullRequest,
+		Commit:      evt.PullRequest.Head.SHA,
+		Ref:         fmt.Sprintf("refs/pull/%d/head", evt.Number),
+		Branch:      evt.PullRequest.Head.Ref,
+		BaseBranch:  evt.PullRequest.Base.Ref,
+		PullRequest: evt.Number,
+		Repo:        evt.Repository.repo(),
+	}
+	return e, nil
+}
+
+// parseGiteaReleaseEvent returns the tag event of a published release
+// The payload doesn't have the tagged commit, so it's resolved when the job is built
+func parseGiteaReleaseEvent(payload []byte) (*Event, error) {
+	evt := giteaReleasePayload{}
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, err
+	}
+
+	if evt.Action != "published" {
+		return nil, nil
+	}
+
+	e := &Event{
+		Type: EventTag,
+		Tag:  evt.Release.TagName,
+		Repo: evt.Repository.repo(),
+	}
+	return e, nil
+}
@@ -2,7 +2,14 @@ package vcs
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"golang.org/x/oauth2"
 
@@ -15,35 +22,53 @@ var ctx = context.Background()
 // It allows addition of custom method to the instance
 type GithubClient struct {
 	*github.Client
-}
-
-// GithubRequestParams is a collection of common params
-// required by github related methods in this this package
-type GithubRequestParams struct {
-	// Repo is the request target repo
-	Repo string
-	// Owner refers to the owner of the request target repo
-	Owner string
-	// Ref is the target sha ref on the repo
-	Ref string
-	// Callback is a URL linking back to the site
-	CallbackURL string
-	// Creds is any credential or secret required for the request
-	Creds string
+	// app is set for the clients with the token of a Github App installation, which can create check runs
+	app bool
+	// botLogin is the login of the app's bot user the installation clients comment as e.g sicuro[bot]
+	botLogin string
+	// tokenHash identifies the client's token in the cache of the list requests
+	tokenHash string
+	// sshHost is the host the repos are cloned from over SSH, if it's not the one of their SSH urls
+	sshHost string
 }
 
 // NewGithubClient creates a new GithubClient with the given token
 // The given token is passed to the underlying github.Client initialization
+// The requests go through the githubTransport, which retries them and keeps track of the rate limit
 func NewGithubClient(token string) *GithubClient {
 	tkn := &oauth2.Token{AccessToken: token}
 	ts := oauth2.StaticTokenSource(tkn)
 	tc := oauth2.NewClient(ctx, ts)
-	return &GithubClient{github.NewClient(tc)}
+	sum := sha256.Sum256([]byte(token))
+	tokenHash := hex.EncodeToString(sum[:])
+	tc.Transport = newGithubTransport(tc.Transport, tokenHash)
+	return &GithubClient{Client: github.NewClient(tc), tokenHash: tokenHash}
+}
+
+// newGithubClient creates a new GithubClient with the given token calling the API and the uploads API
+// at the given URLs, if set e.g the ones of a Github Enterprise server
+func newGithubClient(token, apiURL, uploadURL string) *GithubClient {
+	client := NewGithubClient(token)
+	if apiURL != "" {
+		if baseURL, err := url.Parse(strings.TrimSuffix(apiURL, "/") + "/"); err == nil {
+			client.BaseURL = baseURL
+		} else {
+			log.Printf("Error %s parsing the Github API url %s", err, apiURL)
+		}
+	}
+	if uploadURL != "" {
+		if u, err := url.Parse(strings.TrimSuffix(uploadURL, "/") + "/"); err == nil {
+			client.UploadURL = u
+		} else {
+			log.Printf("Error %s parsing the Github uploads url %s", err, uploadURL)
+		}
+	}
+	return client
 }
 
 // UpdateBuildStatus returns a function that when executed updates the repo status with the given status
 // it takes the repo, owner and ref as args
-func (client *GithubClient) UpdateBuildStatus(params GithubRequestParams) func(string) {
+func (client *GithubClient) UpdateBuildStatus(params RequestParams) func(string) {
 	status := &github.RepoStatus{
 		TargetURL: github.String(params.CallbackURL),
 		Context:   github.String("SicuroCI"),
@@ -55,12 +80,21 @@ func (client *GithubClient) UpdateBuildStatus(params GithubRequestParams) func(s
 		switch state {
 		case "success":
 			description = "Your tests passed on Sicuro"
+		case "flaky":
+			description = "Your tests passed on Sicuro with flaky retries"
+			state = "success"
 		case "pending":
 			description = "Sicuro is running your tests"
 		case "failure":
 			description = "Your tests failed on Sicuro"
 		case "error":
 			description = "Sicuro couldn't run your tests. An error occurred"
+		case "skipped":
+			description = "Sicuro skipped your tests"
+			state = "success"
+		case "canceled":
+			description = "Sicuro canceled your tests. A newer commit has been pushed"
+			state = "error"
 		}
 
 		status.State = github.String(state)
@@ -75,21 +109,34 @@ func (client *GithubClient) UpdateBuildStatus(params GithubRequestParams) func(s
 	}
 }
 
+// UpdateCoverageStatus returns a function that when executed sets the given coverage description
+// on the repo status under a separate coverage context
+func (client *GithubClient) UpdateCoverageStatus(params RequestParams) func(string) {
+	status := &github.RepoStatus{
+		State:     github.String("success"),
+		TargetURL: github.String(params.CallbackURL),
+		Context:   github.String("SicuroCI/coverage"),
+	}
+
+	return func(description string) {
+		status.Description = github.String(description)
+		_, _, err := client.Repositories.CreateStatus(ctx, params.Owner, params.Repo, params.Ref, status)
+
+		if err != nil {
+			log.Println("Error occurred while updating coverage status on the project: ", err)
+			return
+		}
+		log.Println("Successfully update project coverage status to:", description)
+	}
+}
+
 // Subscribe adds the sicuro webhook to the given repo
-// TODO: save the access token of the user subscribing a repo to the database to use for offline actions such as build status update
-func (client *GithubClient) Subscribe(params GithubRequestParams) error {
-	hook := github.Hook{
-		Name:   github.String("web"),
-		Active: github.Bool(true),
-		Events: []string{"push", "pull_request"},
-		Config: map[string]interface{}{
-			"content_type": "json",
-			"url":          params.CallbackURL,
-			"secret":       params.Creds,
-		},
-	}
-
-	_, _, err := client.Repositories.CreateHook(ctx, params.Owner, params.Repo, &hook)
+// The webhook builds report their statuses with the provider's server client, e.g with the token of the Github App
+func (client *GithubClient) Subscribe(params RequestParams) error {
+	hook := newGithubHook(params)
+	hook.Name = github.String("web")
+
+	_, _, err := client.Repositories.CreateHook(ctx, params.Owner, params.Repo, hook)
 	if err != nil {
 		log.Printf("Error %s occurred while creating webhook with params %v", err, params)
 
@@ -100,47 +147,170 @@ func (client *GithubClient) Subscribe(params GithubRequestParams) error {
 
 // UserRepos returns a list of the github repos belongs to the user
 // The user here refers to the owner of the access token used for the  github client
-// Although the github api allows to explicitly specify a user,
-// For now let it default to the user owning the access token
-func (client *GithubClient) UserRepos() []*github.Repository {
-	repos, _, err := client.Repositories.List(ctx, "", nil)
+// They include the repos of the organizations the user is a member of, and the ones the user collaborates on
+// All the pages are listed, and the pages that haven't changed since they were last listed are read from the cache
+// It's a low priority call, held back once the rate limit budget of the token is low
+func (client *GithubClient) UserRepos() []Repo {
+	userRepos := []Repo{}
+	for page := 1; page != 0; {
+		repos := []*github.Repository{}
+		next, err := client.getCached(lowPriority, fmt.Sprintf("user/repos?per_page=100&page=%d", page), &repos)
+		if err != nil {
+			log.Println("Error fetching users repo: ", err)
+			break
+		}
+
+		for _, repo := range repos {
+			userRepos = append(userRepos, githubRepo(repo, client.sshHost))
+		}
+		page = next
+	}
+	return userRepos
+}
+
+// FileContent returns the content of the file at the given path in the repo at the params ref
+// A missing file is reported with an error for which IsNotFound returns true
+func (client *GithubClient) FileContent(params RequestParams, path string) ([]byte, error) {
+	opts := &github.RepositoryContentGetOptions{Ref: params.Ref}
+	file, _, _, err := client.Repositories.GetContents(ctx, params.Owner, params.Repo, path, opts)
+	if err != nil {
+		if !isGithubNotFound(err) {
+			log.Printf("Error %s occurred fetching file %s with params %v", err, path, params)
+		}
+		return nil, err
+	}
+	if file == nil {
+		return nil, fmt.Errorf("%s is not a file", path)
+	}
+
+	content, err := file.GetContent()
+	return []byte(content), err
+}
+
+// CommitSHA returns the hash of the commit the given ref e.g a tag points to
+func (client *GithubClient) CommitSHA(params RequestParams, ref string) (string, error) {
+	sha, _, err := client.Repositories.GetCommitSHA1(ctx, params.Owner, params.Repo, ref, "")
 	if err != nil {
-		log.Println("Error fetching users repo: ", err)
+		log.Printf("Error %s occurred fetching commit for ref %s with params %v", err, ref, params)
 	}
+	return sha, err
+}
 
-	return repos
+// BranchHeads returns the commits the first 100 branches of the repo point to, keyed by branch
+// Github doesn't count the conditional requests answered with 304 Not Modified against the rate limit
+// The poller calls it, so the request is given up after a minute rather than holding up the polling
+func (client *GithubClient) BranchHeads(params RequestParams, etag string) (map[string]string, string, error) {
+	u := fmt.Sprintf("repos/%v/%v/branches?per_page=100", params.Owner, params.Repo)
+	req, err := client.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	var branches []*github.Branch
+	resp, err := client.Do(reqCtx, req, &branches)
+	if resp != nil && resp.StatusCode == http.StatusNotModified {
+		return nil, etag, ErrNotModified
+	}
+	if err != nil {
+		log.Printf("Error %s occurred fetching branches with params %v", err, params)
+		return nil, "", err
+	}
+
+	heads := map[string]string{}
+	for _, branch := range branches {
+		heads[branch.GetName()] = branch.GetCommit().GetSHA()
+	}
+	return heads, resp.Header.Get("ETag"), nil
+}
+
+// isGithubNotFound returns true if the error is a github API not found error
+func isGithubNotFound(err error) bool {
+	errResp, ok := err.(*github.ErrorResponse)
+	return ok && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound
+}
+
+// Login returns the login of the user owning the access token used for the github client
+func (client *GithubClient) Login() (string, error) {
+	user, _, err := client.Users.Get(ctx, "")
+	if err != nil {
+		log.Println("Error fetching user: ", err)
+		return "", err
+	}
+	return user.GetLogin(), nil
 }
 
 // Repo fetches and returns the github repo with the given params
-func (client *GithubClient) Repo(params GithubRequestParams) (repo *github.Repository, err error) {
-	repo, _, err = client.Repositories.Get(ctx, params.Owner, params.Repo)
+func (client *GithubClient) Repo(params RequestParams) (*Repo, error) {
+	repo, _, err := client.Repositories.Get(ctx, params.Owner, params.Repo)
 	if err != nil {
 		log.Printf("Error %s occurred fetching repo with params %v", err, params)
+		return nil, err
+	}
+	r := githubRepo(repo, client.sshHost)
+	return &r, nil
+}
+
+// githubRepo returns the Repo of the github repo, cloned from the given SSH host if set
+func githubRepo(repo *github.Repository, sshHost string) Repo {
+	return Repo{
+		Owner:         repo.GetOwner().GetLogin(),
+		Name:          repo.GetName(),
+		FullName:      repo.GetFullName(),
+		Language:      repo.GetLanguage(),
+		URL:           repo.GetHTMLURL(),
+		CloneURL:      githubCloneURL(repo.GetSSHURL(), sshHost),
+		DefaultBranch: repo.GetDefaultBranch(),
 	}
-	return
 }
 
 // IsRepoSubscribed checks if the given repo has the sicuro webhook set
-func (client *GithubClient) IsRepoSubscribed(params GithubRequestParams) bool {
-	ctx := ctx
-	hooks, _, err := client.Repositories.ListHooks(ctx, params.Owner, params.Repo, &github.ListOptions{})
+// The hooks are listed from the cache if they haven't changed since they were last listed
+// It's a low priority call, the dashboard lists the subscriptions of all the user's repos
+func (client *GithubClient) IsRepoSubscribed(params RequestParams) bool {
+	for page := 1; page != 0; {
+		hooks := []*github.Hook{}
+		next, err := client.getCached(lowPriority, fmt.Sprintf("repos/%v/%v/hooks?per_page=100&page=%d", params.Owner, params.Repo, page), &hooks)
+		if err != nil {
+			log.Printf("Error %s occurred checking repo subscription status with params %v", err, params)
+			return false
+		}
+
+		for _, hook := range hooks {
+			if hasActiveWebhook(hook, params.CallbackURL) {
+				return true
+			}
+		}
+		page = next
+	}
+
+	return false
+}
 
+// Unsubscribe deletes the sicuro webhooks of the given repo
+func (client *GithubClient) Unsubscribe(params RequestParams) error {
+	hooks, err := client.sicuroHooks(params)
 	if err != nil {
-		log.Printf("Error %s occurred checking repo subscription status with params %v", err, params)
-		return false
+		return err
 	}
 
 	for _, hook := range hooks {
-		if hasActiveWebhook(hook, params.CallbackURL) {
-			return true
+		if _, err := client.Repositories.DeleteHook(ctx, params.Owner, params.Repo, hook.GetID()); err != nil && !isGithubNotFound(err) {
+			log.Printf("Error %s occurred deleting webhook with params %v", err, params)
+			return err
 		}
 	}
-
-	return false
+	return nil
 }
 
+// hasActiveWebhook returns true if the hook is an active sicuro webhook
+// Its events, content type and deliveries are checked by WebhookHealth
 func hasActiveWebhook(hook *github.Hook, webhookPath string) bool {
-	if !*(hook.Active) {
+	if !hook.GetActive() {
 		return false
 	}
 
@@ -148,6 +318,5 @@ func hasActiveWebhook(hook *github.Hook, webhookPath string) bool {
 		return false
 	}
 
-	// TODO: also check that the webhook is subscribed to the right events => ["pull_request" "push"]
 	return true
 }
@@ -0,0 +1,218 @@
+package vcs
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// githubAPIURL is the API the Github clients call when no other is set
+	githubAPIURL = "https://api.github.com"
+
+	// githubTokenRefresh is how long before their expiry the installation tokens are renewed,
+	// so that the jobs they are handed to can still clone with them
+	githubTokenRefresh = 10 * time.Minute
+)
+
+// GithubApp is the Github App the server runs as
+// The app signs JWTs with its private key to mint the tokens of its installations,
+// which are used for the statuses, the webhooks and the clones of the repos they are installed on
+type GithubApp struct {
+	// ID is the ID of the app shown on its settings page
+	ID  string
+	key *rsa.PrivateKey
+	// APIURL is the URL of the Github API the app calls
+	APIURL string
+
+	// mu guards installations, tokens, cloneTokens and botLogin
+	mu sync.Mutex
+	// botLogin is the login of the app's bot user, once looked up
+	botLogin string
+	// installations are the IDs of the installations of the repos, keyed by the full name of the repo
+	installations map[string]int64
+	// tokens are the tokens of the installations, keyed by installation ID
+	tokens map[int64]installationToken
+	// cloneTokens are the read-only tokens of the repos, keyed by the full name of the repo
+	cloneTokens map[string]installationToken
+}
+
+type installationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewGithubApp creates a new GithubApp with the given ID and the PEM encoded RSA private key at the key path
+// The API URL defaults to the Github API
+func NewGithubApp(id, keyPath, apiURL string) (*GithubApp, error) {
+	data, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseRSAPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key %s: %s", keyPath, err)
+	}
+
+	if apiURL == "" {
+		apiURL = githubAPIURL
+	}
+	return &GithubApp{
+		ID:            id,
+		key:           key,
+		APIURL:        strings.TrimSuffix(apiURL, "/"),
+		installations: map[string]int64{},
+		tokens:        map[int64]installationToken{},
+		cloneTokens:   map[string]installationToken{},
+	}, nil
+}
+
+// parseRSAPrivateKey parses a PEM encoded PKCS #1 or PKCS #8 RSA private key
+// Github generates PKCS #1 keys, the PKCS #8 ones are the keys converted by openssl 3
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// JWT returns a JSON Web Token the app authenticates with, signed with RS256
+// It's issued a minute in the past to allow for clock drift, and expires after 10 minutes, the most Github allows
+func (app *GithubApp) JWT() (string, error) {
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": app.ID,
+	})
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, app.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// BotLogin returns the login of the bot user the app's installations post as i.e the app's slug followed by [bot]
+func (app *GithubApp) BotLogin() (string, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if app.botLogin != "" {
+		return app.botLogin, nil
+	}
+
+	jwt, err := app.JWT()
+	if err != nil {
+		return "", err
+	}
+	info := struct {
+		Slug string `json:"slug"`
+	}{}
+	if err := newRESTClient(app.APIURL, jwt).do("GET", "/app", nil, nil, &info); err != nil {
+		return "", err
+	}
+	app.botLogin = info.Slug + "[bot]"
+	return app.botLogin, nil
+}
+
+// InstallationToken returns a token of the app's installation on the repo
+// It has all the permissions of the installation on all its repos, so it's only used by the server
+// The tokens are cached until shortly before they expire
+// The error is a not found error if the app is not installed on the repo
+func (app *GithubApp) InstallationToken(owner, repo string) (string, error) {
+	return app.token(owner, repo, false)
+}
+
+// CloneToken returns a token of the app's installation that can only read the contents of the repo
+// It's the token the test containers clone the repo with, as they run the code of the pull requests
+// The tokens are cached until shortly before they expire
+// The error is a not found error if the app is not installed on the repo
+func (app *GithubApp) CloneToken(owner, repo string) (string, error) {
+	return app.token(owner, repo, true)
+}
+
+// token returns a token of the app's installation on the repo, restricted to reading its contents if clone is set
+func (app *GithubApp) token(owner, repo string, clone bool) (string, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	fullName := owner + "/" + repo
+	id, ok := app.installations[fullName]
+	if ok {
+		token, cached := app.tokens[id]
+		if clone {
+			token, cached = app.cloneTokens[fullName]
+		}
+		if cached && time.Until(token.ExpiresAt) > githubTokenRefresh {
+			return token.Token, nil
+		}
+	}
+
+	jwt, err := app.JWT()
+	if err != nil {
+		return "", err
+	}
+	client := newRESTClient(app.APIURL, jwt)
+
+	if !ok {
+		installation := struct {
+			ID int64 `json:"id"`
+		}{}
+		path := "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(repo) + "/installation"
+		if err := client.do("GET", path, nil, nil, &installation); err != nil {
+			return "", err
+		}
+		id = installation.ID
+		app.installations[fullName] = id
+	}
+
+	var body interface{}
+	if clone {
+		body = map[string]interface{}{
+			"repositories": []string{repo},
+			"permissions":  map[string]string{"contents": "read"},
+		}
+	}
+	token := installationToken{}
+	if err := client.do("POST", fmt.Sprintf("/app/installations/%d/access_tokens", id), nil, body, &token); err != nil {
+		if isAPINotFound(err) {
+			// the app was uninstalled, the repo may have been added to another installation since
+			delete(app.installations, fullName)
+		}
+		return "", err
+	}
+	if clone {
+		app.cloneTokens[fullName] = token
+	} else {
+		app.tokens[id] = token
+	}
+	return token.Token, nil
+}
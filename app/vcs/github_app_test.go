@@ -0,0 +1,138 @@
+package vcs
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeGithubApp returns a GithubApp calling a fake Github API, which mints a token for each access token request
+// The bodies of the access token requests are sent to the returned channel
+func fakeGithubApp(t *testing.T, expiresIn time.Duration) (*GithubApp, chan map[string]interface{}) {
+	bodies := make(chan map[string]interface{}, 10)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/owner/repo/installation", func(w http.ResponseWriter, r *http.Request) {
+		if parts := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), "."); len(parts) != 3 {
+			t.Errorf("the installation is looked up without a JWT: %q", r.Header.Get("Authorization"))
+		}
+		w.Write([]byte(`{"id": 42}`))
+	})
+	mux.HandleFunc("/app", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"slug": "sicuro-ci"}`))
+	})
+	mux.HandleFunc("/app/installations/42/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("access tokens are minted with %s", r.Method)
+		}
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies <- body
+
+		token := "full-token"
+		if _, ok := body["repositories"]; ok {
+			token = "clone-token"
+		}
+		json.NewEncoder(w).Encode(installationToken{Token: token, ExpiresAt: time.Now().Add(expiresIn)})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := ioutil.TempDir("", "githubapp")
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	keyPath := filepath.Join(dir, "app.pem")
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(keyPath, pemKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := NewGithubApp("1", keyPath, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app, bodies
+}
+
+func TestGithubAppCloneToken(t *testing.T) {
+	app, bodies := fakeGithubApp(t, time.Hour)
+
+	token, err := app.CloneToken("owner", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "clone-token" {
+		t.Errorf("got token %q, want the clone token", token)
+	}
+	body := <-bodies
+	if repos, _ := body["repositories"].([]interface{}); len(repos) != 1 || repos[0] != "repo" {
+		t.Errorf("the clone token is not restricted to the repo: %v", body["repositories"])
+	}
+	if permissions, _ := body["permissions"].(map[string]interface{}); len(permissions) != 1 || permissions["contents"] != "read" {
+		t.Errorf("the clone token is not restricted to reading the contents: %v", body["permissions"])
+	}
+
+	token, err = app.InstallationToken("owner", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "full-token" {
+		t.Errorf("got token %q, want the installation token", token)
+	}
+	if body := <-bodies; len(body) != 0 {
+		t.Errorf("the installation token is restricted: %v", body)
+	}
+
+	// both tokens are cached
+	app.CloneToken("owner", "repo")
+	app.InstallationToken("owner", "repo")
+	if len(bodies) != 0 {
+		t.Errorf("%d tokens were minted again", len(bodies))
+	}
+}
+
+func TestGithubAppTokenRefresh(t *testing.T) {
+	app, bodies := fakeGithubApp(t, githubTokenRefresh/2)
+
+	for i := 0; i < 2; i++ {
+		if _, err := app.CloneToken("owner", "repo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(bodies) != 2 {
+		t.Errorf("minted %d tokens, want the token close to its expiry minted again", len(bodies))
+	}
+}
+
+func TestGithubAppBotLogin(t *testing.T) {
+	app, _ := fakeGithubApp(t, time.Hour)
+
+	login, err := app.BotLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login != "sicuro-ci[bot]" {
+		t.Errorf("got login %q, want the app's bot user", login)
+	}
+}
+
+func TestGithubAppNotInstalled(t *testing.T) {
+	app, _ := fakeGithubApp(t, time.Hour)
+
+	_, err := app.CloneToken("owner", "other")
+	if !isAPINotFound(err) {
+		t.Errorf("got error %v, want a not found error", err)
+	}
+}
@@ -0,0 +1,101 @@
+package vcs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// githubCacheExpiry is how long the responses that are not requested again are kept
+	githubCacheExpiry = 24 * time.Hour
+	// maxGithubCacheResponses is the most responses kept, the least recently requested ones are dropped first
+	maxGithubCacheResponses = 5000
+)
+
+// githubCachedResponse is a response of a list request, kept to revalidate it with its ETag
+type githubCachedResponse struct {
+	etag     string
+	body     json.RawMessage
+	nextPage int
+	// usedAt is when the response was last requested
+	usedAt time.Time
+}
+
+// githubCache holds the responses of the list requests of the Github clients,
+// keyed by the hash of the client's token and the request URL
+// Github doesn't count the requests answered with 304 Not Modified against the rate limit
+var githubCache = struct {
+	sync.Mutex
+	responses map[string]githubCachedResponse
+}{responses: map[string]githubCachedResponse{}}
+
+// cacheGithubResponse adds the response to the cache, dropping the expired responses and
+// the least recently requested ones over maxGithubCacheResponses
+func cacheGithubResponse(key string, response githubCachedResponse) {
+	githubCache.Lock()
+	defer githubCache.Unlock()
+
+	githubCache.responses[key] = response
+	if len(githubCache.responses) <= maxGithubCacheResponses {
+		return
+	}
+
+	keys := make([]string, 0, len(githubCache.responses))
+	for k, cached := range githubCache.responses {
+		if time.Since(cached.usedAt) > githubCacheExpiry {
+			delete(githubCache.responses, k)
+			continue
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) <= maxGithubCacheResponses {
+		return
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return githubCache.responses[keys[i]].usedAt.Before(githubCache.responses[keys[j]].usedAt)
+	})
+	for _, k := range keys[:len(keys)-maxGithubCacheResponses] {
+		delete(githubCache.responses, k)
+	}
+}
+
+// getCached sends a GET request to the API URL, conditional on the ETag of its cached response if any,
+// and decodes the response, or the cached one if it's not modified, into out
+// It returns the next page of the listed resources, or 0 if it's the last page
+// reqCtx sets the priority of the request e.g lowPriority
+func (client *GithubClient) getCached(reqCtx context.Context, u string, out interface{}) (int, error) {
+	key := client.tokenHash + " " + u
+	githubCache.Lock()
+	cached, ok := githubCache.responses[key]
+	if ok {
+		cached.usedAt = time.Now()
+		githubCache.responses[key] = cached
+	}
+	githubCache.Unlock()
+
+	req, err := client.NewRequest("GET", u, nil)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	var body json.RawMessage
+	resp, err := client.Do(reqCtx, req, &body)
+	if ok && resp != nil && resp.StatusCode == http.StatusNotModified {
+		return cached.nextPage, json.Unmarshal(cached.body, out)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		cacheGithubResponse(key, githubCachedResponse{etag: etag, body: body, nextPage: resp.NextPage, usedAt: time.Now()})
+	}
+	return resp.NextPage, json.Unmarshal(body, out)
+}
@@ -0,0 +1,116 @@
+package vcs
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"newproj/ci"
+)
+
+// githubCheckRun is the body of the requests creating and updating a check run
+// go-github predates the current annotation fields of the Checks API, so the requests are made with these
+type githubCheckRun struct {
+	ID          int64                 `json:"id,omitempty"`
+	Name        string                `json:"name,omitempty"`
+	HeadSHA     string                `json:"head_sha,omitempty"`
+	DetailsURL  string                `json:"details_url,omitempty"`
+	Status      string                `json:"status,omitempty"`
+	Conclusion  string                `json:"conclusion,omitempty"`
+	StartedAt   *time.Time            `json:"started_at,omitempty"`
+	CompletedAt *time.Time            `json:"completed_at,omitempty"`
+	Output      *githubCheckRunOutput `json:"output,omitempty"`
+}
+
+type githubCheckRunOutput struct {
+	Title       string                  `json:"title"`
+	Summary     string                  `json:"summary"`
+	Annotations []githubCheckAnnotation `json:"annotations,omitempty"`
+}
+
+type githubCheckAnnotation struct {
+	Path            string `json:"path"`
+	StartLine       int    `json:"start_line"`
+	EndLine         int    `json:"end_line"`
+	AnnotationLevel string `json:"annotation_level"`
+	Title           string `json:"title,omitempty"`
+	Message         string `json:"message"`
+}
+
+// githubCheckConclusion returns the check run conclusion of the given build status
+func githubCheckConclusion(status string) string {
+	switch status {
+	case "success", ci.BuildFlaky:
+		return "success"
+	case ci.BuildCanceled:
+		return "cancelled"
+	case ci.BuildSkipped:
+		return "skipped"
+	}
+	return "failure"
+}
+
+// UpdateCheckRun returns a function that when executed creates the SicuroCI check run of the commit,
+// then moves it through the statuses of the given check runs
+// Only Github Apps can create check runs, so it returns nil for the clients with other tokens
+func (client *GithubClient) UpdateCheckRun(params RequestParams) func(*ci.CheckRun) {
+	if !client.app {
+		return nil
+	}
+
+	// the check run is created by the first update, the job reports its updates one at a time
+	var id int64
+	return func(run *ci.CheckRun) {
+		body := githubCheckRun{
+			Name:       "SicuroCI",
+			HeadSHA:    params.Ref,
+			DetailsURL: params.CallbackURL,
+			Status:     run.Status,
+		}
+		if !run.StartedAt.IsZero() {
+			body.StartedAt = &run.StartedAt
+		}
+		if run.Status == ci.CheckCompleted {
+			body.Conclusion = githubCheckConclusion(run.Conclusion)
+			completedAt := run.CompletedAt
+			if completedAt.IsZero() {
+				completedAt = time.Now()
+			}
+			body.CompletedAt = &completedAt
+		}
+		if run.Title != "" {
+			body.Output = &githubCheckRunOutput{Title: run.Title, Summary: run.Summary}
+			for _, a := range run.Annotations {
+				body.Output.Annotations = append(body.Output.Annotations, githubCheckAnnotation{
+					Path:            a.Path,
+					StartLine:       a.Line,
+					EndLine:         a.Line,
+					AnnotationLevel: "failure",
+					Title:           a.Title,
+					Message:         a.Message,
+				})
+			}
+		}
+
+		method, u := "POST", fmt.Sprintf("repos/%v/%v/check-runs", params.Owner, params.Repo)
+		if id != 0 {
+			method, u = "PATCH", fmt.Sprintf("%s/%d", u, id)
+		}
+		req, err := client.NewRequest(method, u, body)
+		if err != nil {
+			log.Println("Error occurred while updating check run on the project: ", err)
+			return
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+
+		created := githubCheckRun{}
+		if _, err := client.Do(ctx, req, &created); err != nil {
+			log.Println("Error occurred while updating check run on the project: ", err)
+			return
+		}
+		if id == 0 {
+			id = created.ID
+		}
+		log.Println("Successfully update project check run to:", run.Status, run.Conclusion)
+	}
+}
@@ -0,0 +1,153 @@
+package vcs
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/github"
+)
+
+// pullRequestCommentMarker tags the sicuro comment of a pull request, so that it's updated instead of posting another
+const pullRequestCommentMarker = "<!-- sicuro-ci build summary -->"
+
+// UpdatePullRequestComment returns a function that when executed sets the sicuro comment of the pull request
+// with the given number to the given markdown. The comment is posted by the first update, the later ones edit it
+func (client *GithubClient) UpdatePullRequestComment(params RequestParams, number int) func(string) {
+	var id int64
+	return func(body string) {
+		if id == 0 {
+			author, err := client.commentAuthor()
+			if err != nil {
+				log.Printf("Error %s occurred looking up the user commenting on pull request #%d with params %v", err, number, params)
+				return
+			}
+			comment, err := client.pullRequestComment(params, number, author)
+			if err != nil {
+				log.Printf("Error %s occurred listing the comments of pull request #%d with params %v", err, number, params)
+				return
+			}
+			if comment != nil {
+				id = comment.GetID()
+			}
+		}
+
+		comment := &github.IssueComment{Body: github.String(pullRequestCommentMarker + "\n" + body)}
+		if id != 0 {
+			_, _, err := client.Issues.EditComment(ctx, params.Owner, params.Repo, id, comment)
+			if err == nil {
+				log.Printf("Successfully updated the comment of pull request #%d", number)
+				return
+			}
+			if !isGithubNotFound(err) {
+				log.Printf("Error %s occurred updating the comment of pull request #%d with params %v", err, number, params)
+				return
+			}
+			// the comment has been deleted, another one is posted
+		}
+
+		created, _, err := client.Issues.CreateComment(ctx, params.Owner, params.Repo, number, comment)
+		if err != nil {
+			log.Printf("Error %s occurred commenting on pull request #%d with params %v", err, number, params)
+			return
+		}
+		id = created.GetID()
+		log.Printf("Successfully commented on pull request #%d", number)
+	}
+}
+
+// commentAuthor returns the login the client comments as, the app's bot user for the installation clients
+func (client *GithubClient) commentAuthor() (string, error) {
+	if client.botLogin != "" {
+		return client.botLogin, nil
+	}
+	return client.Login()
+}
+
+// pullRequestComment returns the sicuro comment of the pull request posted by the given author, or nil if it has none
+// The comments of the other users are left out, even with the marker, as the client can't edit them
+func (client *GithubClient) pullRequestComment(params RequestParams, number int, author string) (*github.IssueComment, error) {
+	opt := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := client.Issues.ListComments(ctx, params.Owner, params.Repo, number, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, comment := range comments {
+			if strings.HasPrefix(comment.GetBody(), pullRequestCommentMarker) && strings.EqualFold(comment.GetUser().GetLogin(), author) {
+				return comment, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// HasWriteAccess returns true if the user with the given login has the write, maintain or admin role on the repo
+func (client *GithubClient) HasWriteAccess(params RequestParams, login string) (bool, error) {
+	level, _, err := client.Repositories.GetPermissionLevel(ctx, params.Owner, params.Repo, login)
+	if err != nil {
+		log.Printf("Error %s occurred checking the permission of %s with params %v", err, login, params)
+		return false, err
+	}
+	switch level.GetPermission() {
+	case "admin", "write":
+		return true, nil
+	}
+	return false, nil
+}
+
+// PullRequest returns the pull request event of the pull request's current head commit, built from its merge ref
+func (client *GithubClient) PullRequest(params RequestParams, number int) (*Event, error) {
+	pr, _, err := client.PullRequests.Get(ctx, params.Owner, params.Repo, number)
+	if err != nil {
+		log.Printf("Error %s occurred fetching pull request #%d with params %v", err, number, params)
+		return nil, err
+	}
+	if pr.GetHead().GetRepo().GetFullName() != pr.GetBase().GetRepo().GetFullName() {
+		return nil, ErrForkPullRequest
+	}
+	return &Event{
+		Type:        EventPullRequest,
+		Commit:      pr.GetHead().GetSHA(),
+		Ref:         fmt.Sprintf("refs/pull/%d/merge", number),
+		Branch:      pr.GetHead().GetRef(),
+		BaseBranch:  pr.GetBase().GetRef(),
+		PullRequest: number,
+		Repo:        githubRepo(pr.GetBase().GetRepo(), client.sshHost),
+	}, nil
+}
+
+// ReactToComment adds the given reaction to the comment, go-github predates creating reactions
+func (client *GithubClient) ReactToComment(params RequestParams, comment *Comment, reaction string) error {
+	u := fmt.Sprintf("repos/%v/%v/comments/%d/reactions", params.Owner, params.Repo, comment.ID)
+	if comment.PullRequest != 0 {
+		u = fmt.Sprintf("repos/%v/%v/issues/comments/%d/reactions", params.Owner, params.Repo, comment.ID)
+	}
+	req, err := client.NewRequest("POST", u, map[string]string{"content": reaction})
+	if err == nil {
+		req.Header.Set("Accept", "application/vnd.github+json")
+		_, err = client.Do(ctx, req, nil)
+	}
+	if err != nil {
+		log.Printf("Error %s occurred reacting to comment %d with params %v", err, comment.ID, params)
+	}
+	return err
+}
+
+// ReplyToComment posts the given markdown on the pull request or the commit of the comment, quoting it
+func (client *GithubClient) ReplyToComment(params RequestParams, comment *Comment, body string) error {
+	body = fmt.Sprintf("> %s\n\n@%s %s", strings.Replace(strings.TrimSpace(comment.Body), "\n", "\n> ", -1), comment.Author, body)
+	var err error
+	if comment.PullRequest != 0 {
+		_, _, err = client.Issues.CreateComment(ctx, params.Owner, params.Repo, comment.PullRequest, &github.IssueComment{Body: github.String(body)})
+	} else {
+		_, _, err = client.Repositories.CreateComment(ctx, params.Owner, params.Repo, comment.Commit, &github.RepositoryComment{Body: github.String(body)})
+	}
+	if err != nil {
+		log.Printf("Error %s occurred replying to comment %d with params %v", err, comment.ID, params)
+	}
+	return err
+}
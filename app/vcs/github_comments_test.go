@@ -0,0 +1,117 @@
+package vcs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeCommentsServer is a fake Github API with the comments of pull request #1 of owner/repo
+// The signed in user is sicuro, and the comment requests are recorded as the method followed by the path
+type fakeCommentsServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	comments []map[string]interface{}
+	requests []string
+}
+
+func newFakeCommentsServer(t *testing.T, comments ...map[string]interface{}) *fakeCommentsServer {
+	s := &fakeCommentsServer{comments: comments}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"login": "sicuro"}`))
+	})
+	mux.HandleFunc("/repos/owner/repo/issues/1/comments", func(w http.ResponseWriter, r *http.Request) {
+		s.record(r)
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode(s.comments)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": 100}`))
+	})
+	mux.HandleFunc("/repos/owner/repo/issues/comments/", func(w http.ResponseWriter, r *http.Request) {
+		s.record(r)
+		w.Write([]byte(`{}`))
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeCommentsServer) record(r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Method != "GET" {
+		s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	}
+}
+
+func comment(id int, login, body string) map[string]interface{} {
+	return map[string]interface{}{"id": id, "body": body, "user": map[string]string{"login": login}}
+}
+
+func TestUpdatePullRequestComment(t *testing.T) {
+	tests := []struct {
+		name     string
+		comments []map[string]interface{}
+		want     []string
+	}{
+		{
+			name: "no comment",
+			want: []string{"POST /repos/owner/repo/issues/1/comments", "PATCH /repos/owner/repo/issues/comments/100"},
+		},
+		{
+			name:     "own comment",
+			comments: []map[string]interface{}{comment(7, "sicuro", pullRequestCommentMarker+"\nold")},
+			want:     []string{"PATCH /repos/owner/repo/issues/comments/7", "PATCH /repos/owner/repo/issues/comments/7"},
+		},
+		{
+			name: "marker posted by another user",
+			comments: []map[string]interface{}{
+				comment(5, "mallory", pullRequestCommentMarker+"\nfake"),
+				comment(7, "sicuro", pullRequestCommentMarker+"\nold"),
+			},
+			want: []string{"PATCH /repos/owner/repo/issues/comments/7", "PATCH /repos/owner/repo/issues/comments/7"},
+		},
+		{
+			name:     "only another user's marker",
+			comments: []map[string]interface{}{comment(5, "mallory", pullRequestCommentMarker+"\nfake")},
+			want:     []string{"POST /repos/owner/repo/issues/1/comments", "PATCH /repos/owner/repo/issues/comments/100"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeCommentsServer(t, tt.comments...)
+			client := newGithubClient("token", server.URL, "")
+
+			update := client.UpdatePullRequestComment(RequestParams{Owner: "owner", Repo: "repo"}, 1)
+			update("first build")
+			update("second build")
+
+			if fmt.Sprint(server.requests) != fmt.Sprint(tt.want) {
+				t.Errorf("got requests %v, want %v", server.requests, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdatePullRequestCommentAsApp(t *testing.T) {
+	server := newFakeCommentsServer(t,
+		comment(5, "sicuro", pullRequestCommentMarker+"\nposted with the user token"),
+		comment(7, "sicuro-ci[bot]", pullRequestCommentMarker+"\nold"),
+	)
+	client := newGithubClient("token", server.URL, "")
+	client.app = true
+	client.botLogin = "sicuro-ci[bot]"
+
+	client.UpdatePullRequestComment(RequestParams{Owner: "owner", Repo: "repo"}, 1)("build")
+	if len(server.requests) != 1 || !strings.HasSuffix(server.requests[0], "/comments/7") {
+		t.Errorf("got requests %v, want the bot's comment edited", server.requests)
+	}
+}
@@ -0,0 +1,132 @@
+package vcs
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/go-github/github"
+)
+
+// githubHookEvents are the events the sicuro webhooks are subscribed to
+var githubHookEvents = []string{"push", "pull_request", "release", "issue_comment", "commit_comment"}
+
+// githubHookDeliveriesLimit is how many of the recent deliveries of a webhook are listed
+const githubHookDeliveriesLimit = 25
+
+// githubHookDelivery is a delivery of a repo webhook, go-github predates the deliveries API
+type githubHookDelivery struct {
+	GUID        string    `json:"guid"`
+	DeliveredAt time.Time `json:"delivered_at"`
+	Status      string    `json:"status"`
+	StatusCode  int       `json:"status_code"`
+	Event       string    `json:"event"`
+	Action      string    `json:"action"`
+}
+
+// newGithubHook returns the settings of the sicuro webhook of the repo
+func newGithubHook(params RequestParams) *github.Hook {
+	return &github.Hook{
+		Active: github.Bool(true),
+		Events: githubHookEvents,
+		Config: map[string]interface{}{
+			"content_type": "json",
+			"url":          params.CallbackURL,
+			"secret":       params.Creds,
+			"insecure_ssl": "0",
+		},
+	}
+}
+
+// sicuroHooks returns the webhooks of the repo delivering to the params callback URL, the active ones first
+func (client *GithubClient) sicuroHooks(params RequestParams) ([]*github.Hook, error) {
+	active, inactive := []*github.Hook{}, []*github.Hook{}
+	for page := 1; page != 0; {
+		hooks := []*github.Hook{}
+		next, err := client.getCached(ctx, fmt.Sprintf("repos/%v/%v/hooks?per_page=100&page=%d", params.Owner, params.Repo, page), &hooks)
+		if err != nil {
+			log.Printf("Error %s occurred listing webhooks with params %v", err, params)
+			return nil, err
+		}
+
+		for _, hook := range hooks {
+			if hasActiveWebhook(hook, params.CallbackURL) {
+				active = append(active, hook)
+			} else if hook.Config["url"] == params.CallbackURL {
+				inactive = append(inactive, hook)
+			}
+		}
+		page = next
+	}
+	return append(active, inactive...), nil
+}
+
+// WebhookHealth returns the state of the sicuro webhook of the repo, with its recent deliveries
+// Github masks the secret of the webhook, only whether one is set is reported
+func (client *GithubClient) WebhookHealth(params RequestParams) (*WebhookHealth, error) {
+	hooks, err := client.sicuroHooks(params)
+	if err != nil {
+		return nil, err
+	}
+	health := &WebhookHealth{Hooks: len(hooks)}
+	if len(hooks) == 0 {
+		return health, nil
+	}
+
+	hook := hooks[0]
+	secret, _ := hook.Config["secret"].(string)
+	health.Found, health.Active, health.Events = true, hook.GetActive(), hook.Events
+	health.MissingEvents = missingEvents(hook.Events, githubHookEvents)
+	health.ContentType, _ = hook.Config["content_type"].(string)
+	health.HasSecret = secret != ""
+
+	u := fmt.Sprintf("repos/%v/%v/hooks/%d/deliveries?per_page=%d", params.Owner, params.Repo, hook.GetID(), githubHookDeliveriesLimit)
+	req, err := client.NewRequest("GET", u, nil)
+	deliveries := []githubHookDelivery{}
+	if err == nil {
+		_, err = client.Do(ctx, req, &deliveries)
+	}
+	if err != nil {
+		// the older Github Enterprise servers don't report the deliveries
+		log.Printf("Error %s occurred listing webhook deliveries with params %v", err, params)
+		return health, nil
+	}
+
+	health.Deliveries = []HookDelivery{}
+	for _, d := range deliveries {
+		event := d.Event
+		if d.Action != "" {
+			event += "." + d.Action
+		}
+		delivery := HookDelivery{ID: d.GUID, Event: event, DeliveredAt: d.DeliveredAt, StatusCode: d.StatusCode, Status: d.Status}
+		if !delivery.Failed() && health.LastSuccess.IsZero() {
+			health.LastSuccess = d.DeliveredAt
+		}
+		health.Deliveries = append(health.Deliveries, delivery)
+	}
+	return health, nil
+}
+
+// RepairWebhook sets the sicuro webhook of the repo back to the events, content type and secret it's created with
+// The first webhook is updated and the duplicates are deleted, or the webhook is created if the repo has none
+func (client *GithubClient) RepairWebhook(params RequestParams) error {
+	hooks, err := client.sicuroHooks(params)
+	if err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		return client.Subscribe(params)
+	}
+
+	if _, _, err := client.Repositories.EditHook(ctx, params.Owner, params.Repo, hooks[0].GetID(), newGithubHook(params)); err != nil {
+		log.Printf("Error %s occurred while updating webhook with params %v", err, params)
+		return err
+	}
+	for _, hook := range hooks[1:] {
+		if _, err := client.Repositories.DeleteHook(ctx, params.Owner, params.Repo, hook.GetID()); err != nil && !isGithubNotFound(err) {
+			log.Printf("Error %s occurred deleting webhook with params %v", err, params)
+			return err
+		}
+	}
+	return nil
+}
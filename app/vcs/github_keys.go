@@ -0,0 +1,31 @@
+package vcs
+
+import (
+	"log"
+
+	"github.com/google/go-github/github"
+)
+
+// AddDeployKey registers the given public key as a read-only deploy key of the repo, and returns its ID
+func (client *GithubClient) AddDeployKey(params RequestParams, title, publicKey string) (int64, error) {
+	key := &github.Key{
+		Title:    github.String(title),
+		Key:      github.String(publicKey),
+		ReadOnly: github.Bool(true),
+	}
+	created, _, err := client.Repositories.CreateKey(ctx, params.Owner, params.Repo, key)
+	if err != nil {
+		log.Printf("Error %s occurred adding deploy key with params %v", err, params)
+		return 0, err
+	}
+	return created.GetID(), nil
+}
+
+// RemoveDeployKey removes the deploy key with the given ID from the repo, if it's still there
+func (client *GithubClient) RemoveDeployKey(params RequestParams, id int64) error {
+	if _, err := client.Repositories.DeleteKey(ctx, params.Owner, params.Repo, id); err != nil && !isGithubNotFound(err) {
+		log.Printf("Error %s occurred removing deploy key with params %v", err, params)
+		return err
+	}
+	return nil
+}
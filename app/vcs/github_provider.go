@@ -0,0 +1,435 @@
+package vcs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"gopkg.in/go-playground/webhooks.v3/github"
+	githubhook "gopkg.in/rjz/githubhook.v0"
+)
+
+const (
+	// GithubID is the ID of the Github provider
+	GithubID = "gh"
+
+	githubAuthorizeURL = "https://github.com/login/oauth/authorize"
+	githubTokenURL     = "https://github.com/login/oauth/access_token"
+)
+
+// GithubProvider is the Github Provider
+type GithubProvider struct {
+	ClientID     string
+	ClientSecret string
+	// WebhookSecret is the secret the webhook requests are signed with
+	WebhookSecret string
+	// Token is the server's token, used for offline actions such as build status updates
+	Token string
+	// App is the Github App the server runs as, if set
+	// The tokens of its installations are used instead of the server's token for the repos it's installed on
+	App *GithubApp
+	// APIURL is the URL of the Github API the clients call e.g of a Github Enterprise server, or a fake API server in tests
+	APIURL string
+	// UploadURL is the URL of the Github uploads API the clients call e.g of a Github Enterprise server
+	UploadURL string
+	// AuthURL and TokenURL are the OAuth endpoints users sign in with, they default to github.com's
+	AuthURL  string
+	TokenURL string
+	// SSHHost is the host the repos are cloned from over SSH, with its port if it's not 22, if it's not the host
+	// of their SSH urls e.g when the SSH service of a Github Enterprise server has its own host name
+	SSHHost string
+}
+
+// NewGithubProvider creates a new GithubProvider with the given OAuth app credentials,
+// webhook secret and server token
+func NewGithubProvider(clientID, clientSecret, webhookSecret, token string) *GithubProvider {
+	return &GithubProvider{
+		ClientID:      clientID,
+		ClientSecret:  clientSecret,
+		WebhookSecret: webhookSecret,
+		Token:         token,
+	}
+}
+
+// SetEnterpriseURL points the API, uploads API and OAuth endpoints of the provider
+// at the Github Enterprise server at the given URL e.g https://github.example.com
+func (p *GithubProvider) SetEnterpriseURL(baseURL string) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	p.APIURL = baseURL + "/api/v3/"
+	p.UploadURL = baseURL + "/api/uploads/"
+	p.AuthURL = baseURL + "/login/oauth/authorize"
+	p.TokenURL = baseURL + "/login/oauth/access_token"
+}
+
+// ID returns the GithubID
+func (p *GithubProvider) ID() string {
+	return GithubID
+}
+
+// Name returns the name of the provider shown to users
+func (p *GithubProvider) Name() string {
+	return "Github"
+}
+
+// OAuthConfig returns the config users sign in with
+// The callback URL is the one set on the Github OAuth app, so the given one is not used
+func (p *GithubProvider) OAuthConfig(callbackURL string) *oauth2.Config {
+	endpoint := oauth2.Endpoint{AuthURL: p.AuthURL, TokenURL: p.TokenURL}
+	if endpoint.AuthURL == "" {
+		endpoint.AuthURL = githubAuthorizeURL
+	}
+	if endpoint.TokenURL == "" {
+		endpoint.TokenURL = githubTokenURL
+	}
+	return &oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		Endpoint:     endpoint,
+		Scopes:       []string{"repo"},
+	}
+}
+
+// NewClient returns a GithubClient with the given token
+func (p *GithubProvider) NewClient(token string) Client {
+	return p.newClient(token)
+}
+
+// newClient returns a GithubClient with the given token calling the provider's API
+func (p *GithubProvider) newClient(token string) *GithubClient {
+	client := newGithubClient(token, p.APIURL, p.UploadURL)
+	client.sshHost = p.SSHHost
+	return client
+}
+
+// ServerClient returns a GithubClient with the token of the app's installation on the repo,
+// or with the server's token if the app is not set or not installed on the repo
+// It returns nil if there's neither
+func (p *GithubProvider) ServerClient(owner, repo string) Client {
+	if token := p.installationToken(owner, repo); token != "" {
+		client := p.newClient(token)
+		client.app = true
+		if login, err := p.App.BotLogin(); err == nil {
+			client.botLogin = login
+		} else {
+			log.Printf("Error %s occurred looking up the bot user of the app", err)
+		}
+		return client
+	}
+	if p.Token == "" {
+		return nil
+	}
+	return p.newClient(p.Token)
+}
+
+// CloneToken returns a token of the app's installation that can only read the contents of the repo
+// It returns an empty string if the app is not set or not installed on the repo
+func (p *GithubProvider) CloneToken(owner, repo string) string {
+	if p.App == nil {
+		return ""
+	}
+	token, err := p.App.CloneToken(owner, repo)
+	if err != nil {
+		if !isAPINotFound(err) {
+			log.Printf("Error %s occurred minting the clone token of %s/%s", err, owner, repo)
+		}
+		return ""
+	}
+	return token
+}
+
+// installationToken returns the token of the app's installation on the repo, the server calls the API with
+// It returns an empty string if the app is not set or not installed on the repo
+func (p *GithubProvider) installationToken(owner, repo string) string {
+	if p.App == nil {
+		return ""
+	}
+	token, err := p.App.InstallationToken(owner, repo)
+	if err != nil {
+		if !isAPINotFound(err) {
+			log.Printf("Error %s occurred minting the installation token of %s/%s", err, owner, repo)
+		}
+		return ""
+	}
+	return token
+}
+
+// WebhookDelivery returns the delivery ID and the event name of a webhook request
+func (p *GithubProvider) WebhookDelivery(headers http.Header, payload []byte) (id, event string) {
+	return headers.Get("X-GitHub-Delivery"), headers.Get("X-GitHub-Event")
+}
+
+// VerifyWebhook returns true if the webhook payload is signed with the webhook secret
+func (p *GithubProvider) VerifyWebhook(headers http.Header, payload []byte) bool {
+	hook := &githubhook.Hook{
+		Signature: headers.Get("X-Hub-Signature"),
+		Payload:   payload,
+	}
+	return hook.Signature != "" && hook.SignedBy([]byte(p.WebhookSecret))
+}
+
+// ParseWebhook parses the payload of the ping, push, pull_request and release events
+// The repo is cloned from the provider's SSH host, if set
+func (p *GithubProvider) ParseWebhook(event string, payload []byte) (*Event, error) {
+	var e *Event
+	var err error
+	switch event {
+	case "ping":
+		e, err = parseGithubPingEvent(payload)
+	case string(github.PushEvent):
+		e, err = parseGithubPushEvent(payload)
+	case string(github.PullRequestEvent):
+		e, err = parseGithubPREvent(payload)
+	case string(github.ReleaseEvent):
+		e, err = parseGithubReleaseEvent(payload)
+	case string(github.IssueCommentEvent):
+		e, err = parseGithubIssueCommentEvent(payload)
+	case string(github.CommitCommentEvent):
+		e, err = parseGithubCommitCommentEvent(payload)
+	}
+	if e != nil {
+		e.Repo.CloneURL = githubCloneURL(e.Repo.CloneURL, p.SSHHost)
+	}
+	return e, err
+}
+
+// githubCloneURL returns the SSH url of a repo on the given SSH host, if set
+// e.g ssh://git@ssh.github.example.com:2222/owner/repo.git for git@github.example.com:owner/repo.git
+func githubCloneURL(sshURL, sshHost string) string {
+	i := strings.Index(sshURL, ":")
+	if sshHost == "" || !strings.HasPrefix(sshURL, "git@") || i < 0 {
+		return sshURL
+	}
+	return "ssh://git@" + sshHost + "/" + sshURL[i+1:]
+}
+
+// parseGithubPushEvent returns the event of the pushed commit of a branch or a tag
+// It returns a nil event when a branch or a tag is deleted
+func parseGithubPushEvent(payload []byte) (*Event, error) {
+	evt := github.PushPayload{}
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, err
+	}
+
+	if evt.Deleted {
+		return nil, nil
+	}
+
+	e := &Event{
+		Type:   EventPush,
+		Commit: evt.After,
+		Repo: Repo{
+			Owner:         evt.Repository.Owner.Name,
+			Name:          evt.Repository.Name,
+			FullName:      evt.Repository.FullName,
+			URL:           evt.Repository.HTMLURL,
+			CloneURL:      evt.Repository.SSHURL,
+			DefaultBranch: evt.Repository.DefaultBranch,
+		},
+		Pusher: Person{Name: evt.Pusher.Name, Email: evt.Pusher.Email},
+		HeadCommit: Commit{
+			ID:      evt.HeadCommit.ID,
+			Message: evt.HeadCommit.Message,
+			Author:  Person{Name: evt.HeadCommit.Author.Name, Email: evt.HeadCommit.Author.Email},
+		},
+	}
+	if evt.Repository.Language != nil {
+		e.Repo.Language = *evt.Repository.Language
+	}
+
+	for _, c := range evt.Commits {
+		commit := Commit{
+			ID:      c.ID,
+			Message: c.Message,
+			Author:  Person{Name: c.Author.Name, Email: c.Author.Email},
+		}
+		commit.Files = append(commit.Files, c.Added...)
+		commit.Files = append(commit.Files, c.Modified...)
+		commit.Files = append(commit.Files, c.Removed...)
+		e.Commits = append(e.Commits, commit)
+	}
+
+	if strings.HasPrefix(evt.Ref, "refs/tags/") {
+		e.Type = EventTag
+		e.Tag = strings.TrimPrefix(evt.Ref, "refs/tags/")
+		return e, nil
+	}
+	e.Branch = strings.TrimPrefix(evt.Ref, "refs/heads/")
+	return e, nil
+}
+
+// parseGithubPREvent returns the event of a pull request when it's opened, reopened or has new commits
+// It returns a nil event for the other pull request actions, and ErrForkPullRequest for the pull requests from forks
+func parseGithubPREvent(payload []byte) (*Event, error) {
+	evt := github.PullRequestPayload{}
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, err
+	}
+
+	switch evt.Action {
+	case "opened", "synchronize", "reopened":
+	default:
+		return nil, nil
+	}
+	// the head repo is null once the fork is deleted
+	if evt.PullRequest.Head.Repo.FullName != evt.PullRequest.Base.Repo.FullName {
+		return nil, ErrForkPullRequest
+	}
+
+	e := &Event{
+		Type:        EventPullRequest,
+		Commit:      evt.PullRequest.Head.Sha,
+		Ref:         fmt.Sprintf("refs/pull/%d/merge", evt.Number),
+		Branch:      evt.PullRequest.Head.Ref,
+		BaseBranch:  evt.PullRequest.Base.Ref,
+		PullRequest: int(evt.Number),
+		Repo: Repo{
+			Owner:         evt.Repository.Owner.Login,
+			Name:          evt.Repository.Name,
+			FullName:      evt.Repository.FullName,
+			URL:           evt.Repository.HTMLURL,
+			CloneURL:      evt.Repository.SSHURL,
+			DefaultBranch: evt.Repository.DefaultBranch,
+		},
+	}
+	if evt.Repository.Language != nil {
+		e.Repo.Language = *evt.Repository.Language
+	}
+	return e, nil
+}
+
+// parseGithubReleaseEvent returns the tag event of a release when it's published
+// It returns a nil event for the other release actions
+func parseGithubReleaseEvent(payload []byte) (*Event, error) {
+	evt := github.ReleasePayload{}
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, err
+	}
+
+	if evt.Action != "published" {
+		return nil, nil
+	}
+
+	e := &Event{
+		Type: EventTag,
+		Tag:  evt.Release.TagName,
+		Repo: Repo{
+			Owner:         evt.Repository.Owner.Login,
+			Name:          evt.Repository.Name,
+			FullName:      evt.Repository.FullName,
+			URL:           evt.Repository.HTMLURL,
+			CloneURL:      evt.Repository.SSHURL,
+			DefaultBranch: evt.Repository.DefaultBranch,
+		},
+		Pusher: Person{Name: evt.Sender.Login},
+	}
+	if evt.Repository.Language != nil {
+		e.Repo.Language = *evt.Repository.Language
+	}
+	return e, nil
+}
+
+// parseGithubIssueCommentEvent returns the comment event of a comment posted on a pull request
+// It returns a nil event for the comments on issues, and the edited and deleted comments
+func parseGithubIssueCommentEvent(payload []byte) (*Event, error) {
+	evt := github.IssueCommentPayload{}
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, err
+	}
+	// the payload's issue is a pull request if it links to one, go-playground predates the field
+	issue := struct {
+		Issue struct {
+			PullRequest *struct{} `json:"pull_request"`
+		} `json:"issue"`
+	}{}
+	if err := json.Unmarshal(payload, &issue); err != nil {
+		return nil, err
+	}
+
+	if evt.Action != "created" || issue.Issue.PullRequest == nil {
+		return nil, nil
+	}
+
+	e := &Event{
+		Type:        EventComment,
+		PullRequest: int(evt.Issue.Number),
+		Comment: &Comment{
+			ID:          evt.Comment.ID,
+			Body:        evt.Comment.Body,
+			Author:      evt.Comment.User.Login,
+			PullRequest: int(evt.Issue.Number),
+		},
+		Repo: Repo{
+			Owner:         evt.Repository.Owner.Login,
+			Name:          evt.Repository.Name,
+			FullName:      evt.Repository.FullName,
+			URL:           evt.Repository.HTMLURL,
+			CloneURL:      evt.Repository.SSHURL,
+			DefaultBranch: evt.Repository.DefaultBranch,
+		},
+	}
+	if evt.Repository.Language != nil {
+		e.Repo.Language = *evt.Repository.Language
+	}
+	return e, nil
+}
+
+// parseGithubCommitCommentEvent returns the comment event of a comment posted on a commit
+func parseGithubCommitCommentEvent(payload []byte) (*Event, error) {
+	evt := github.CommitCommentPayload{}
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, err
+	}
+
+	if evt.Action != "" && evt.Action != "created" {
+		return nil, nil
+	}
+
+	e := &Event{
+		Type:   EventComment,
+		Commit: evt.Comment.CommitID,
+		Comment: &Comment{
+			ID:     evt.Comment.ID,
+			Body:   evt.Comment.Body,
+			Author: evt.Comment.User.Login,
+			Commit: evt.Comment.CommitID,
+		},
+		Repo: Repo{
+			Owner:         evt.Repository.Owner.Login,
+			Name:          evt.Repository.Name,
+			FullName:      evt.Repository.FullName,
+			URL:           evt.Repository.HTMLURL,
+			CloneURL:      evt.Repository.SSHURL,
+			DefaultBranch: evt.Repository.DefaultBranch,
+		},
+	}
+	if evt.Repository.Language != nil {
+		e.Repo.Language = *evt.Repository.Language
+	}
+	return e, nil
+}
+
+func parseGithubPingEvent(payload []byte) (*Event, error) {
+	evt := github.WatchPayload{}
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, err
+	}
+
+	e := &Event{
+		Type: EventPing,
+		Repo: Repo{
+			Owner:         evt.Repository.Owner.Login,
+			Name:          evt.Repository.Name,
+			FullName:      evt.Repository.FullName,
+			URL:           evt.Repository.HTMLURL,
+			CloneURL:      evt.Repository.SSHURL,
+			DefaultBranch: evt.Repository.DefaultBranch,
+		},
+	}
+	if evt.Repository.Language != nil {
+		e.Repo.Language = *evt.Repository.Language
+	}
+	return e, nil
+}
@@ -0,0 +1,334 @@
+package vcs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// githubMaxRetries is how many times a failed call is retried
+	githubMaxRetries = 3
+	// githubRetryBackoff is the backoff before the first retry, it doubles with each retry
+	githubRetryBackoff = 500 * time.Millisecond
+	// githubMaxRetryAfter is the longest Retry-After of a secondary rate limit that's waited out
+	// The calls told to wait longer fail with the rate limit error
+	githubMaxRetryAfter = time.Minute
+
+	// GithubBudgetReserve is the percentage of the rate limit of a token kept for the high priority calls
+	// e.g the status updates. Once less is left, the low priority calls wait for the budget to reset
+	GithubBudgetReserve = 10
+	// githubMaxQueueWait is how long a low priority call waits for the budget to reset
+	// The calls that would wait longer fail with ErrBudgetLow
+	githubMaxQueueWait = 2 * time.Minute
+)
+
+// ErrBudgetLow is returned for the low priority calls made while the rate limit budget of the token is low
+var ErrBudgetLow = errors.New("the Github rate limit budget is low, low priority calls wait for it to reset")
+
+type githubPriorityKey struct{}
+
+// lowPriority is the context of the calls that can wait for the rate limit budget to reset once it's low
+// e.g the repo listings of the dashboard
+var lowPriority = context.WithValue(context.Background(), githubPriorityKey{}, true)
+
+// GithubBudget is the rate limit budget of a token for a resource, as last reported by Github
+type GithubBudget struct {
+	// Token identifies the token, it's the start of the hash of the token
+	Token string
+	// Resource is the rate limited resource e.g core or search
+	Resource  string
+	Limit     int
+	Remaining int
+	Reset     time.Time
+	UpdatedAt time.Time
+}
+
+// Low returns true if the low priority calls wait for the budget to reset
+func (b GithubBudget) Low() bool {
+	return b.Remaining*100 < GithubBudgetReserve*b.Limit && time.Now().Before(b.Reset)
+}
+
+// GithubEndpointMetrics are the calls made to an endpoint of the Github API
+type GithubEndpointMetrics struct {
+	// Endpoint is the method and the path of the endpoint e.g GET /repos/:owner/:repo/hooks
+	Endpoint string
+	// Calls counts the requests sent, including the retries
+	Calls int
+	// NotModified counts the conditional requests answered with 304, which don't count against the rate limit
+	NotModified int
+	// Errors counts the requests that failed or got an error response
+	Errors      int
+	Retries     int
+	RateLimited int
+	// Queued counts the low priority calls that waited for the budget to reset, or failed with ErrBudgetLow
+	Queued   int
+	Duration time.Duration
+}
+
+// AverageDuration returns the average duration of the calls
+func (m GithubEndpointMetrics) AverageDuration() time.Duration {
+	if m.Calls == 0 {
+		return 0
+	}
+	return (m.Duration / time.Duration(m.Calls)).Round(time.Millisecond)
+}
+
+var githubMetrics = struct {
+	sync.Mutex
+	endpoints map[string]*GithubEndpointMetrics
+	// budgets are keyed by the token and the resource
+	budgets map[string]*GithubBudget
+	// queues hold back the low priority calls of each token, one at a time
+	queues map[string]*sync.Mutex
+}{
+	endpoints: map[string]*GithubEndpointMetrics{},
+	budgets:   map[string]*GithubBudget{},
+	queues:    map[string]*sync.Mutex{},
+}
+
+// GithubMetrics returns the calls made to each endpoint of the Github API, sorted by endpoint,
+// and the last reported rate limit budget of each token, sorted by token and resource
+func GithubMetrics() ([]GithubEndpointMetrics, []GithubBudget) {
+	githubMetrics.Lock()
+	defer githubMetrics.Unlock()
+
+	endpoints := []GithubEndpointMetrics{}
+	for _, m := range githubMetrics.endpoints {
+		endpoints = append(endpoints, *m)
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Endpoint < endpoints[j].Endpoint })
+
+	budgets := []GithubBudget{}
+	for _, b := range githubMetrics.budgets {
+		budgets = append(budgets, *b)
+	}
+	sort.Slice(budgets, func(i, j int) bool {
+		if budgets[i].Token != budgets[j].Token {
+			return budgets[i].Token < budgets[j].Token
+		}
+		return budgets[i].Resource < budgets[j].Resource
+	})
+	return endpoints, budgets
+}
+
+// githubTransport is the transport of the Github clients
+// It records the calls of each endpoint and the rate limit budget of the token, waits out the secondary
+// rate limits, retries the idempotent calls that failed with a network or server error with a jittered backoff,
+// and holds back the low priority calls once the budget is low
+type githubTransport struct {
+	base http.RoundTripper
+	// token identifies the client's token in the metrics
+	token string
+}
+
+func newGithubTransport(base http.RoundTripper, tokenHash string) *githubTransport {
+	if len(tokenHash) > 8 {
+		tokenHash = tokenHash[:8]
+	}
+	return &githubTransport{base: base, token: tokenHash}
+}
+
+// RoundTrip sends the request, retrying it if it fails with a secondary rate limit,
+// or with a network or server error if it's idempotent
+func (t *githubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := githubEndpoint(req.Method, req.URL.Path)
+	if low, _ := req.Context().Value(githubPriorityKey{}).(bool); low {
+		if err := t.waitForBudget(req.Context(), endpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			req = req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				req.Body = body
+			}
+		}
+
+		start := time.Now()
+		resp, err := t.base.RoundTrip(req)
+		t.record(endpoint, resp, err, time.Since(start), attempt)
+
+		wait, retry := githubRetryWait(req, resp, err, attempt)
+		if !retry {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err := sleepContext(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// githubRetryWait returns how long to wait before retrying the request, and false if it's not retried
+// The calls rejected by a secondary rate limit weren't processed, so they are retried whatever their method
+func githubRetryWait(req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if attempt >= githubMaxRetries || req.Context().Err() != nil || (req.Body != nil && req.GetBody == nil) {
+		return 0, false
+	}
+
+	if resp != nil && (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) {
+		seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		wait := time.Duration(seconds) * time.Second
+		return wait, err == nil && wait <= githubMaxRetryAfter
+	}
+
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+	default:
+		return 0, false
+	}
+	if err != nil || resp.StatusCode >= 500 {
+		backoff := githubRetryBackoff << uint(attempt)
+		return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2))), true
+	}
+	return 0, false
+}
+
+// waitForBudget holds back a low priority call until the core rate limit budget of the token resets,
+// if it's low. The held back calls of a token wait one at a time
+func (t *githubTransport) waitForBudget(reqCtx context.Context, endpoint string) error {
+	key := t.token + " core"
+	githubMetrics.Lock()
+	budget, ok := githubMetrics.budgets[key]
+	low := ok && budget.Low()
+	queue, ok := githubMetrics.queues[t.token]
+	if !ok {
+		queue = &sync.Mutex{}
+		githubMetrics.queues[t.token] = queue
+	}
+	if low {
+		t.metrics(endpoint).Queued++
+	}
+	githubMetrics.Unlock()
+	if !low {
+		return nil
+	}
+
+	queue.Lock()
+	defer queue.Unlock()
+	githubMetrics.Lock()
+	wait, low := time.Until(budget.Reset), budget.Low()
+	githubMetrics.Unlock()
+	if !low {
+		return nil
+	}
+	if wait > githubMaxQueueWait {
+		return ErrBudgetLow
+	}
+	return sleepContext(reqCtx, wait)
+}
+
+// record adds the call to the metrics of the endpoint, and updates the budget of the token
+// with the rate limit headers of the response
+func (t *githubTransport) record(endpoint string, resp *http.Response, err error, duration time.Duration, attempt int) {
+	githubMetrics.Lock()
+	defer githubMetrics.Unlock()
+
+	m := t.metrics(endpoint)
+	m.Calls++
+	m.Duration += duration
+	if attempt > 0 {
+		m.Retries++
+	}
+	if err != nil {
+		m.Errors++
+		return
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		m.NotModified++
+	case resp.StatusCode >= 400:
+		m.Errors++
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || (resp.StatusCode == http.StatusForbidden &&
+		(resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0")) {
+		m.RateLimited++
+	}
+
+	limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return
+	}
+	remaining, _ := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	reset, _ := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	resource := resp.Header.Get("X-RateLimit-Resource")
+	if resource == "" {
+		resource = "core"
+	}
+	key := t.token + " " + resource
+	budget, ok := githubMetrics.budgets[key]
+	if !ok {
+		budget = &GithubBudget{Token: t.token, Resource: resource}
+		githubMetrics.budgets[key] = budget
+	}
+	budget.Limit, budget.Remaining, budget.Reset, budget.UpdatedAt = limit, remaining, time.Unix(reset, 0), time.Now()
+}
+
+// metrics returns the metrics of the endpoint, the githubMetrics lock must be held
+func (t *githubTransport) metrics(endpoint string) *GithubEndpointMetrics {
+	m, ok := githubMetrics.endpoints[endpoint]
+	if !ok {
+		m = &GithubEndpointMetrics{Endpoint: endpoint}
+		githubMetrics.endpoints[endpoint] = m
+	}
+	return m
+}
+
+// githubEndpoint returns the endpoint of the API path with its parameters replaced by their names
+// e.g GET /repos/:owner/:repo/statuses/:ref for GET /repos/o/r/statuses/1a2b
+func githubEndpoint(method, path string) string {
+	path = strings.TrimPrefix(path, "/api/v3")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i < len(parts); i++ {
+		switch {
+		case parts[i] == "repos" && i+2 < len(parts):
+			parts[i+1], parts[i+2] = ":owner", ":repo"
+			i += 2
+		case (parts[i] == "users" || parts[i] == "orgs") && i+1 < len(parts):
+			parts[i+1] = ":" + strings.TrimSuffix(parts[i], "s")
+			i++
+		case (parts[i] == "statuses" || parts[i] == "commits" || parts[i] == "branches") && i+1 < len(parts):
+			parts = append(parts[:i+1], ":ref")
+		case parts[i] == "contents" && i+1 < len(parts):
+			parts = append(parts[:i+1], ":path")
+		case isDigits(parts[i]):
+			parts[i] = ":id"
+		}
+	}
+	return method + " /" + strings.Join(parts, "/")
+}
+
+func isDigits(s string) bool {
+	_, err := strconv.ParseInt(s, 10, 64)
+	return err == nil
+}
+
+// sleepContext waits for the given duration, or until the context is done
+func sleepContext(reqCtx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-reqCtx.Done():
+		return reqCtx.Err()
+	}
+}
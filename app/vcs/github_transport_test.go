@@ -0,0 +1,112 @@
+package vcs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGithubTransportRetries(t *testing.T) {
+	tests := []struct {
+		name string
+		// statuses are the status codes of the consecutive responses, the last one is repeated
+		statuses   []int
+		retryAfter string
+		method     string
+		wantStatus int
+		wantCalls  int32
+	}{
+		{name: "success", statuses: []int{200}, method: "GET", wantStatus: 200, wantCalls: 1},
+		{name: "server error retried", statuses: []int{502, 200}, method: "GET", wantStatus: 200, wantCalls: 2},
+		{name: "server error of a POST not retried", statuses: []int{502, 200}, method: "POST", wantStatus: 502, wantCalls: 1},
+		{name: "secondary rate limit retried", statuses: []int{403, 201}, retryAfter: "0", method: "POST", wantStatus: 201, wantCalls: 2},
+		{name: "long secondary rate limit not retried", statuses: []int{429, 200}, retryAfter: "3600", method: "GET", wantStatus: 429, wantCalls: 1},
+		{name: "forbidden not retried", statuses: []int{403, 200}, method: "GET", wantStatus: 403, wantCalls: 1},
+		{name: "client error not retried", statuses: []int{404, 200}, method: "GET", wantStatus: 404, wantCalls: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := int(atomic.AddInt32(&calls, 1)) - 1
+				if i >= len(test.statuses) {
+					i = len(test.statuses) - 1
+				}
+				if test.retryAfter != "" {
+					w.Header().Set("Retry-After", test.retryAfter)
+				}
+				w.WriteHeader(test.statuses[i])
+			}))
+			defer server.Close()
+
+			client := &http.Client{Transport: newGithubTransport(http.DefaultTransport, "test-"+test.name)}
+			req, err := http.NewRequest(test.method, server.URL+"/repos/owner/repo/statuses/abc", strings.NewReader("{}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Do() returned %s", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("got status %d, want %d", resp.StatusCode, test.wantStatus)
+			}
+			if calls != test.wantCalls {
+				t.Errorf("got %d calls, want %d", calls, test.wantCalls)
+			}
+		})
+	}
+}
+
+func TestGithubTransportRecordsBudget(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "100")
+		w.Header().Set("X-RateLimit-Reset", "4102444800")
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newGithubTransport(http.DefaultTransport, "budgettoken")}
+	resp, err := client.Get(server.URL + "/user/repos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	_, budgets := GithubMetrics()
+	for _, b := range budgets {
+		if b.Token != "budgetto" || b.Resource != "core" {
+			continue
+		}
+		if b.Limit != 5000 || b.Remaining != 100 {
+			t.Errorf("got budget %d/%d, want 100/5000", b.Remaining, b.Limit)
+		}
+		if !b.Low() {
+			t.Errorf("a budget with 2%% left isn't low")
+		}
+		return
+	}
+	t.Errorf("the budget of the token wasn't recorded")
+}
+
+func TestGithubEndpoint(t *testing.T) {
+	tests := []struct {
+		method, path, want string
+	}{
+		{"GET", "/repos/o/r/statuses/1a2b", "GET /repos/:owner/:repo/statuses/:ref"},
+		{"GET", "/api/v3/repos/o/r/contents/dir/file.go", "GET /repos/:owner/:repo/contents/:path"},
+		{"POST", "/app/installations/42/access_tokens", "POST /app/installations/:id/access_tokens"},
+		{"GET", "/users/someone/repos", "GET /users/:user/repos"},
+		{"GET", "/user/repos", "GET /user/repos"},
+	}
+	for _, test := range tests {
+		if got := githubEndpoint(test.method, test.path); got != test.want {
+			t.Errorf("githubEndpoint(%s, %s) = %s, want %s", test.method, test.path, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package vcs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"newproj/ci"
+)
+
+// gitlabDeveloperAccess is the access level of the developer role, the lowest one that can push to a project
+const gitlabDeveloperAccess = 30
+
+// GitlabClient is a client of the GitLab REST API
+type GitlabClient struct {
+	// BaseURL is the URL of the GitLab instance e.g https://gitlab.com
+	BaseURL string
+	*restClient
+}
+
+// NewGitlabClient creates a new GitlabClient for the GitLab instance at the base URL with the given token
+func NewGitlabClient(baseURL, token string) *GitlabClient {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	return &GitlabClient{
+		BaseURL:    baseURL,
+		restClient: newRESTClient(baseURL+"/api/v4", token),
+	}
+}
+
+// gitlabProject is the project returned by the GitLab API
+type gitlabProject struct {
+	Path              string `json:"path"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	WebURL            string `json:"web_url"`
+	SSHURLToRepo      string `json:"ssh_url_to_repo"`
+	DefaultBranch     string `json:"default_branch"`
+	Namespace         struct {
+		FullPath string `json:"full_path"`
+	} `json:"namespace"`
+}
+
+func (project gitlabProject) repo() Repo {
+	return Repo{
+		Owner:         project.Namespace.FullPath,
+		Name:          project.Path,
+		FullName:      project.PathWithNamespace,
+		URL:           project.WebURL,
+		CloneURL:      project.SSHURLToRepo,
+		DefaultBranch: project.DefaultBranch,
+	}
+}
+
+// gitlabProjectPath returns the API path of the project with the given params
+func gitlabProjectPath(params RequestParams) string {
+	return "/projects/" + url.PathEscape(params.Owner+"/"+params.Repo)
+}
+
+// UpdateBuildStatus returns a function that when executed updates the commit status with the given status
+// GitLab has no error or skipped states, so errors are reported as failed and skipped builds as success
+func (client *GitlabClient) UpdateBuildStatus(params RequestParams) func(string) {
+	return func(state string) {
+		var description string
+
+		switch state {
+		case "success":
+			description = "Your tests passed on Sicuro"
+		case "flaky":
+			description = "Your tests passed on Sicuro with flaky retries"
+			state = "success"
+		case "pending":
+			description = "Sicuro is running your tests"
+			state = "running"
+		case "failure":
+			description = "Your tests failed on Sicuro"
+			state = "failed"
+		case "error":
+			description = "Sicuro couldn't run your tests. An error occurred"
+			state = "failed"
+		case "skipped":
+			description = "Sicuro skipped your tests"
+			state = "success"
+		case "canceled":
+			description = "Sicuro canceled your tests. A newer commit has been pushed"
+		}
+
+		err := client.createStatus(params, "SicuroCI", state, description)
+		if err != nil {
+			log.Println("Error occurred while updating repo status on the project: ", err)
+			return
+		}
+		log.Println("Successfully update project status to:", state)
+	}
+}
+
+// UpdateCoverageStatus returns a function that when executed sets the given coverage description
+// on the commit under a separate coverage status
+func (client *GitlabClient) UpdateCoverageStatus(params RequestParams) func(string) {
+	return func(description string) {
+		err := client.createStatus(params, "SicuroCI/coverage", "success", description)
+		if err != nil {
+			log.Println("Error occurred while updating coverage status on the project: ", err)
+			return
+		}
+		log.Println("Successfully update project coverage status to:", description)
+	}
+}
+
+// UpdateCheckRun returns nil, GitLab has no check runs, the builds are reported with commit statuses
+func (client *GitlabClient) UpdateCheckRun(params RequestParams) func(*ci.CheckRun) {
+	return nil
+}
+
+// UpdatePullRequestComment returns nil, the build summary comments are only posted on Github
+func (client *GitlabClient) UpdatePullRequestComment(params RequestParams, number int) func(string) {
+	return nil
+}
+
+func (client *GitlabClient) createStatus(params RequestParams, name, state, description string) error {
+	status := map[string]string{
+		"state":       state,
+		"name":        name,
+		"target_url":  params.CallbackURL,
+		"description": description,
+	}
+	return client.do("POST", gitlabProjectPath(params)+"/statuses/"+url.PathEscape(params.Ref), nil, status, nil)
+}
+
+// Subscribe adds the sicuro webhook to the given project
+func (client *GitlabClient) Subscribe(params RequestParams) error {
+	err := client.do("POST", gitlabProjectPath(params)+"/hooks", nil, gitlabHookSettings(params), nil)
+	if err != nil {
+		log.Printf("Error %s occurred while creating webhook with params %v", err, params)
+	}
+	return err
+}
+
+// UserRepos returns the projects the owner of the token is a member of, listing all the pages
+func (client *GitlabClient) UserRepos() []Repo {
+	repos := []Repo{}
+	for page := 1; ; page++ {
+		projects := []gitlabProject{}
+		query := url.Values{"membership": {"true"}, "order_by": {"last_activity_at"}, "per_page": {"100"}, "page": {strconv.Itoa(page)}}
+		if err := client.do("GET", "/projects", query, nil, &projects); err != nil {
+			log.Println("Error fetching users repo: ", err)
+			break
+		}
+
+		for _, project := range projects {
+			repos = append(repos, project.repo())
+		}
+		if len(projects) < 100 {
+			break
+		}
+	}
+	return repos
+}
+
+// Repo fetches and returns the project with the given params
+// The language of the repo is the one most of the project is written in
+func (client *GitlabClient) Repo(params RequestParams) (*Repo, error) {
+	project := gitlabProject{}
+	if err := client.do("GET", gitlabProjectPath(params), nil, nil, &project); err != nil {
+		log.Printf("Error %s occurred fetching repo with params %v", err, params)
+		return nil, err
+	}
+	repo := project.repo()
+
+	languages := map[string]float64{}
+	if err := client.do("GET", gitlabProjectPath(params)+"/languages", nil, nil, &languages); err != nil {
+		log.Printf("Error %s occurred fetching repo languages with params %v", err, params)
+	}
+	share := 0.0
+	for language, percent := range languages {
+		if percent > share {
+			repo.Language, share = language, percent
+		}
+	}
+	return &repo, nil
+}
+
+// FileContent returns the content of the file at the given path in the project at the params ref
+// A missing file is reported with an error for which IsNotFound returns true
+func (client *GitlabClient) FileContent(params RequestParams, path string) ([]byte, error) {
+	query := url.Values{"ref": {params.Ref}}
+	resp, err := client.send("GET", gitlabProjectPath(params)+"/repository/files/"+url.PathEscape(path)+"/raw", query, nil)
+	if err != nil {
+		if !isAPINotFound(err) {
+			log.Printf("Error %s occurred fetching file %s with params %v", err, path, params)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// CommitSHA returns the hash of the commit the given ref e.g a tag points to
+func (client *GitlabClient) CommitSHA(params RequestParams, ref string) (string, error) {
+	commit := struct {
+		ID string `json:"id"`
+	}{}
+	err := client.do("GET", gitlabProjectPath(params)+"/repository/commits/"+url.PathEscape(ref), nil, nil, &commit)
+	if err != nil {
+		log.Printf("Error %s occurred fetching commit for ref %s with params %v", err, ref, params)
+	}
+	return commit.ID, err
+}
+
+// Login returns the username of the user owning the token
+func (client *GitlabClient) Login() (string, error) {
+	user := struct {
+		Username string `json:"username"`
+	}{}
+	if err := client.do("GET", "/user", nil, nil, &user); err != nil {
+		log.Println("Error fetching user: ", err)
+		return "", err
+	}
+	return user.Username, nil
+}
+
+// IsRepoSubscribed checks if the given project has the sicuro webhook set
+func (client *GitlabClient) IsRepoSubscribed(params RequestParams) bool {
+	hooks := []struct {
+		URL string `json:"url"`
+	}{}
+	if err := client.do("GET", gitlabProjectPath(params)+"/hooks", nil, nil, &hooks); err != nil {
+		log.Printf("Error %s occurred checking repo subscription status with params %v", err, params)
+		return false
+	}
+
+	for _, hook := range hooks {
+		if hook.URL == params.CallbackURL {
+			return true
+		}
+	}
+	return false
+}
+
+// Unsubscribe deletes the sicuro webhooks of the given project
+func (client *GitlabClient) Unsubscribe(params RequestParams) error {
+	hooks, err := client.sicuroHooks(params)
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range hooks {
+		err := client.do("DELETE", gitlabProjectPath(params)+"/hooks/"+strconv.FormatInt(hook.ID, 10), nil, nil, nil)
+		if err != nil && !isAPINotFound(err) {
+			log.Printf("Error %s occurred deleting webhook with params %v", err, params)
+			return err
+		}
+	}
+	return nil
+}
+
+// AddDeployKey returns ErrNotSupported, only the Github projects get deploy keys
+func (client *GitlabClient) AddDeployKey(params RequestParams, title, publicKey string) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+// RemoveDeployKey returns ErrNotSupported, only the Github projects get deploy keys
+func (client *GitlabClient) RemoveDeployKey(params RequestParams, id int64) error {
+	return ErrNotSupported
+}
+
+// HasWriteAccess returns true if the user with the given username has the developer role or a higher one
+// on the project, including the roles inherited from its groups
+func (client *GitlabClient) HasWriteAccess(params RequestParams, login string) (bool, error) {
+	users := []struct {
+		ID int64 `json:"id"`
+	}{}
+	if err := client.do("GET", "/users", url.Values{"username": {login}}, nil, &users); err != nil {
+		log.Printf("Error %s occurred looking up user %s", err, login)
+		return false, err
+	}
+	if len(users) == 0 {
+		return false, nil
+	}
+
+	member := struct {
+		AccessLevel int `json:"access_level"`
+	}{}
+	err := client.do("GET", fmt.Sprintf("%s/members/all/%d", gitlabProjectPath(params), users[0].ID), nil, nil, &member)
+	if isAPINotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		log.Printf("Error %s occurred checking the permission of %s with params %v", err, login, params)
+		return false, err
+	}
+	return member.AccessLevel >= gitlabDeveloperAccess, nil
+}
+
+// PullRequest returns ErrNotSupported, the comment commands are only read from Github
+func (client *GitlabClient) PullRequest(params RequestParams, number int) (*Event, error) {
+	return nil, ErrNotSupported
+}
+
+// ReactToComment returns ErrNotSupported, the comment commands are only read from Github
+func (client *GitlabClient) ReactToComment(params RequestParams, comment *Comment, reaction string) error {
+	return ErrNotSupported
+}
+
+// ReplyToComment returns ErrNotSupported, the comment commands are only read from Github
+func (client *GitlabClient) ReplyToComment(params RequestParams, comment *Comment, body string) error {
+	return ErrNotSupported
+}
+
+// gitlabHookEvents are the events the sicuro webhooks are subscribed to
+var gitlabHookEvents = []string{"push_events", "tag_push_events", "merge_requests_events"}
+
+// gitlabHook is a project webhook returned by the GitLab API
+type gitlabHook struct {
+	ID                  int64  `json:"id"`
+	URL                 string `json:"url"`
+	PushEvents          bool   `json:"push_events"`
+	TagPushEvents       bool   `json:"tag_push_events"`
+	MergeRequestsEvents bool   `json:"merge_requests_events"`
+	// AlertStatus is set to disabled or temporarily_disabled by GitLab once the deliveries keep failing
+	AlertStatus string `json:"alert_status"`
+}
+
+func (hook gitlabHook) events() []string {
+	events := []string{}
+	for event, set := range map[string]bool{
+		"push_events":           hook.PushEvents,
+		"tag_push_events":       hook.TagPushEvents,
+		"merge_requests_events": hook.MergeRequestsEvents,
+	} {
+		if set {
+			events = append(events, event)
+		}
+	}
+	sort.Strings(events)
+	return events
+}
+
+// gitlabHookSettings returns the settings of the sicuro webhook of the project
+func gitlabHookSettings(params RequestParams) map[string]interface{} {
+	return map[string]interface{}{
+		"url":                     params.CallbackURL,
+		"token":                   params.Creds,
+		"push_events":             true,
+		"tag_push_events":         true,
+		"merge_requests_events":   true,
+		"enable_ssl_verification": true,
+	}
+}
+
+// sicuroHooks returns the webhooks of the project delivering to the params callback URL
+func (client *GitlabClient) sicuroHooks(params RequestParams) ([]gitlabHook, error) {
+	hooks := []gitlabHook{}
+	if err := client.do("GET", gitlabProjectPath(params)+"/hooks", url.Values{"per_page": {"100"}}, nil, &hooks); err != nil {
+		log.Printf("Error %s occurred listing webhooks with params %v", err, params)
+		return nil, err
+	}
+
+	sicuro := []gitlabHook{}
+	for _, hook := range hooks {
+		if hook.URL == params.CallbackURL {
+			sicuro = append(sicuro, hook)
+		}
+	}
+	return sicuro, nil
+}
+
+// WebhookHealth returns the state of the sicuro webhook of the project
+// GitLab neither reports the token of the webhook nor its deliveries, the token is assumed to be set
+// and a wrong one shows up as deliveries rejected by the server
+func (client *GitlabClient) WebhookHealth(params RequestParams) (*WebhookHealth, error) {
+	hooks, err := client.sicuroHooks(params)
+	if err != nil {
+		return nil, err
+	}
+	health := &WebhookHealth{Hooks: len(hooks)}
+	if len(hooks) == 0 {
+		return health, nil
+	}
+
+	hook := hooks[0]
+	health.Found = true
+	health.Active = hook.AlertStatus != "disabled" && hook.AlertStatus != "temporarily_disabled"
+	health.Events = hook.events()
+	health.MissingEvents = missingEvents(health.Events, gitlabHookEvents)
+	health.ContentType = "json"
+	health.HasSecret = true
+	return health, nil
+}
+
+// RepairWebhook sets the sicuro webhook of the project back to the events and token it's created with
+// The first webhook is updated and the duplicates are deleted, or the webhook is created if the project has none
+func (client *GitlabClient) RepairWebhook(params RequestParams) error {
+	hooks, err := client.sicuroHooks(params)
+	if err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		return client.Subscribe(params)
+	}
+
+	hooksPath := gitlabProjectPath(params) + "/hooks/"
+	if err := client.do("PUT", hooksPath+strconv.FormatInt(hooks[0].ID, 10), nil, gitlabHookSettings(params), nil); err != nil {
+		log.Printf("Error %s occurred while updating webhook with params %v", err, params)
+		return err
+	}
+	for _, hook := range hooks[1:] {
+		err := client.do("DELETE", hooksPath+strconv.FormatInt(hook.ID, 10), nil, nil, nil)
+		if err != nil && !isAPINotFound(err) {
+			log.Printf("Error %s occurred deleting webhook with params %v", err, params)
+			return err
+		}
+	}
+	return nil
+}
+
+// BranchHeads returns the commits the first 100 branches of the project point to, keyed by branch
+func (client *GitlabClient) BranchHeads(params RequestParams, etag string) (map[string]string, string, error) {
+	branches := []struct {
+		Name   string `json:"name"`
+		Commit struct {
+			ID string `json:"id"`
+		} `json:"commit"`
+	}{}
+	query := url.Values{"per_page": {"100"}}
+	newETag, err := client.getIfChanged(gitlabProjectPath(params)+"/repository/branches", query, etag, &branches)
+	if err != nil {
+		return nil, newETag, err
+	}
+
+	heads := map[string]string{}
+	for _, branch := range branches {
+		heads[branch.Name] = branch.Commit.ID
+	}
+	return heads, newETag, nil
+}
@@ -0,0 +1,258 @@
+package vcs
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// GitlabID is the ID of the GitLab provider
+	GitlabID = "gl"
+
+	// gitlabNullSHA is the commit hash GitLab sends for deleted branches and tags
+	gitlabNullSHA = "0000000000000000000000000000000000000000"
+)
+
+// GitlabProvider is the GitLab Provider, either gitlab.com or a self-managed instance
+type GitlabProvider struct {
+	// BaseURL is the URL of the GitLab instance e.g https://gitlab.com
+	BaseURL      string
+	ClientID     string
+	ClientSecret string
+	// WebhookSecret is the secret token GitLab sends with the webhook requests
+	WebhookSecret string
+	// Token is the server's token, used for offline actions such as build status updates
+	Token string
+}
+
+// NewGitlabProvider creates a new GitlabProvider for the GitLab instance at the base URL
+// with the given OAuth application credentials, webhook secret and server token
+func NewGitlabProvider(baseURL, clientID, clientSecret, webhookSecret, token string) *GitlabProvider {
+	return &GitlabProvider{
+		BaseURL:       strings.TrimSuffix(baseURL, "/"),
+		ClientID:      clientID,
+		ClientSecret:  clientSecret,
+		WebhookSecret: webhookSecret,
+		Token:         token,
+	}
+}
+
+// ID returns the GitlabID
+func (p *GitlabProvider) ID() string {
+	return GitlabID
+}
+
+// Name returns the name of the provider shown to users
+func (p *GitlabProvider) Name() string {
+	return "GitLab"
+}
+
+// OAuthConfig returns the config users sign in with
+// GitLab requires the callback URL to match the one set on the GitLab application
+func (p *GitlabProvider) OAuthConfig(callbackURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  p.BaseURL + "/oauth/authorize",
+			TokenURL: p.BaseURL + "/oauth/token",
+		},
+		RedirectURL: callbackURL,
+		Scopes:      []string{"api"},
+	}
+}
+
+// NewClient returns a GitlabClient with the given token
+func (p *GitlabProvider) NewClient(token string) Client {
+	return NewGitlabClient(p.BaseURL, token)
+}
+
+// ServerClient returns a GitlabClient with the server's token, which is the same for every repo
+// It returns nil if the token is not set
+func (p *GitlabProvider) ServerClient(owner, repo string) Client {
+	if p.Token == "" {
+		return nil
+	}
+	return NewGitlabClient(p.BaseURL, p.Token)
+}
+
+// CloneToken returns an empty string, the repos are cloned with the server's SSH key
+func (p *GitlabProvider) CloneToken(owner, repo string) string {
+	return ""
+}
+
+// WebhookDelivery returns the delivery ID and the event name of a webhook request
+// GitLab versions that don't send the event UUID are identified by the hash of the payload instead
+func (p *GitlabProvider) WebhookDelivery(headers http.Header, payload []byte) (id, event string) {
+	id = headers.Get("X-Gitlab-Event-UUID")
+	if id == "" && len(payload) > 0 {
+		sum := sha1.Sum(payload)
+		id = hex.EncodeToString(sum[:])
+	}
+	return id, headers.Get("X-Gitlab-Event")
+}
+
+// VerifyWebhook returns true if the webhook request has the webhook secret as its token
+func (p *GitlabProvider) VerifyWebhook(headers http.Header, payload []byte) bool {
+	token := headers.Get("X-Gitlab-Token")
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(p.WebhookSecret)) == 1
+}
+
+// gitlabProjectPayload is the project sent in the GitLab webhook payloads
+type gitlabProjectPayload struct {
+	PathWithNamespace string `json:"path_with_namespace"`
+	WebURL            string `json:"web_url"`
+	GitSSHURL         string `json:"git_ssh_url"`
+	DefaultBranch     string `json:"default_branch"`
+}
+
+func (project gitlabProjectPayload) repo() Repo {
+	owner, name := "", project.PathWithNamespace
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		owner, name = name[:i], name[i+1:]
+	}
+	return Repo{
+		Owner:         owner,
+		Name:          name,
+		FullName:      project.PathWithNamespace,
+		URL:           project.WebURL,
+		CloneURL:      project.GitSSHURL,
+		DefaultBranch: project.DefaultBranch,
+	}
+}
+
+// gitlabPushPayload is the payload of the push and tag push events
+type gitlabPushPayload struct {
+	Ref         string               `json:"ref"`
+	After       string               `json:"after"`
+	CheckoutSHA string               `json:"checkout_sha"`
+	UserName    string               `json:"user_name"`
+	UserEmail   string               `json:"user_email"`
+	Project     gitlabProjectPayload `json:"project"`
+	Commits     []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		Author  struct {
+			Name  string `json:"name"`
+			Email string `json:"email"`
+		} `json:"author"`
+		Added    []string `json:"added"`
+		Modified []string `json:"modified"`
+		Removed  []string `json:"removed"`
+	} `json:"commits"`
+}
+
+// gitlabMRPayload is the payload of the merge request events
+type gitlabMRPayload struct {
+	Project          gitlabProjectPayload `json:"project"`
+	ObjectAttributes struct {
+		IID          int    `json:"iid"`
+		Action       string `json:"action"`
+		SourceBranch string `json:"source_branch"`
+		TargetBranch string `json:"target_branch"`
+		// the source project is a fork for the merge requests opened from one
+		SourceProjectID int64 `json:"source_project_id"`
+		TargetProjectID int64 `json:"target_project_id"`
+		// OldRev is set on update actions that pushed new commits
+		OldRev     string `json:"oldrev"`
+		LastCommit struct {
+			ID string `json:"id"`
+		} `json:"last_commit"`
+	} `json:"object_attributes"`
+}
+
+// ParseWebhook parses the payload of the push, tag push and merge request events
+func (p *GitlabProvider) ParseWebhook(event string, payload []byte) (*Event, error) {
+	switch event {
+	case "Push Hook", "Tag Push Hook":
+		return parseGitlabPushEvent(payload)
+	case "Merge Request Hook":
+		return parseGitlabMREvent(payload)
+	}
+	return nil, nil
+}
+
+// parseGitlabPushEvent returns the event of the pushed commit of a branch or a tag
+// It returns a nil event when a branch or a tag is deleted
+func parseGitlabPushEvent(payload []byte) (*Event, error) {
+	evt := gitlabPushPayload{}
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, err
+	}
+
+	if evt.After == gitlabNullSHA {
+		return nil, nil
+	}
+
+	e := &Event{
+		Type:   EventPush,
+		Commit: evt.After,
+		Repo:   evt.Project.repo(),
+		Pusher: Person{Name: evt.UserName, Email: evt.UserEmail},
+	}
+	// the checkout sha is the commit an annotated tag points to
+	if evt.CheckoutSHA != "" {
+		e.Commit = evt.CheckoutSHA
+	}
+
+	for _, c := range evt.Commits {
+		commit := Commit{
+			ID:      c.ID,
+			Message: c.Message,
+			Author:  Person{Name: c.Author.Name, Email: c.Author.Email},
+		}
+		commit.Files = append(commit.Files, c.Added...)
+		commit.Files = append(commit.Files, c.Modified...)
+		commit.Files = append(commit.Files, c.Removed...)
+		e.Commits = append(e.Commits, commit)
+		if c.ID == e.Commit {
+			e.HeadCommit = commit
+		}
+	}
+
+	if strings.HasPrefix(evt.Ref, "refs/tags/") {
+		e.Type = EventTag
+		e.Tag = strings.TrimPrefix(evt.Ref, "refs/tags/")
+		return e, nil
+	}
+	e.Branch = strings.TrimPrefix(evt.Ref, "refs/heads/")
+	return e, nil
+}
+
+// parseGitlabMREvent returns the event of a merge request when it's opened, reopened or has new commits
+// It returns a nil event for the other merge request actions, and ErrForkPullRequest for the merge requests from forks
+func parseGitlabMREvent(payload []byte) (*Event, error) {
+	evt := gitlabMRPayload{}
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, err
+	}
+
+	attrs := evt.ObjectAttributes
+	switch {
+	case attrs.Action == "open", attrs.Action == "reopen":
+	case attrs.Action == "update" && attrs.OldRev != "":
+	default:
+		return nil, nil
+	}
+	if attrs.SourceProjectID != attrs.TargetProjectID {
+		return nil, ErrForkPullRequest
+	}
+
+	e := &Event{
+		Type:        EventPullRequest,
+		Commit:      attrs.LastCommit.ID,
+		Ref:         fmt.Sprintf("refs/merge-requests/%d/merge", attrs.IID),
+		Branch:      attrs.SourceBranch,
+		BaseBranch:  attrs.TargetBranch,
+		PullRequest: attrs.IID,
+		Repo:        evt.Project.repo(),
+	}
+	return e, nil
+}
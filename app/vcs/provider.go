@@ -0,0 +1,292 @@
+package vcs
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+
+	"newproj/ci"
+)
+
+const (
+	// EventPing is the event sent when a webhook is created
+	EventPing = "ping"
+	// EventPush is the event of commits pushed to a branch
+	EventPush = "push"
+	// EventTag is the event of a pushed tag or a published release
+	EventTag = "tag"
+	// EventPullRequest is the event of a pull request opened, reopened or updated with new commits
+	EventPullRequest = "pull_request"
+	// EventComment is the event of a comment posted on a pull request or a commit
+	EventComment = "comment"
+)
+
+// ErrNotModified is returned by the conditional requests of a client when the resource
+// hasn't changed since the ETag of the previous request
+var ErrNotModified = errors.New("not modified")
+
+// ErrNotSupported is returned by the client methods the provider has no API for
+var ErrNotSupported = errors.New("not supported by the provider")
+
+// ErrForkPullRequest is returned for the pull requests opened from forks, which are not built
+// as their code would run in a test container with the server's token and SSH key
+var ErrForkPullRequest = errors.New("pull requests from forks are not built")
+
+// IsPermissionError returns true if the error is the API error of a request the token isn't allowed to make
+// i.e a 401, 403 or 404 response, as the providers hide the repos from the users who can't see them
+func IsPermissionError(err error) bool {
+	var status int
+	switch err := err.(type) {
+	case *APIError:
+		status = err.StatusCode
+	case *github.ErrorResponse:
+		if err.Response != nil {
+			status = err.Response.StatusCode
+		}
+	}
+	return status == http.StatusUnauthorized || status == http.StatusForbidden || status == http.StatusNotFound
+}
+
+// RequestParams is a collection of common params
+// required by the provider client methods in this package
+type RequestParams struct {
+	// Repo is the request target repo
+	Repo string
+	// Owner refers to the owner of the request target repo
+	Owner string
+	// Ref is the target sha ref on the repo
+	Ref string
+	// Callback is a URL linking back to the site
+	CallbackURL string
+	// Creds is any credential or secret required for the request
+	Creds string
+}
+
+// Provider is a VCS hosting service e.g Github or GitLab
+type Provider interface {
+	// ID identifies the provider in routes, sessions and saved records e.g gh
+	ID() string
+	// Name is the name of the provider shown to users e.g Github
+	Name() string
+	// OAuthConfig returns the config users sign in with
+	// callbackURL is the URL of the app the provider redirects to once the user signs in
+	OAuthConfig(callbackURL string) *oauth2.Config
+	// NewClient returns a client acting on behalf of the owner of the given token
+	NewClient(token string) Client
+	// ServerClient returns a client with the server's credentials for the given repo,
+	// for offline actions such as build status updates. It returns nil if there are none
+	ServerClient(owner, repo string) Client
+	// CloneToken returns a token the tests containers clone the given repo with over HTTPS
+	// It returns an empty string if the repo is cloned with the server's SSH key
+	CloneToken(owner, repo string) string
+	// WebhookDelivery returns the delivery ID and the event name of a webhook request
+	WebhookDelivery(headers http.Header, payload []byte) (id, event string)
+	// VerifyWebhook returns true if the webhook request was sent by the provider with the server's secret
+	VerifyWebhook(headers http.Header, payload []byte) bool
+	// ParseWebhook parses the payload of a webhook event
+	// It returns a nil Event for the events and actions that are not built
+	ParseWebhook(event string, payload []byte) (*Event, error)
+}
+
+// Client is a client of the provider API
+type Client interface {
+	// Login returns the login of the user owning the client's token
+	Login() (string, error)
+	// UserRepos returns the repos of the user owning the client's token
+	UserRepos() []Repo
+	// Repo fetches and returns the repo with the given params
+	Repo(params RequestParams) (*Repo, error)
+	// Subscribe adds the sicuro webhook to the given repo
+	Subscribe(params RequestParams) error
+	// IsRepoSubscribed checks if the given repo has the sicuro webhook set
+	IsRepoSubscribed(params RequestParams) bool
+	// Unsubscribe removes the sicuro webhook from the given repo, if it's set
+	Unsubscribe(params RequestParams) error
+	// WebhookHealth returns the state of the sicuro webhook of the given repo, with its recent deliveries if known
+	WebhookHealth(params RequestParams) (*WebhookHealth, error)
+	// RepairWebhook sets the sicuro webhook of the given repo back to the events, content type and secret
+	// it's created with, or creates it if the repo has none
+	RepairWebhook(params RequestParams) error
+	// AddDeployKey registers the given public key as a read-only deploy key of the given repo, and returns its ID
+	// It returns ErrNotSupported if the provider's projects don't get deploy keys
+	AddDeployKey(params RequestParams, title, publicKey string) (int64, error)
+	// RemoveDeployKey removes the deploy key with the given ID from the given repo, if it's still there
+	RemoveDeployKey(params RequestParams, id int64) error
+	// HasWriteAccess returns true if the user with the given login can push to the given repo
+	HasWriteAccess(params RequestParams, login string) (bool, error)
+	// PullRequest returns the pull request event of the given pull request's current head commit
+	PullRequest(params RequestParams, number int) (*Event, error)
+	// ReactToComment adds the given reaction e.g +1 or confused to the comment
+	ReactToComment(params RequestParams, comment *Comment, reaction string) error
+	// ReplyToComment posts the given markdown on the pull request or the commit of the comment
+	ReplyToComment(params RequestParams, comment *Comment, body string) error
+	// UpdateBuildStatus returns a function that when executed updates the commit status with the given build status
+	UpdateBuildStatus(params RequestParams) func(string)
+	// UpdateCoverageStatus returns a function that when executed sets the given coverage description on the commit
+	UpdateCoverageStatus(params RequestParams) func(string)
+	// UpdateCheckRun returns a function that when executed creates or updates the check run of the commit
+	// with the given one. It returns nil if the provider or the client's token can't create check runs
+	UpdateCheckRun(params RequestParams) func(*ci.CheckRun)
+	// UpdatePullRequestComment returns a function that when executed sets the sicuro comment of the pull request
+	// with the given number to the given markdown, posting it the first time. It returns nil if the provider
+	// doesn't support it
+	UpdatePullRequestComment(params RequestParams, number int) func(string)
+	// FileContent returns the content of the file at the given path in the repo at the params ref
+	// A missing file is reported with an error for which IsNotFound returns true
+	FileContent(params RequestParams, path string) ([]byte, error)
+	// CommitSHA returns the hash of the commit the given ref e.g a tag points to
+	CommitSHA(params RequestParams, ref string) (string, error)
+	// BranchHeads returns the commits the branches of the repo point to, keyed by branch
+	// The request is conditional on the given ETag of the previous one, if set, and ErrNotModified
+	// is returned if the branches haven't changed. The ETag of the response is returned, if any
+	BranchHeads(params RequestParams, etag string) (heads map[string]string, newETag string, err error)
+}
+
+// Repo is a repo hosted on a provider
+type Repo struct {
+	Owner    string
+	Name     string
+	FullName string
+	// Language is the main language of the repo, if known
+	Language string
+	// URL is the web page of the repo
+	URL string
+	// CloneURL is the SSH url the tests containers clone the repo with
+	CloneURL      string
+	DefaultBranch string
+}
+
+// WebhookHealth is the state of the sicuro webhook of a repo, as reported by the provider
+type WebhookHealth struct {
+	// Found is false if the repo has no sicuro webhook
+	Found bool
+	// Hooks is the number of sicuro webhooks of the repo, each event is delivered once per webhook
+	Hooks  int
+	Active bool
+	// Events are the events the webhook is subscribed to, and MissingEvents the ones sicuro builds that it's not
+	Events        []string
+	MissingEvents []string
+	// ContentType is the format of the payloads e.g json
+	ContentType string
+	// HasSecret is true if the webhook signs its payloads with a secret or sends a token
+	HasSecret bool
+	// Deliveries are the recent deliveries of the webhook, newest first
+	// They are nil if the provider doesn't report them
+	Deliveries []HookDelivery
+	// LastSuccess is when the webhook was last delivered successfully, or zero if it's not among the recent deliveries
+	LastSuccess time.Time
+}
+
+// HookDelivery is a request sent by the webhook of a repo, as reported by the provider
+type HookDelivery struct {
+	ID          string
+	Event       string
+	DeliveredAt time.Time
+	// StatusCode is the status code of the response, or 0 if none was received
+	StatusCode int
+	// Status describes the outcome e.g OK or timed out
+	Status string
+}
+
+// Failed returns true if the delivery didn't get a success response
+func (d HookDelivery) Failed() bool {
+	return d.StatusCode < 200 || d.StatusCode >= 300
+}
+
+// missingEvents returns the wanted events that are not in the given ones
+func missingEvents(events, wanted []string) []string {
+	missing := []string{}
+	for _, w := range wanted {
+		found := false
+		for _, e := range events {
+			found = found || e == w || e == "*"
+		}
+		if !found {
+			missing = append(missing, w)
+		}
+	}
+	return missing
+}
+
+// Person is a commit author or the pusher of the commits
+type Person struct {
+	Name  string
+	Email string
+}
+
+// Commit is a commit received in a webhook event
+type Commit struct {
+	ID      string
+	Message string
+	Author  Person
+	// Files are the paths of the files the commit added, modified or removed
+	Files []string
+}
+
+// Event is a webhook event parsed by a provider
+type Event struct {
+	// Type is one of the Event* types
+	Type string
+	Repo Repo
+	// Commit is the hash of the commit to build
+	// It's empty for tag events that only name the tag e.g a published release
+	Commit string
+	// Ref is a ref to build instead of the commit, if set e.g the merge ref of a pull request
+	Ref string
+	// Branch is the branch the commit was pushed to, or the head branch of a pull request
+	Branch string
+	// BaseBranch is the branch a pull request is merged into
+	BaseBranch  string
+	Tag         string
+	PullRequest int
+	Pusher      Person
+	// HeadCommit is the last pushed commit, if known
+	HeadCommit Commit
+	// Commits are the pushed commits, if known
+	Commits []Commit
+	// Comment is the posted comment of a comment event
+	Comment *Comment
+}
+
+// Comment is a comment posted on a pull request or a commit
+type Comment struct {
+	ID   int64
+	Body string
+	// Author is the login of the commenter
+	Author string
+	// PullRequest is the number of the pull request commented on, or 0 for a commit comment
+	PullRequest int
+	// Commit is the commit commented on, for a commit comment
+	Commit string
+}
+
+var providers []Provider
+
+// RegisterProvider adds the given provider to the ones users can sign in with
+func RegisterProvider(p Provider) {
+	providers = append(providers, p)
+}
+
+// Providers returns the registered providers in the order they were registered
+func Providers() []Provider {
+	return providers
+}
+
+// FindProvider returns the registered provider with the given ID
+// It returns nil if there's none
+func FindProvider(id string) Provider {
+	for _, p := range providers {
+		if p.ID() == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// IsNotFound returns true if the error is a provider API not found error
+func IsNotFound(err error) bool {
+	return isGithubNotFound(err) || isAPINotFound(err)
+}
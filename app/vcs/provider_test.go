@@ -0,0 +1,257 @@
+package vcs
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParsePushEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		event    string
+		payload  string
+		want     *Event
+	}{
+		{
+			name:     "github branch push",
+			provider: &GithubProvider{},
+			event:    "push",
+			payload: `{
+				"ref": "refs/heads/master",
+				"after": "abc123",
+				"repository": {"name": "repo", "full_name": "owner/repo", "owner": {"name": "owner"},
+					"html_url": "https://github.com/owner/repo", "ssh_url": "git@github.com:owner/repo.git",
+					"default_branch": "master", "language": "Go"},
+				"pusher": {"name": "pusher", "email": "pusher@example.com"},
+				"head_commit": {"id": "abc123", "message": "Fix the build", "author": {"name": "author", "email": "author@example.com"}},
+				"commits": [{"id": "abc123", "message": "Fix the build", "author": {"name": "author", "email": "author@example.com"},
+					"added": ["new.go"], "modified": ["main.go"], "removed": ["old.go"]}]
+			}`,
+			want: &Event{
+				Type:   EventPush,
+				Commit: "abc123",
+				Branch: "master",
+				Repo: Repo{Owner: "owner", Name: "repo", FullName: "owner/repo", Language: "Go",
+					URL: "https://github.com/owner/repo", CloneURL: "git@github.com:owner/repo.git", DefaultBranch: "master"},
+				Pusher: Person{Name: "pusher", Email: "pusher@example.com"},
+				HeadCommit: Commit{ID: "abc123", Message: "Fix the build",
+					Author: Person{Name: "author", Email: "author@example.com"}},
+				Commits: []Commit{{ID: "abc123", Message: "Fix the build", Author: Person{Name: "author", Email: "author@example.com"},
+					Files: []string{"new.go", "main.go", "old.go"}}},
+			},
+		},
+		{
+			name:     "github ssh host",
+			provider: &GithubProvider{SSHHost: "ssh.github.example.com:2222"},
+			event:    "push",
+			payload: `{"ref": "refs/tags/v1.0.0", "after": "abc123",
+				"repository": {"name": "repo", "owner": {"name": "owner"}, "ssh_url": "git@github.example.com:owner/repo.git"}}`,
+			want: &Event{
+				Type:   EventTag,
+				Commit: "abc123",
+				Tag:    "v1.0.0",
+				Repo:   Repo{Owner: "owner", Name: "repo", CloneURL: "ssh://git@ssh.github.example.com:2222/owner/repo.git"},
+			},
+		},
+		{
+			name:     "github deleted branch",
+			provider: &GithubProvider{},
+			event:    "push",
+			payload:  `{"ref": "refs/heads/feature", "deleted": true, "after": "0000000000000000000000000000000000000000"}`,
+		},
+		{
+			name:     "gitlab annotated tag push",
+			provider: &GitlabProvider{},
+			event:    "Tag Push Hook",
+			payload: `{
+				"ref": "refs/tags/v1.0.0",
+				"after": "tag123",
+				"checkout_sha": "abc123",
+				"user_name": "pusher",
+				"user_email": "pusher@example.com",
+				"project": {"path_with_namespace": "group/sub/repo", "web_url": "https://gitlab.com/group/sub/repo",
+					"git_ssh_url": "git@gitlab.com:group/sub/repo.git", "default_branch": "main"},
+				"commits": [{"id": "abc123", "message": "Release", "author": {"name": "author", "email": "author@example.com"},
+					"modified": ["CHANGELOG.md"]}]
+			}`,
+			want: &Event{
+				Type:   EventTag,
+				Commit: "abc123",
+				Tag:    "v1.0.0",
+				Repo: Repo{Owner: "group/sub", Name: "repo", FullName: "group/sub/repo",
+					URL: "https://gitlab.com/group/sub/repo", CloneURL: "git@gitlab.com:group/sub/repo.git", DefaultBranch: "main"},
+				Pusher: Person{Name: "pusher", Email: "pusher@example.com"},
+				HeadCommit: Commit{ID: "abc123", Message: "Release", Author: Person{Name: "author", Email: "author@example.com"},
+					Files: []string{"CHANGELOG.md"}},
+				Commits: []Commit{{ID: "abc123", Message: "Release", Author: Person{Name: "author", Email: "author@example.com"},
+					Files: []string{"CHANGELOG.md"}}},
+			},
+		},
+		{
+			name:     "gitlab deleted branch",
+			provider: &GitlabProvider{},
+			event:    "Push Hook",
+			payload:  `{"ref": "refs/heads/feature", "after": "0000000000000000000000000000000000000000"}`,
+		},
+		{
+			name:     "gitlab unknown event",
+			provider: &GitlabProvider{},
+			event:    "Note Hook",
+			payload:  `{}`,
+		},
+		{
+			name:     "gitea branch push",
+			provider: &GiteaProvider{},
+			event:    "push",
+			payload: `{
+				"ref": "refs/heads/main",
+				"after": "abc123",
+				"repository": {"name": "repo", "full_name": "owner/repo", "owner": {"login": "owner"},
+					"html_url": "https://gitea.example.com/owner/repo", "ssh_url": "git@gitea.example.com:owner/repo.git",
+					"default_branch": "main"},
+				"pusher": {"login": "pusher", "email": "pusher@example.com"},
+				"head_commit": {"id": "abc123", "message": "Add docs", "author": {"name": "author", "email": "author@example.com"},
+					"added": ["docs/index.md"]},
+				"commits": [{"id": "abc123", "message": "Add docs", "author": {"name": "author", "email": "author@example.com"},
+					"added": ["docs/index.md"]}]
+			}`,
+			want: &Event{
+				Type:   EventPush,
+				Commit: "abc123",
+				Branch: "main",
+				Repo: Repo{Owner: "owner", Name: "repo", FullName: "owner/repo",
+					URL: "https://gitea.example.com/owner/repo", CloneURL: "git@gitea.example.com:owner/repo.git", DefaultBranch: "main"},
+				Pusher: Person{Name: "pusher", Email: "pusher@example.com"},
+				HeadCommit: Commit{ID: "abc123", Message: "Add docs", Author: Person{Name: "author", Email: "author@example.com"},
+					Files: []string{"docs/index.md"}},
+				Commits: []Commit{{ID: "abc123", Message: "Add docs", Author: Person{Name: "author", Email: "author@example.com"},
+					Files: []string{"docs/index.md"}}},
+			},
+		},
+		{
+			name:     "gitea deleted tag",
+			provider: &GiteaProvider{},
+			event:    "push",
+			payload:  `{"ref": "refs/tags/v1.0.0", "after": "0000000000000000000000000000000000000000"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.provider.ParseWebhook(test.event, []byte(test.payload))
+			if err != nil {
+				t.Fatalf("ParseWebhook() returned %s", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseWebhook() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParsePushEventInvalidPayload(t *testing.T) {
+	tests := []struct {
+		provider Provider
+		event    string
+	}{
+		{&GithubProvider{}, "push"},
+		{&GitlabProvider{}, "Push Hook"},
+		{&GiteaProvider{}, "push"},
+	}
+	for _, test := range tests {
+		if _, err := test.provider.ParseWebhook(test.event, []byte(`{"ref": 42`)); err == nil {
+			t.Errorf("%s: ParseWebhook() of a truncated payload didn't fail", test.provider.ID())
+		}
+	}
+}
+
+func TestParseGithubPREventFromFork(t *testing.T) {
+	tests := []struct {
+		name     string
+		headRepo string
+		wantErr  error
+	}{
+		{name: "same repo", headRepo: `{"full_name": "owner/repo"}`},
+		{name: "fork", headRepo: `{"full_name": "someone/repo"}`, wantErr: ErrForkPullRequest},
+		{name: "deleted fork", headRepo: `null`, wantErr: ErrForkPullRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload := `{"action": "opened", "number": 7,
+				"pull_request": {"head": {"ref": "feature", "sha": "abc123", "repo": ` + test.headRepo + `},
+					"base": {"ref": "master", "repo": {"full_name": "owner/repo"}}},
+				"repository": {"name": "repo", "full_name": "owner/repo", "owner": {"login": "owner"}}}`
+			evt, err := (&GithubProvider{}).ParseWebhook("pull_request", []byte(payload))
+			if err != test.wantErr {
+				t.Fatalf("ParseWebhook() returned error %v, want %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if evt.Ref != "refs/pull/7/merge" || evt.Commit != "abc123" || evt.PullRequest != 7 {
+				t.Errorf("ParseWebhook() = %+v, want the merge ref of pull request 7", evt)
+			}
+		})
+	}
+}
+
+func TestParseGitlabMREventFromFork(t *testing.T) {
+	tests := []struct {
+		name          string
+		sourceProject int
+		wantErr       error
+	}{
+		{name: "same project", sourceProject: 1},
+		{name: "fork", sourceProject: 2, wantErr: ErrForkPullRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload := fmt.Sprintf(`{"project": {"path_with_namespace": "group/repo"},
+				"object_attributes": {"iid": 7, "action": "open", "source_branch": "feature", "target_branch": "main",
+					"source_project_id": %d, "target_project_id": 1, "last_commit": {"id": "abc123"}}}`, test.sourceProject)
+			evt, err := (&GitlabProvider{}).ParseWebhook("Merge Request Hook", []byte(payload))
+			if err != test.wantErr {
+				t.Fatalf("ParseWebhook() returned error %v, want %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if evt.Ref != "refs/merge-requests/7/merge" || evt.Commit != "abc123" {
+				t.Errorf("ParseWebhook() = %+v, want the merge ref of merge request 7", evt)
+			}
+		})
+	}
+}
+
+func TestParseGiteaPREventFromFork(t *testing.T) {
+	tests := []struct {
+		name       string
+		headRepoID int
+		wantErr    error
+	}{
+		{name: "same repo", headRepoID: 1},
+		{name: "fork", headRepoID: 2, wantErr: ErrForkPullRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload := fmt.Sprintf(`{"action": "opened", "number": 7, "repository": {"name": "repo", "owner": {"login": "owner"}},
+				"pull_request": {"head": {"ref": "feature", "sha": "abc123", "repo_id": %d}, "base": {"ref": "main", "repo_id": 1}}}`,
+				test.headRepoID)
+			evt, err := (&GiteaProvider{}).ParseWebhook("pull_request", []byte(payload))
+			if err != test.wantErr {
+				t.Fatalf("ParseWebhook() returned error %v, want %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if evt.Ref != "refs/pull/7/head" || evt.Commit != "abc123" {
+				t.Errorf("ParseWebhook() = %+v, want the head ref of pull request 7", evt)
+			}
+		})
+	}
+}
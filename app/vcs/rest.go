@@ -0,0 +1,131 @@
+package vcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// restClient sends JSON requests to a REST API on behalf of the owner of a token
+// It's shared by the clients of the providers without a Go library e.g GitLab and Gitea,
+// and by the Github App to mint its installation tokens
+type restClient struct {
+	// apiURL is the URL the request paths are relative to e.g https://gitlab.com/api/v4
+	apiURL string
+	// token is the OAuth or personal access token the requests are made with
+	token      string
+	httpClient *http.Client
+}
+
+// APIError is the error returned for REST API responses with an error status code
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *APIError) Error() string {
+	return fmt.Sprintf("API error %d: %s", err.StatusCode, err.Message)
+}
+
+// isAPINotFound returns true if the error is a REST API not found error
+func isAPINotFound(err error) bool {
+	errResp, ok := err.(*APIError)
+	return ok && errResp.StatusCode == http.StatusNotFound
+}
+
+func newRESTClient(apiURL, token string) *restClient {
+	return &restClient{
+		apiURL:     strings.TrimSuffix(apiURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do sends the API request with the JSON encoding of the body, if any,
+// and decodes the JSON response into out, if set
+func (client *restClient) do(method, path string, query url.Values, body, out interface{}) error {
+	resp, err := client.send(method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// getIfChanged sends a GET request conditional on the given ETag, if set, and decodes the JSON response into out
+// It returns the ETag of the response, or ErrNotModified if the resource hasn't changed
+func (client *restClient) getIfChanged(path string, query url.Values, etag string, out interface{}) (string, error) {
+	req, err := client.newRequest("GET", path, query, nil)
+	if err != nil {
+		return "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return etag, ErrNotModified
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return "", &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return resp.Header.Get("ETag"), json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send sends the API request and returns the response if it has a success status code
+func (client *restClient) send(method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	req, err := client.newRequest(method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// newRequest returns the API request with the token and the JSON encoding of the body, if any
+func (client *restClient) newRequest(method, path string, query url.Values, body interface{}) (*http.Request, error) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+
+	reqURL := client.apiURL + path
+	if len(query) > 0 {
+		reqURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, reqURL, &reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+client.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
@@ -0,0 +1,140 @@
+package vcs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// newFakeAPI returns a fake API answering the requests with the responses keyed by their escaped path and query
+// The requests to the other paths are answered with 404 Not Found
+func newFakeAPI(t *testing.T, responses map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"etag"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		key := r.URL.EscapedPath()
+		if r.URL.RawQuery != "" {
+			key += "?" + r.URL.RawQuery
+		}
+		body, ok := responses[key]
+		if !ok {
+			http.Error(w, `{"message": "404 Not Found"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGitlabHasWriteAccess(t *testing.T) {
+	server := newFakeAPI(t, map[string]string{
+		"/api/v4/users?username=developer":             `[{"id": 1}]`,
+		"/api/v4/users?username=reporter":              `[{"id": 2}]`,
+		"/api/v4/users?username=outsider":              `[{"id": 3}]`,
+		"/api/v4/projects/group%2Frepo/members/all/1":  `{"access_level": 30}`,
+		"/api/v4/projects/group%2Frepo/members/all/2":  `{"access_level": 20}`,
+		"/api/v4/users?username=ghost":                 `[]`,
+		"/api/v4/projects/group%2Fother/members/all/1": `{"access_level": 10}`,
+	})
+	client := NewGitlabClient(server.URL, "token")
+
+	tests := []struct {
+		login string
+		repo  string
+		want  bool
+	}{
+		{"developer", "repo", true},
+		{"reporter", "repo", false},
+		{"outsider", "repo", false},
+		{"ghost", "repo", false},
+		{"developer", "other", false},
+	}
+	for _, test := range tests {
+		got, err := client.HasWriteAccess(RequestParams{Owner: "group", Repo: test.repo}, test.login)
+		if err != nil {
+			t.Errorf("HasWriteAccess(%s, %s) returned %s", test.repo, test.login, err)
+		}
+		if got != test.want {
+			t.Errorf("HasWriteAccess(%s, %s) = %t, want %t", test.repo, test.login, got, test.want)
+		}
+	}
+}
+
+func TestGiteaHasWriteAccess(t *testing.T) {
+	server := newFakeAPI(t, map[string]string{
+		"/api/v1/repos/owner/repo/collaborators/writer/permission": `{"permission": "write"}`,
+		"/api/v1/repos/owner/repo/collaborators/admin/permission":  `{"permission": "admin"}`,
+		"/api/v1/repos/owner/repo/collaborators/owner/permission":  `{"permission": "owner"}`,
+		"/api/v1/repos/owner/repo/collaborators/reader/permission": `{"permission": "read"}`,
+	})
+	client := NewGiteaClient(server.URL, "token")
+
+	tests := []struct {
+		login   string
+		want    bool
+		wantErr bool
+	}{
+		{"writer", true, false},
+		{"admin", true, false},
+		{"owner", true, false},
+		{"reader", false, false},
+		{"outsider", false, true},
+	}
+	for _, test := range tests {
+		got, err := client.HasWriteAccess(RequestParams{Owner: "owner", Repo: "repo"}, test.login)
+		if (err != nil) != test.wantErr {
+			t.Errorf("HasWriteAccess(%s) returned error %v, want an error: %t", test.login, err, test.wantErr)
+		}
+		if test.wantErr && !IsPermissionError(err) {
+			t.Errorf("HasWriteAccess(%s) returned %v, want a permission error", test.login, err)
+		}
+		if got != test.want {
+			t.Errorf("HasWriteAccess(%s) = %t, want %t", test.login, got, test.want)
+		}
+	}
+}
+
+func TestBranchHeads(t *testing.T) {
+	server := newFakeAPI(t, map[string]string{
+		"/api/v4/projects/group%2Frepo/repository/branches?per_page=100": `[{"name": "main", "commit": {"id": "abc"}},
+			{"name": "feature", "commit": {"id": "def"}}]`,
+		"/api/v1/repos/owner/repo/branches?limit=50": `[{"name": "main", "commit": {"id": "abc"}},
+			{"name": "feature", "commit": {"id": "def"}}]`,
+	})
+	want := map[string]string{"main": "abc", "feature": "def"}
+
+	tests := []struct {
+		name   string
+		client Client
+		params RequestParams
+	}{
+		{"gitlab", NewGitlabClient(server.URL, "token"), RequestParams{Owner: "group", Repo: "repo"}},
+		{"gitea", NewGiteaClient(server.URL, "token"), RequestParams{Owner: "owner", Repo: "repo"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			heads, etag, err := test.client.BranchHeads(test.params, "")
+			if err != nil {
+				t.Fatalf("BranchHeads() returned %s", err)
+			}
+			if !reflect.DeepEqual(heads, want) || etag != `"etag"` {
+				t.Errorf("BranchHeads() = %v, %s, want %v, \"etag\"", heads, etag, want)
+			}
+
+			heads, etag, err = test.client.BranchHeads(test.params, etag)
+			if err != ErrNotModified || heads != nil || etag != `"etag"` {
+				t.Errorf("BranchHeads() with the ETag = %v, %s, %v, want ErrNotModified", heads, etag, err)
+			}
+
+			_, _, err = test.client.BranchHeads(RequestParams{Owner: "owner", Repo: "missing"}, "")
+			if !isAPINotFound(err) {
+				t.Errorf("BranchHeads() of a missing repo returned %v, want 404", err)
+			}
+		})
+	}
+}
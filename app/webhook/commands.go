@@ -0,0 +1,190 @@
+package webhook
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"newproj/app/vcs"
+	"newproj/ci"
+)
+
+// commandPrefix starts the lines of the comments that are sicuro commands e.g /sicuro retry
+const commandPrefix = "/sicuro"
+
+const (
+	// commandRetry builds the head commit of the pull request, or the commented commit, again
+	commandRetry = "retry"
+	// commandCancel cancels the running build of the head commit of the pull request, or of the commented commit
+	commandCancel = "cancel"
+	// commandBisect looks for the first bad commit since the last successful build, without reverting it
+	commandBisect = "bisect"
+	// commandRevert reverts the given commit, or the first bad commit before it, on the default branch
+	commandRevert = "revert"
+)
+
+// commandUsage is the reply to the comments with an unknown command
+const commandUsage = "Sicuro understands `" + commandPrefix + " retry`, `" + commandPrefix + " cancel`, `" +
+	commandPrefix + " bisect` and `" + commandPrefix + " revert <sha>`."
+
+var commitRegex = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// parseCommand returns the command and the arguments of the first line of the comment starting with /sicuro
+// It returns false if the comment has no sicuro command
+func parseCommand(body string) (string, []string, bool) {
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != commandPrefix {
+			continue
+		}
+		if len(fields) == 1 {
+			return "", nil, true
+		}
+		return strings.ToLower(fields[1]), fields[2:], true
+	}
+	return "", nil, false
+}
+
+// runCommentCommand runs the sicuro command of the comment, if the commenter can push to the repo
+// It reacts to the comment with the outcome, and replies with the link to the build the command started, if any
+// It updates the delivery with the outcome and returns the matching HTTP status code
+func runCommentCommand(p vcs.Provider, d *Delivery, evt *vcs.Event, host string) int {
+	command, args, ok := parseCommand(evt.Comment.Body)
+	if !ok {
+		d.Status = DeliveryIgnored
+		return http.StatusOK
+	}
+
+	params := vcs.RequestParams{Owner: evt.Repo.Owner, Repo: evt.Repo.Name}
+	client := p.ServerClient(params.Owner, params.Repo)
+	if client == nil {
+		fmt.Printf("No server credentials to run the command of comment %d\n", evt.Comment.ID)
+		d.Status = DeliveryFailed
+		d.Error = "no server credentials to check the permission of the commenter"
+		return http.StatusOK
+	}
+
+	allowed, err := client.HasWriteAccess(params, evt.Comment.Author)
+	if err != nil {
+		d.Status = DeliveryFailed
+		d.Error = err.Error()
+		return http.StatusInternalServerError
+	}
+	if !allowed {
+		fmt.Printf("Ignoring the command of %s, who can't push to %s\n", evt.Comment.Author, evt.Repo.FullName)
+		client.ReactToComment(params, evt.Comment, "-1")
+		d.Status = DeliveryIgnored
+		d.Error = fmt.Sprintf("%s can't push to the repo", evt.Comment.Author)
+		return http.StatusOK
+	}
+
+	reply, code := runCommand(p, client, d, evt, command, args, host)
+	reaction := "rocket"
+	switch {
+	case d.Status == DeliveryFailed || d.Status == DeliveryIgnored:
+		reaction = "confused"
+	case d.Status == DeliveryCanceled:
+		reaction = "+1"
+	}
+	client.ReactToComment(params, evt.Comment, reaction)
+	client.ReplyToComment(params, evt.Comment, reply)
+	return code
+}
+
+// runCommand runs the command for the pull request or the commit of the comment event
+// It returns the reply to the comment and the HTTP status code of the delivery
+func runCommand(p vcs.Provider, client vcs.Client, d *Delivery, evt *vcs.Event, command string, args []string, host string) (string, int) {
+	switch command {
+	case commandRetry, commandCancel, commandBisect, commandRevert:
+	default:
+		d.Status = DeliveryFailed
+		d.Error = fmt.Sprintf("unknown command %q", command)
+		return commandUsage, http.StatusOK
+	}
+	if evt.Repo.Language == "" {
+		evt.Repo.Language = repoLanguage(p, evt.Repo)
+	}
+
+	// the commands of pull request comments are for the current head commit of the pull request
+	target := evt
+	if evt.PullRequest != 0 {
+		pr, err := client.PullRequest(vcs.RequestParams{Owner: evt.Repo.Owner, Repo: evt.Repo.Name}, evt.PullRequest)
+		if err == vcs.ErrForkPullRequest {
+			d.Status = DeliveryIgnored
+			d.Error = err.Error()
+			return "Sicuro doesn't build pull requests from forks.", http.StatusOK
+		}
+		if err != nil {
+			d.Status = DeliveryFailed
+			d.Error = err.Error()
+			return fmt.Sprintf("Sicuro couldn't look up the pull request: %s", err), http.StatusInternalServerError
+		}
+		if pr.Repo.Language == "" {
+			pr.Repo.Language = evt.Repo.Language
+		}
+		target = pr
+	}
+
+	var job *ci.JobDetails
+	switch command {
+	case commandRetry:
+		if target.Type == vcs.EventPullRequest {
+			job, _ = buildPREventJob(target)
+		} else {
+			job = newEventJob(target, target.Commit)
+		}
+		setServerCallbacks(p, job, host)
+	case commandCancel:
+		reason := fmt.Sprintf("It was canceled with %s cancel by %s", commandPrefix, evt.Comment.Author)
+		if ci.CancelCommitJobs(target.Repo.FullName, target.Commit, reason) == 0 {
+			d.Status = DeliveryIgnored
+			return fmt.Sprintf("No build of %s is running.", target.Commit), http.StatusOK
+		}
+		d.Status = DeliveryCanceled
+		d.Job = filepath.Join(target.Repo.FullName, target.Commit)
+		return fmt.Sprintf("The build of %s has been canceled: %s", target.Commit, buildURL(host, d.Job)), http.StatusOK
+	case commandBisect, commandRevert:
+		commit := target.Commit
+		if command == commandRevert {
+			if len(args) != 1 || !commitRegex.MatchString(args[0]) {
+				d.Status = DeliveryFailed
+				d.Error = "revert needs the commit to revert"
+				return fmt.Sprintf("`%s revert` needs the hash of the commit to revert.", commandPrefix), http.StatusOK
+			}
+			commit = args[0]
+		}
+		// only the go image has the bisect and revert steps
+		if !strings.EqualFold(target.Repo.Language, "go") {
+			d.Status = DeliveryFailed
+			d.Error = "bisect and revert are only supported for go projects"
+			return "Sicuro can only bisect and revert go projects.", http.StatusOK
+		}
+		// the bisect and revert jobs don't report on the commit, their outcome is in their log
+		job = newEventJob(target, commit)
+		// The commit keeps its own build log, the command is logged next to it
+		job.LogFileName += "-" + command
+		job.IsRevert = "1"
+		job.BisectOnly = command == commandBisect
+		job.CloneToken = p.CloneToken(target.Repo.Owner, target.Repo.Name)
+	}
+
+	if err := ci.Run(job); err != nil {
+		d.Status = DeliveryFailed
+		d.Error = err.Error()
+		if err == ci.ErrJobInProgress {
+			return fmt.Sprintf("A build of %s is already running: %s", job.ProjectBranch, buildURL(host, job.LogFileName)), http.StatusOK
+		}
+		return fmt.Sprintf("Sicuro couldn't start the %s of %s: %s", command, job.ProjectBranch, err), http.StatusInternalServerError
+	}
+
+	d.Status = DeliveryQueued
+	d.Job = job.LogFileName
+	return fmt.Sprintf("Started the %s of %s: %s", command, job.ProjectBranch, buildURL(host, job.LogFileName)), http.StatusAccepted
+}
+
+// buildURL returns the URL of the build page of the job with the given log file name
+func buildURL(host, logFileName string) string {
+	return fmt.Sprintf("http://%s/ci/%s", host, logFileName)
+}
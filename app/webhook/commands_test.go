@@ -0,0 +1,30 @@
+package webhook
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		body        string
+		wantCommand string
+		wantArgs    []string
+		wantOK      bool
+	}{
+		{"/sicuro retry", "retry", []string{}, true},
+		{"Flaky again\n  /sicuro RERUN  failed  \nthanks", "rerun", []string{"failed"}, true},
+		{"/sicuro", "", nil, true},
+		{"/sicuro retry\n/sicuro cancel", "retry", []string{}, true},
+		{"Looks good", "", nil, false},
+		{"please /sicuro retry", "", nil, false},
+		{"/sicuroretry", "", nil, false},
+	}
+	for _, test := range tests {
+		command, args, ok := parseCommand(test.body)
+		if command != test.wantCommand || !reflect.DeepEqual(args, test.wantArgs) || ok != test.wantOK {
+			t.Errorf("parseCommand(%q) = %q, %q, %t, want %q, %q, %t",
+				test.body, command, args, ok, test.wantCommand, test.wantArgs, test.wantOK)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"newproj/app/vcs"
+	"newproj/ci"
+)
+
+const (
+	// DeliveryQueued is the status of a delivery that started a job
+	DeliveryQueued = "queued"
+	// DeliveryIgnored is the status of a delivery with no job to run
+	DeliveryIgnored = "ignored"
+	// DeliveryRejected is the status of a delivery that could not be parsed or verified
+	DeliveryRejected = "rejected"
+	// DeliveryFailed is the status of a delivery whose job could not be built or started
+	DeliveryFailed = "failed"
+	// DeliveryDuplicate is the status of a delivery whose ID has already been received
+	DeliveryDuplicate = "duplicate"
+	// DeliveryCoalesced is the status of a delivery whose trigger has already started a job
+	DeliveryCoalesced = "coalesced"
+	// DeliverySkipped is the status of a delivery whose job was skipped e.g by the branch filters
+	DeliverySkipped = "skipped"
+	// DeliveryCanceled is the status of a delivery whose comment command canceled a job
+	DeliveryCanceled = "canceled"
+
+	deliveryFileExt = ".json"
+	// deliveryListLimit is the max number of deliveries returned by Deliveries
+	deliveryListLimit = 100
+	// deliveryRetention is how long the deliveries are kept
+	deliveryRetention = 30 * 24 * time.Hour
+	// maxDeliveries is the most deliveries kept, the oldest ones are deleted first
+	maxDeliveries = 5000
+	// pruneInterval is how often the deliveries are pruned
+	pruneInterval = time.Minute
+	// triggerWindow is how long after its job was started a push or tag trigger is coalesced,
+	// so that a push seen by both the webhook and the poller, or a tag pushed and released, is built once
+	triggerWindow = 15 * time.Minute
+)
+
+// deliveryDIR is the absolute path to the directory the webhook deliveries are saved in
+var deliveryDIR = filepath.Join(filepath.Dir(ci.LogDIR), "deliveries")
+
+// secretHeaders are the headers that carry a secret in plain text e.g the GitLab webhook token
+// They are redacted from the saved deliveries, the signature headers are kept
+var secretHeaders = []string{"X-Gitlab-Token", "Authorization"}
+
+// redactedHeader is the value the secret headers are saved with
+const redactedHeader = "[redacted]"
+
+// Delivery is the record of a webhook request received from the VCS
+type Delivery struct {
+	// Key uniquely identifies the record. Replays of a delivery share its ID but not its key
+	Key string
+	// Provider is the ID of the provider the delivery was received from
+	// It's empty for the deliveries received from Github before there were other providers
+	Provider string
+	// ID is the delivery ID set by the VCS
+	ID string
+	// Event is the name of the webhook event
+	Event      string
+	Headers    map[string][]string
+	Payload    string
+	Verified   bool
+	ReceivedAt time.Time
+	// ReplayOf is the key of the delivery this delivery replays, if any
+	ReplayOf string
+	// Status is one of the Delivery* statuses
+	Status string
+	// Error explains why the delivery was rejected, failed or skipped
+	Error string
+	// Trigger identifies what the delivery would build i.e project, commit and event
+	// Deliveries with the same trigger are coalesced into the first job
+	Trigger string
+	// Job is the log file name of the job started for the delivery, if any
+	// For coalesced deliveries it's the job of the first delivery with the same trigger
+	Job string
+}
+
+// lastPrune is when the deliveries were last pruned, pruneMu guards it
+var (
+	lastPrune time.Time
+	pruneMu   sync.Mutex
+)
+
+var (
+	// seenDeliveries holds when the verified deliveries were received, keyed by their provider and ID
+	// They are forgotten with their records, after the deliveryRetention, or right away if the delivery failed
+	seenDeliveries map[string]time.Time
+	// seenTriggers holds the job last started for each trigger
+	seenTriggers map[string]triggerJob
+	seenOnce     sync.Once
+	seenMu       sync.Mutex
+)
+
+// triggerJob is the job started for a trigger, and when it was started
+type triggerJob struct {
+	Job       string
+	StartedAt time.Time
+}
+
+// loadSeen builds the indexes of the seen deliveries and triggers from the saved deliveries
+// The triggers are only those of the jobs started within the triggerWindow, the older ones are not coalesced
+func loadSeen() {
+	seenDeliveries = map[string]time.Time{}
+	seenTriggers = map[string]triggerJob{}
+	for _, d := range readDeliveries(0) {
+		if d.Verified && d.ReplayOf == "" && d.Status != DeliveryFailed {
+			seenDeliveries[seenKey(d)] = d.ReceivedAt
+		}
+		if d.Status == DeliveryQueued && d.Trigger != "" && time.Since(d.ReceivedAt) < triggerWindow {
+			if _, ok := seenTriggers[d.Trigger]; !ok {
+				seenTriggers[d.Trigger] = triggerJob{d.Job, d.ReceivedAt}
+			}
+		}
+	}
+}
+
+// seenKey identifies the delivery among those of all the providers
+func seenKey(d *Delivery) string {
+	if d.Provider == "" {
+		return vcs.GithubID + ":" + d.ID
+	}
+	return d.Provider + ":" + d.ID
+}
+
+// markDeliverySeen records the delivery ID as seen
+// It returns false if the ID had already been seen
+func markDeliverySeen(d *Delivery) bool {
+	seenOnce.Do(loadSeen)
+	seenMu.Lock()
+	defer seenMu.Unlock()
+
+	key := seenKey(d)
+	if _, ok := seenDeliveries[key]; ok {
+		return false
+	}
+	for seen, receivedAt := range seenDeliveries {
+		if time.Since(receivedAt) > deliveryRetention {
+			delete(seenDeliveries, seen)
+		}
+	}
+	seenDeliveries[key] = time.Now()
+	return true
+}
+
+// forgetFailedDelivery forgets the delivery ID if the delivery failed, so that its redelivery isn't dropped
+func forgetFailedDelivery(d *Delivery) {
+	if d.Status != DeliveryFailed {
+		return
+	}
+	seenMu.Lock()
+	defer seenMu.Unlock()
+
+	delete(seenDeliveries, seenKey(d))
+}
+
+// claimTrigger records the job as the one started for the trigger
+// If the job started for the trigger is still queued or running, or was started less than the given window ago,
+// it returns that job and false. The pull request triggers have no window, so that reopening a pull request builds it again
+func claimTrigger(trigger, job string, window time.Duration) (string, bool) {
+	seenOnce.Do(loadSeen)
+	seenMu.Lock()
+	defer seenMu.Unlock()
+
+	if existing, ok := seenTriggers[trigger]; ok && (time.Since(existing.StartedAt) < window || ci.JobActive(existing.Job)) {
+		return existing.Job, false
+	}
+	for seen, existing := range seenTriggers {
+		if time.Since(existing.StartedAt) > triggerWindow && !ci.JobActive(existing.Job) {
+			delete(seenTriggers, seen)
+		}
+	}
+	seenTriggers[trigger] = triggerJob{job, time.Now()}
+	return job, true
+}
+
+// releaseTrigger forgets the job claimed for the trigger e.g when the job could not be started
+func releaseTrigger(trigger string) {
+	seenOnce.Do(loadSeen)
+	seenMu.Lock()
+	defer seenMu.Unlock()
+
+	delete(seenTriggers, trigger)
+}
+
+func newDelivery() *Delivery {
+	now := time.Now()
+	return &Delivery{
+		Key:        strconv.FormatInt(now.UnixNano(), 10),
+		ReceivedAt: now,
+	}
+}
+
+func saveDelivery(d *Delivery) {
+	if err := os.MkdirAll(deliveryDIR, 0755); err != nil {
+		log.Println("Error while creating deliveries directory", err)
+		return
+	}
+
+	saved := *d
+	saved.Headers = redactHeaders(d.Headers)
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		log.Printf("Error %s occurred while encoding delivery %s\n", err, d.ID)
+		return
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(deliveryDIR, d.Key+deliveryFileExt), data, 0644); err != nil {
+		log.Printf("Error %s occurred while saving delivery %s\n", err, d.ID)
+	}
+	pruneDeliveries()
+}
+
+// pruneDeliveries deletes the deliveries older than the retention, and the oldest ones over maxDeliveries
+// It runs at most once every pruneInterval
+func pruneDeliveries() {
+	pruneMu.Lock()
+	defer pruneMu.Unlock()
+	if time.Since(lastPrune) < pruneInterval {
+		return
+	}
+	lastPrune = time.Now()
+
+	files, err := ioutil.ReadDir(deliveryDIR)
+	if err != nil {
+		log.Println("Error reading deliveries directory", err)
+		return
+	}
+	// the keys are the times the deliveries were received at, newest first
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() > files[j].Name() })
+	oldest := strconv.FormatInt(time.Now().Add(-deliveryRetention).UnixNano(), 10)
+	kept := 0
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), deliveryFileExt) {
+			continue
+		}
+		if kept < maxDeliveries && strings.TrimSuffix(file.Name(), deliveryFileExt) >= oldest {
+			kept++
+			continue
+		}
+		if err := os.Remove(filepath.Join(deliveryDIR, file.Name())); err != nil {
+			log.Printf("Error %s occurred while deleting delivery %s\n", err, file.Name())
+		}
+	}
+}
+
+// FindDelivery returns the delivery with the given key
+func FindDelivery(key string) (*Delivery, error) {
+	if key == "" || strings.ContainsAny(key, `/\.`) {
+		return nil, fmt.Errorf("invalid delivery key: %q", key)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(deliveryDIR, key+deliveryFileExt))
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Delivery{}
+	err = json.Unmarshal(data, d)
+	// the deliveries saved before the secrets were redacted
+	d.Headers = redactHeaders(d.Headers)
+	return d, err
+}
+
+// redactHeaders returns a copy of the headers with the values of the secretHeaders redacted
+func redactHeaders(headers map[string][]string) map[string][]string {
+	if headers == nil {
+		return nil
+	}
+	redacted := make(map[string][]string, len(headers))
+	for name, values := range headers {
+		redacted[name] = values
+		for _, secret := range secretHeaders {
+			if http.CanonicalHeaderKey(name) == secret {
+				redacted[name] = []string{redactedHeader}
+			}
+		}
+	}
+	return redacted
+}
+
+// Deliveries returns the most recent deliveries, newest first
+func Deliveries() []*Delivery {
+	return readDeliveries(deliveryListLimit)
+}
+
+// readDeliveries returns up to limit saved deliveries, newest first
+// All the deliveries are returned if limit is 0
+func readDeliveries(limit int) []*Delivery {
+	deliveries := []*Delivery{}
+	files, err := ioutil.ReadDir(deliveryDIR)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Error reading deliveries directory", err)
+		}
+		return deliveries
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() > files[j].Name() })
+	for _, file := range files {
+		if limit > 0 && len(deliveries) == limit {
+			break
+		}
+		if !strings.HasSuffix(file.Name(), deliveryFileExt) {
+			continue
+		}
+
+		d, err := FindDelivery(strings.TrimSuffix(file.Name(), deliveryFileExt))
+		if err != nil {
+			log.Printf("Error %s occurred while reading delivery %s\n", err, file.Name())
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries
+}
@@ -0,0 +1,213 @@
+package webhook
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"newproj/app/vcs"
+)
+
+// fakeProvider is a provider whose webhooks are verified and parsed as set, the other methods are not implemented
+type fakeProvider struct {
+	vcs.Provider
+	verified bool
+	evt      *vcs.Event
+	err      error
+}
+
+func (p *fakeProvider) ID() string { return "fake" }
+
+func (p *fakeProvider) Name() string { return "Fake" }
+
+func (p *fakeProvider) VerifyWebhook(headers http.Header, payload []byte) bool { return p.verified }
+
+func (p *fakeProvider) ParseWebhook(event string, payload []byte) (*vcs.Event, error) {
+	return p.evt, p.err
+}
+
+// useTestDeliveries saves the deliveries of the test in a temporary directory, with empty seen indexes
+func useTestDeliveries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deliveries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := deliveryDIR
+	deliveryDIR = dir
+	seenOnce.Do(func() {})
+	seenDeliveries = map[string]time.Time{}
+	seenTriggers = map[string]triggerJob{}
+	t.Cleanup(func() {
+		deliveryDIR = original
+		os.RemoveAll(dir)
+	})
+}
+
+func TestSaveDeliveryRedactsSecrets(t *testing.T) {
+	useTestDeliveries(t)
+
+	d := newDelivery()
+	d.Headers = map[string][]string{
+		"X-Gitlab-Token": {"webhook-secret"},
+		"X-Gitlab-Event": {"Push Hook"},
+	}
+	saveDelivery(d)
+
+	data, err := ioutil.ReadFile(filepath.Join(deliveryDIR, d.Key+deliveryFileExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "webhook-secret") {
+		t.Errorf("the saved delivery has the webhook secret: %s", data)
+	}
+	if d.Headers["X-Gitlab-Token"][0] != "webhook-secret" {
+		t.Errorf("saving the delivery changed its headers")
+	}
+
+	saved, err := FindDelivery(d.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := saved.Headers["X-Gitlab-Token"]; len(got) != 1 || got[0] != redactedHeader {
+		t.Errorf("got token header %q, want it redacted", got)
+	}
+	if got := saved.Headers["X-Gitlab-Event"]; len(got) != 1 || got[0] != "Push Hook" {
+		t.Errorf("got event header %q, want it kept", got)
+	}
+}
+
+func TestFindDeliveryRedactsOldRecords(t *testing.T) {
+	useTestDeliveries(t)
+
+	record := `{"Key": "1", "ID": "old", "Headers": {"X-Gitlab-Token": ["webhook-secret"]}}`
+	if err := ioutil.WriteFile(filepath.Join(deliveryDIR, "1"+deliveryFileExt), []byte(record), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := FindDelivery("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Headers["X-Gitlab-Token"]; len(got) != 1 || got[0] != redactedHeader {
+		t.Errorf("got token header %q, want it redacted", got)
+	}
+}
+
+func TestReplayKeepsVerification(t *testing.T) {
+	useTestDeliveries(t)
+
+	// the secret header is redacted, so the replayed delivery doesn't verify anymore
+	p := &fakeProvider{verified: false}
+	tests := []struct {
+		verified bool
+		want     string
+	}{
+		{true, DeliveryIgnored},
+		{false, DeliveryRejected},
+	}
+	for _, test := range tests {
+		d := newDelivery()
+		d.ID, d.Event, d.Verified = "delivery", "push", test.verified
+		handleDelivery(p, d, "example.com", true)
+		if d.Status != test.want {
+			t.Errorf("replay of a delivery verified: %t has status %s, want %s", test.verified, d.Status, test.want)
+		}
+	}
+}
+
+func TestHandleDeliveryDuplicates(t *testing.T) {
+	tests := []struct {
+		name string
+		// first is the provider the delivery is first handled with, and then its redelivery
+		first, redelivery *fakeProvider
+		wantFirst         string
+		wantRedelivery    string
+	}{
+		{
+			name:           "handled delivery",
+			first:          &fakeProvider{verified: true},
+			redelivery:     &fakeProvider{verified: true},
+			wantFirst:      DeliveryIgnored,
+			wantRedelivery: DeliveryDuplicate,
+		},
+		{
+			name:           "failed delivery",
+			first:          &fakeProvider{verified: true, err: errors.New("invalid payload")},
+			redelivery:     &fakeProvider{verified: true},
+			wantFirst:      DeliveryFailed,
+			wantRedelivery: DeliveryIgnored,
+		},
+		{
+			name:           "rejected delivery",
+			first:          &fakeProvider{verified: false},
+			redelivery:     &fakeProvider{verified: true},
+			wantFirst:      DeliveryRejected,
+			wantRedelivery: DeliveryIgnored,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestDeliveries(t)
+
+			d := newDelivery()
+			d.ID, d.Event = "delivery-1", "push"
+			handleDelivery(test.first, d, "example.com", false)
+			if d.Status != test.wantFirst {
+				t.Fatalf("the delivery has status %s, want %s", d.Status, test.wantFirst)
+			}
+			saveDelivery(d)
+
+			// the seen deliveries loaded from the records at startup don't have the failed ones either
+			loadSeen()
+			if _, seen := seenDeliveries[seenKey(d)]; seen != (test.wantFirst == DeliveryIgnored) {
+				t.Errorf("the delivery is seen after a restart: %t, want %t", seen, !seen)
+			}
+
+			redelivery := newDelivery()
+			redelivery.ID, redelivery.Event = "delivery-1", "push"
+			handleDelivery(test.redelivery, redelivery, "example.com", false)
+			if redelivery.Status != test.wantRedelivery {
+				t.Errorf("the redelivery has status %s, want %s", redelivery.Status, test.wantRedelivery)
+			}
+		})
+	}
+}
+
+func TestClaimTrigger(t *testing.T) {
+	useTestDeliveries(t)
+
+	if job, ok := claimTrigger("owner/repo@abc:push", "owner/repo/abc", time.Hour); !ok || job != "owner/repo/abc" {
+		t.Fatalf("claimTrigger() of a new trigger = %s, %t, want the job claimed", job, ok)
+	}
+	if job, ok := claimTrigger("owner/repo@abc:push", "owner/repo/abc-2", time.Hour); ok || job != "owner/repo/abc" {
+		t.Errorf("claimTrigger() within the window = %s, %t, want the first job", job, ok)
+	}
+	if _, ok := claimTrigger("owner/repo@def:push", "owner/repo/def", time.Hour); !ok {
+		t.Errorf("claimTrigger() of another trigger wasn't claimed")
+	}
+
+	// the pull request triggers have no window, their job is not running
+	if _, ok := claimTrigger("owner/repo@abc:pull_request", "owner/repo/abc", 0); !ok {
+		t.Errorf("claimTrigger() of a new pull request trigger wasn't claimed")
+	}
+	if _, ok := claimTrigger("owner/repo@abc:pull_request", "owner/repo/abc", 0); !ok {
+		t.Errorf("claimTrigger() of a pull request trigger whose job is done wasn't claimed")
+	}
+
+	releaseTrigger("owner/repo@abc:push")
+	if _, ok := claimTrigger("owner/repo@abc:push", "owner/repo/abc-3", time.Hour); !ok {
+		t.Errorf("claimTrigger() of a released trigger wasn't claimed")
+	}
+
+	// the triggers older than the triggerWindow are swept
+	seenTriggers["owner/repo@old:push"] = triggerJob{"owner/repo/old", time.Now().Add(-2 * triggerWindow)}
+	claimTrigger("owner/repo@ghi:push", "owner/repo/ghi", time.Hour)
+	if _, ok := seenTriggers["owner/repo@old:push"]; ok {
+		t.Errorf("the expired trigger wasn't swept")
+	}
+}
@@ -0,0 +1,86 @@
+package webhook
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"newproj/app/vcs"
+	"newproj/ci"
+)
+
+var (
+	// skipCIRegex matches the [skip ci] and [ci skip] directives in a commit message
+	skipCIRegex = regexp.MustCompile(`(?i)\[(skip ci|ci skip)\]`)
+	// skipTrailerRegex matches the Sicuro-Skip: true trailer in the trailers of a commit message
+	skipTrailerRegex = regexp.MustCompile(`(?im)^Sicuro-Skip:\s*true\s*$`)
+)
+
+// hasSkipDirective returns true if the commit message asks not to be built
+func hasSkipDirective(message string) bool {
+	return skipCIRegex.MatchString(message) || skipTrailerRegex.MatchString(trailers(message))
+}
+
+// trailers returns the trailers of a commit message i.e its last paragraph, if it is not the subject
+func trailers(message string) string {
+	message = strings.TrimSpace(strings.Replace(message, "\r\n", "\n", -1))
+	i := strings.LastIndex(message, "\n\n")
+	if i < 0 {
+		return ""
+	}
+	return message[i+2:]
+}
+
+// allHaveSkipDirective returns true if there are messages and all of them ask not to be built
+func allHaveSkipDirective(messages []string) bool {
+	for _, message := range messages {
+		if !hasSkipDirective(message) {
+			return false
+		}
+	}
+	return len(messages) > 0
+}
+
+// pushSkipReason returns why the push of the given files should not be built
+// The filters of the project settings are applied first, then those of the pipeline config
+// It returns an empty string if the push should be built
+func pushSkipReason(p vcs.Provider, job *ci.JobDetails, files []string) string {
+	settings, err := ci.LoadProjectSettings(job.LogDirPath)
+	if err != nil {
+		fmt.Printf("Error loading settings for %s. Error: %s\n", job.LogDirPath, err)
+	}
+	if reason := settings.Filters.SkipReason(job.BranchName, files); reason != "" {
+		return "project settings: " + reason
+	}
+
+	config := fetchPipelineConfig(p, job)
+	if config == nil {
+		return ""
+	}
+	if reason := config.SkipReason(job.BranchName, files); reason != "" {
+		return "pipeline config: " + reason
+	}
+	return ""
+}
+
+// fetchPipelineConfig fetches and parses the pipeline config at the job's commit from the provider
+// It returns nil if the config can't be fetched e.g the project doesn't have one
+func fetchPipelineConfig(p vcs.Provider, job *ci.JobDetails) *ci.PipelineConfig {
+	params := jobRequestParams(job)
+	client := p.ServerClient(params.Owner, params.Repo)
+	if client == nil {
+		return nil
+	}
+
+	data, err := client.FileContent(params, ci.PipelineConfigFile)
+	if err != nil {
+		return nil
+	}
+
+	config, err := ci.ParsePipelineConfig(data)
+	if err != nil {
+		fmt.Printf("Error parsing pipeline config for %s. Error: %s\n", job.LogFileName, err)
+		return nil
+	}
+	return config
+}
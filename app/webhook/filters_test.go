@@ -0,0 +1,41 @@
+package webhook
+
+import "testing"
+
+func TestHasSkipDirective(t *testing.T) {
+	tests := []struct {
+		message string
+		want    bool
+	}{
+		{"Fix the build", false},
+		{"Update the docs [skip ci]", true},
+		{"Update the docs [CI SKIP]", true},
+		{"Update the docs\n\nSicuro-Skip: true", true},
+		{"Update the docs\r\n\r\nThe body\r\n\r\nSigned-off-by: someone\r\nsicuro-skip:  TRUE\r\n", true},
+		{"Update the docs\n\nSicuro-Skip: false", false},
+		{"Sicuro-Skip: true", false},
+		{"Update the docs\n\nSicuro-Skip: true\n\nThe trailer is not at the end", false},
+		{"Update the docs\n\nThe body mentions Sicuro-Skip: true", false},
+	}
+	for _, test := range tests {
+		if got := hasSkipDirective(test.message); got != test.want {
+			t.Errorf("hasSkipDirective(%q) = %t, want %t", test.message, got, test.want)
+		}
+	}
+}
+
+func TestAllHaveSkipDirective(t *testing.T) {
+	tests := []struct {
+		messages []string
+		want     bool
+	}{
+		{nil, false},
+		{[]string{"Docs [skip ci]", "More docs [ci skip]"}, true},
+		{[]string{"Docs [skip ci]", "Fix the build"}, false},
+	}
+	for _, test := range tests {
+		if got := allHaveSkipDirective(test.messages); got != test.want {
+			t.Errorf("allHaveSkipDirective(%q) = %t, want %t", test.messages, got, test.want)
+		}
+	}
+}
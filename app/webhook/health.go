@@ -0,0 +1,120 @@
+package webhook
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"newproj/app/vcs"
+	"newproj/ci"
+)
+
+const (
+	// HealthOK is the status of a passing health check
+	HealthOK = "ok"
+	// HealthWarning is the status of a health check that may need attention
+	HealthWarning = "warning"
+	// HealthFailing is the status of a health check that stops the pushes from being built
+	HealthFailing = "failing"
+)
+
+// HealthCheck is a check of the project health panel
+type HealthCheck struct {
+	Name string
+	// Status is one of ok, warning or failing
+	Status string
+	Detail string
+}
+
+// SecretID returns the ID of the webhook secret saved in the project settings
+func SecretID(secret string) string {
+	sum := sha256.Sum256([]byte("sicuro webhook secret " + secret))
+	return hex.EncodeToString(sum[:8])
+}
+
+// HealthChecks returns the checks of the project's webhook, its secret and its recent deliveries
+// secret is the server's current webhook secret
+func HealthChecks(p vcs.Provider, health *vcs.WebhookHealth, settings *ci.ProjectSettings, secret string) []HealthCheck {
+	if !health.Found {
+		return []HealthCheck{{"Webhook", HealthFailing, "The repo has no Sicuro webhook, pushes aren't built. Repair to create it."}}
+	}
+
+	checks := []HealthCheck{}
+	switch {
+	case !health.Active:
+		checks = append(checks, HealthCheck{"Webhook", HealthFailing, fmt.Sprintf("The webhook is disabled on %s.", p.Name())})
+	case health.Hooks > 1:
+		checks = append(checks, HealthCheck{"Webhook", HealthWarning, fmt.Sprintf("The repo has %d Sicuro webhooks, each push is delivered %d times.", health.Hooks, health.Hooks)})
+	default:
+		checks = append(checks, HealthCheck{"Webhook", HealthOK, "Active"})
+	}
+
+	if len(health.MissingEvents) > 0 {
+		checks = append(checks, HealthCheck{"Events", HealthFailing, "Not subscribed to " + strings.Join(health.MissingEvents, ", ")})
+	} else {
+		checks = append(checks, HealthCheck{"Events", HealthOK, strings.Join(health.Events, ", ")})
+	}
+
+	if health.ContentType != "json" {
+		checks = append(checks, HealthCheck{"Content type", HealthFailing, fmt.Sprintf("The payloads are sent as %q, Sicuro only reads json.", health.ContentType)})
+	} else {
+		checks = append(checks, HealthCheck{"Content type", HealthOK, health.ContentType})
+	}
+
+	switch {
+	case !health.HasSecret:
+		checks = append(checks, HealthCheck{"Secret", HealthFailing, "The webhook has no secret, Sicuro rejects its deliveries."})
+	case settings.WebhookSecretID == "":
+		checks = append(checks, HealthCheck{"Secret", HealthWarning, "The webhook was set before its secret was tracked. Repair it if its deliveries are rejected."})
+	case settings.WebhookSecretID != SecretID(secret):
+		checks = append(checks, HealthCheck{"Secret", HealthFailing, "The server's secret was rotated after the webhook was set, Sicuro rejects its deliveries."})
+	default:
+		checks = append(checks, HealthCheck{"Secret", HealthOK, "Set with the server's current secret"})
+	}
+
+	return append(checks, deliveryHealthChecks(p, health)...)
+}
+
+// deliveryHealthChecks returns the checks of the recent deliveries of the webhook, if the provider reports them
+func deliveryHealthChecks(p vcs.Provider, health *vcs.WebhookHealth) []HealthCheck {
+	if health.Deliveries == nil {
+		return []HealthCheck{{"Deliveries", HealthWarning, fmt.Sprintf("%s doesn't report the deliveries of the webhook.", p.Name())}}
+	}
+	if len(health.Deliveries) == 0 {
+		return []HealthCheck{{"Deliveries", HealthOK, "Nothing has been delivered yet"}}
+	}
+
+	failed := 0
+	for _, d := range health.Deliveries {
+		if d.Failed() {
+			failed++
+		}
+	}
+	lastSuccess := "Last successful delivery: " + health.LastSuccess.Format(time.RFC1123)
+	if health.LastSuccess.IsZero() {
+		lastSuccess = "None of the recent deliveries succeeded"
+	}
+
+	switch {
+	case health.Deliveries[0].Failed():
+		latest := health.Deliveries[0]
+		detail := fmt.Sprintf("The latest delivery failed: %s. %d of the last %d failed. %s", latest.Status, failed, len(health.Deliveries), lastSuccess)
+		return []HealthCheck{{"Deliveries", HealthFailing, detail}}
+	case failed > 0:
+		detail := fmt.Sprintf("%d of the last %d deliveries failed. %s", failed, len(health.Deliveries), lastSuccess)
+		return []HealthCheck{{"Deliveries", HealthWarning, detail}}
+	}
+	return []HealthCheck{{"Deliveries", HealthOK, lastSuccess}}
+}
+
+// HealthFailed returns true if any of the checks isn't ok
+func HealthFailed(checks []HealthCheck) bool {
+	for _, check := range checks {
+		if check.Status != HealthOK {
+			return true
+		}
+	}
+	return false
+}
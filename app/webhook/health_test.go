@@ -0,0 +1,127 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+
+	"newproj/app/vcs"
+	"newproj/ci"
+)
+
+// healthyWebhook returns the health of a webhook that passes all the checks
+func healthyWebhook() *vcs.WebhookHealth {
+	return &vcs.WebhookHealth{
+		Found:       true,
+		Hooks:       1,
+		Active:      true,
+		Events:      []string{"push", "pull_request"},
+		ContentType: "json",
+		HasSecret:   true,
+		Deliveries:  []vcs.HookDelivery{{ID: "2", StatusCode: 200}, {ID: "1", StatusCode: 200}},
+		LastSuccess: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	const secret = "secret"
+	tests := []struct {
+		name     string
+		change   func(h *vcs.WebhookHealth, s *ci.ProjectSettings)
+		check    string
+		want     string
+		wantAll  int
+		wantFail bool
+	}{
+		{name: "healthy", change: func(h *vcs.WebhookHealth, s *ci.ProjectSettings) {}, check: "Webhook", want: HealthOK},
+		{
+			name:     "missing webhook",
+			change:   func(h *vcs.WebhookHealth, s *ci.ProjectSettings) { h.Found = false },
+			check:    "Webhook",
+			want:     HealthFailing,
+			wantAll:  1,
+			wantFail: true,
+		},
+		{name: "disabled", change: func(h *vcs.WebhookHealth, s *ci.ProjectSettings) { h.Active = false }, check: "Webhook", want: HealthFailing, wantFail: true},
+		{name: "duplicate webhooks", change: func(h *vcs.WebhookHealth, s *ci.ProjectSettings) { h.Hooks = 2 }, check: "Webhook", want: HealthWarning, wantFail: true},
+		{
+			name:     "missing events",
+			change:   func(h *vcs.WebhookHealth, s *ci.ProjectSettings) { h.MissingEvents = []string{"issue_comment"} },
+			check:    "Events",
+			want:     HealthFailing,
+			wantFail: true,
+		},
+		{name: "form payloads", change: func(h *vcs.WebhookHealth, s *ci.ProjectSettings) { h.ContentType = "form" }, check: "Content type", want: HealthFailing, wantFail: true},
+		{name: "no secret", change: func(h *vcs.WebhookHealth, s *ci.ProjectSettings) { h.HasSecret = false }, check: "Secret", want: HealthFailing, wantFail: true},
+		{name: "untracked secret", change: func(h *vcs.WebhookHealth, s *ci.ProjectSettings) { s.WebhookSecretID = "" }, check: "Secret", want: HealthWarning, wantFail: true},
+		{
+			name:     "rotated secret",
+			change:   func(h *vcs.WebhookHealth, s *ci.ProjectSettings) { s.WebhookSecretID = SecretID("old secret") },
+			check:    "Secret",
+			want:     HealthFailing,
+			wantFail: true,
+		},
+		{
+			name:     "deliveries not reported",
+			change:   func(h *vcs.WebhookHealth, s *ci.ProjectSettings) { h.Deliveries = nil },
+			check:    "Deliveries",
+			want:     HealthWarning,
+			wantFail: true,
+		},
+		{name: "no deliveries", change: func(h *vcs.WebhookHealth, s *ci.ProjectSettings) { h.Deliveries = []vcs.HookDelivery{} }, check: "Deliveries", want: HealthOK},
+		{
+			name:     "latest delivery failed",
+			change:   func(h *vcs.WebhookHealth, s *ci.ProjectSettings) { h.Deliveries[0].StatusCode = 500 },
+			check:    "Deliveries",
+			want:     HealthFailing,
+			wantFail: true,
+		},
+		{
+			name:     "older delivery failed",
+			change:   func(h *vcs.WebhookHealth, s *ci.ProjectSettings) { h.Deliveries[1].StatusCode = 0 },
+			check:    "Deliveries",
+			want:     HealthWarning,
+			wantFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			health := healthyWebhook()
+			settings := &ci.ProjectSettings{WebhookSecretID: SecretID(secret)}
+			test.change(health, settings)
+
+			checks := HealthChecks(&fakeProvider{}, health, settings, secret)
+			wantAll := test.wantAll
+			if wantAll == 0 {
+				wantAll = 5
+			}
+			if len(checks) != wantAll {
+				t.Fatalf("HealthChecks() returned %d checks, want %d: %+v", len(checks), wantAll, checks)
+			}
+			for _, check := range checks {
+				want := HealthOK
+				if check.Name == test.check {
+					want = test.want
+				}
+				if check.Status != want {
+					t.Errorf("%s check is %s (%s), want %s", check.Name, check.Status, check.Detail, want)
+				}
+			}
+			if failed := HealthFailed(checks); failed != test.wantFail {
+				t.Errorf("HealthFailed() = %t, want %t", failed, test.wantFail)
+			}
+		})
+	}
+}
+
+func TestSecretID(t *testing.T) {
+	if SecretID("secret") != SecretID("secret") {
+		t.Error("SecretID() of the same secret differs")
+	}
+	if SecretID("secret") == SecretID("rotated") {
+		t.Error("SecretID() of different secrets is the same")
+	}
+	if id := SecretID("secret"); len(id) != 16 {
+		t.Errorf("SecretID() = %q, want 16 hex characters", id)
+	}
+}
@@ -0,0 +1,131 @@
+package webhook
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"newproj/app/vcs"
+	"newproj/ci"
+)
+
+// defaultLanguage is the language of the projects the provider doesn't know the language of
+const defaultLanguage = "go"
+
+// buildEventJob returns the job for the webhook event of the provider
+func buildEventJob(p vcs.Provider, evt *vcs.Event) (*ci.JobDetails, error) {
+	if evt.Repo.Language == "" {
+		evt.Repo.Language = repoLanguage(p, evt.Repo)
+	}
+
+	switch evt.Type {
+	case vcs.EventPing:
+		return buildPingEventJob(evt)
+	case vcs.EventPush:
+		return buildPushEventJob(p, evt)
+	case vcs.EventTag:
+		return buildTagEventJob(p, evt)
+	case vcs.EventPullRequest:
+		return buildPREventJob(evt)
+	}
+	return nil, nil
+}
+
+// repoLanguage looks up the language of the repo with the provider's server client
+// as some providers don't send it in their webhook payloads
+func repoLanguage(p vcs.Provider, repo vcs.Repo) string {
+	if client := p.ServerClient(repo.Owner, repo.Name); client != nil {
+		r, err := client.Repo(vcs.RequestParams{Owner: repo.Owner, Repo: repo.Name})
+		if err == nil && r.Language != "" {
+			return r.Language
+		}
+	}
+	return defaultLanguage
+}
+
+// newEventJob returns the job for the given commit of the event's repo
+func newEventJob(evt *vcs.Event, commit string) *ci.JobDetails {
+	return &ci.JobDetails{
+		LogFileName:            filepath.Join(evt.Repo.FullName, commit),
+		LogDirPath:             filepath.Join(evt.Repo.FullName),
+		ProjectBranch:          commit,
+		BaseBranchName:         evt.Repo.DefaultBranch,
+		ProjectRepositoryURL:   evt.Repo.CloneURL,
+		ProjectLanguage:        evt.Repo.Language,
+		ProjectRespositoryName: evt.Repo.Name,
+		Pusher:                 ci.Person{Name: evt.Pusher.Name, Email: evt.Pusher.Email},
+	}
+}
+
+// commitAuthors returns the authors of the pushed commits, or of the head commit if the commits are unknown
+func commitAuthors(evt *vcs.Event) (authors []ci.Person) {
+	for _, c := range evt.Commits {
+		authors = append(authors, ci.Person{Name: c.Author.Name, Email: c.Author.Email})
+	}
+	if len(evt.Commits) == 0 && evt.HeadCommit.ID != "" {
+		authors = append(authors, ci.Person{Name: evt.HeadCommit.Author.Name, Email: evt.HeadCommit.Author.Email})
+	}
+	return
+}
+
+// buildPushEventJob builds the pushed commit of a branch
+// It's skipped if the commit messages ask to, or the branch and path filters filter it out
+func buildPushEventJob(p vcs.Provider, evt *vcs.Event) (*ci.JobDetails, error) {
+	job := newEventJob(evt, evt.Commit)
+	job.BranchName = evt.Branch
+	job.CommitAuthors = commitAuthors(evt)
+
+	files := []string{}
+	messages := []string{}
+	for _, c := range evt.Commits {
+		files = append(files, c.Files...)
+		messages = append(messages, c.Message)
+	}
+
+	if hasSkipDirective(evt.HeadCommit.Message) || allHaveSkipDirective(messages) {
+		job.SkipReason = "the commit message asks to skip ci"
+		return job, nil
+	}
+	job.SkipReason = pushSkipReason(p, job, files)
+	return job, nil
+}
+
+// buildTagEventJob builds the tagged commit of a pushed tag or a published release
+// Tag builds are not filtered and run the release steps of the pipeline config
+// When the event doesn't have the commit, the tag is resolved to its commit with the provider's
+// server client, if any, to report the build status on it
+func buildTagEventJob(p vcs.Provider, evt *vcs.Event) (*ci.JobDetails, error) {
+	commit := evt.Commit
+	if commit == "" {
+		commit = evt.Tag
+		if client := p.ServerClient(evt.Repo.Owner, evt.Repo.Name); client != nil {
+			params := vcs.RequestParams{Owner: evt.Repo.Owner, Repo: evt.Repo.Name}
+			if sha, err := client.CommitSHA(params, "refs/tags/"+evt.Tag); err == nil {
+				commit = sha
+			}
+		}
+	}
+
+	job := newEventJob(evt, commit)
+	job.TagName = evt.Tag
+	job.CommitAuthors = commitAuthors(evt)
+	return job, nil
+}
+
+// buildPREventJob builds the merge ref of the pull request
+// The status is reported on the head commit, and a new commit cancels the build of the previous one
+func buildPREventJob(evt *vcs.Event) (*ci.JobDetails, error) {
+	job := newEventJob(evt, evt.Commit)
+	job.ProjectRef = evt.Ref
+	job.BranchName = evt.Branch
+	job.BaseBranchName = evt.BaseBranch
+	job.PullRequest = evt.PullRequest
+	job.Group = fmt.Sprintf("%s#%d", evt.Repo.FullName, evt.PullRequest)
+	return job, nil
+}
+
+func buildPingEventJob(evt *vcs.Event) (*ci.JobDetails, error) {
+	branch := "master"
+	job := newEventJob(evt, branch)
+	job.BranchName = branch
+	return job, nil
+}
@@ -0,0 +1,166 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"newproj/app/vcs"
+	"newproj/ci"
+)
+
+// PollEvent is the event of the deliveries recorded for the new commits found by polling
+const PollEvent = "poll"
+
+// pollWorkers is how many projects are polled at the same time
+const pollWorkers = 4
+
+// PollProjects checks the branches of the polled projects for new commits every interval
+// The projects are polled concurrently, so that a remote that doesn't answer only holds up its own project
+// The new commits are built as pushes, and recorded as deliveries of the project's provider
+// host is the address of the server the build statuses link to
+func PollProjects(interval time.Duration, host string) {
+	for {
+		var wg sync.WaitGroup
+		workers := make(chan struct{}, pollWorkers)
+		for _, projectDir := range ci.PolledProjects() {
+			wg.Add(1)
+			workers <- struct{}{}
+			go func(projectDir string) {
+				defer wg.Done()
+				pollProject(projectDir, host)
+				<-workers
+			}(projectDir)
+		}
+		wg.Wait()
+		time.Sleep(interval)
+	}
+}
+
+// pollProject builds the new commits of the project's polled branches
+// The branches are listed with the provider's server client, or with git ls-remote if there's none
+// The first check records the heads of the branches without building them
+// Only the polling state is saved, so that the settings saved while the branches are listed are kept
+func pollProject(projectDir, host string) {
+	settings, err := ci.LoadProjectSettings(projectDir)
+	if err != nil || settings.Polling == nil {
+		return
+	}
+
+	polling := settings.Polling
+	polling.LastCheck = time.Now()
+	owner, project := filepath.Split(projectDir)
+	params := vcs.RequestParams{Owner: filepath.Clean(owner), Repo: project}
+
+	p := vcs.FindProvider(settings.Provider)
+	if settings.Provider == "" {
+		p = vcs.FindProvider(vcs.GithubID)
+	}
+
+	var heads map[string]string
+	etag := polling.ETag
+	if p == nil {
+		err = fmt.Errorf("provider %q is not set up", settings.Provider)
+	} else if client := p.ServerClient(params.Owner, params.Repo); client != nil {
+		heads, etag, err = client.BranchHeads(params, polling.ETag)
+	} else {
+		heads, err = ci.RemoteBranches(polling.URL)
+	}
+
+	switch {
+	case err == vcs.ErrNotModified:
+		polling.Error = ""
+	case err != nil:
+		fmt.Printf("Error polling %s. Error: %s\n", projectDir, err)
+		polling.Error = err.Error()
+	default:
+		polling.Error = ""
+		polled := map[string]string{}
+		for branch, commit := range heads {
+			if !polling.Polls(branch) {
+				continue
+			}
+			polled[branch] = commit
+			if polling.Heads != nil && polling.Heads[branch] != commit {
+				fmt.Println("Polled new commit", commit, "of", projectDir, branch)
+				handlePolledCommit(p, params, polling, branch, commit, host)
+			}
+		}
+		polling.Heads = polled
+		polling.ETag = etag
+	}
+
+	err = ci.UpdateProjectSettings(projectDir, func(saved *ci.ProjectSettings) {
+		if saved.Polling == nil {
+			return
+		}
+		saved.Polling.Heads = polling.Heads
+		saved.Polling.ETag = polling.ETag
+		saved.Polling.LastCheck = polling.LastCheck
+		saved.Polling.Error = polling.Error
+	})
+	if err != nil {
+		fmt.Printf("Error saving polling state of %s. Error: %s\n", projectDir, err)
+	}
+}
+
+// handlePolledCommit builds the new commit of a polled branch as a push, and records it as a delivery
+func handlePolledCommit(p vcs.Provider, params vcs.RequestParams, polling *ci.Polling, branch, commit, host string) {
+	repo := vcs.Repo{
+		Owner:         params.Owner,
+		Name:          params.Repo,
+		FullName:      params.Owner + "/" + params.Repo,
+		Language:      polling.Language,
+		URL:           polling.URL,
+		CloneURL:      polling.URL,
+		DefaultBranch: polling.DefaultBranch,
+	}
+	if client := p.ServerClient(params.Owner, params.Repo); client != nil {
+		if r, err := client.Repo(params); err == nil {
+			repo = *r
+		}
+	}
+
+	evt := &vcs.Event{
+		Type:       vcs.EventPush,
+		Repo:       repo,
+		Commit:     commit,
+		Branch:     branch,
+		HeadCommit: vcs.Commit{ID: commit},
+	}
+	payload, _ := json.Marshal(evt)
+
+	d := newDelivery()
+	d.Provider = p.ID()
+	d.ID = fmt.Sprintf("%s@%s:%s", repo.FullName, branch, commit)
+	d.Event = PollEvent
+	d.Payload = string(payload)
+
+	handlePolledEvent(p, d, host, false)
+	saveDelivery(d)
+}
+
+// handlePolledEvent starts the job for the event of a delivery recorded by the poller
+// The poller found the event itself, so there's no signature to verify
+func handlePolledEvent(p vcs.Provider, d *Delivery, host string, replay bool) {
+	d.Verified = true
+	marked := markDeliverySeen(d)
+	if !marked && !replay {
+		fmt.Printf("Dropping duplicate delivery %s\n", d.ID)
+		d.Status = DeliveryDuplicate
+		return
+	}
+	if marked {
+		defer forgetFailedDelivery(d)
+	}
+
+	evt := &vcs.Event{}
+	if err := json.Unmarshal([]byte(d.Payload), evt); err != nil {
+		d.Status = DeliveryFailed
+		d.Error = err.Error()
+		return
+	}
+	runEventJob(p, d, evt, host, replay)
+}
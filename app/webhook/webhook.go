@@ -0,0 +1,299 @@
+package webhook
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"newproj/app/vcs"
+	"newproj/ci"
+	"path/filepath"
+)
+
+// maxPayloadSize is the largest webhook payload read, the size Github caps its payloads at
+const maxPayloadSize = 25 << 20
+
+// HandleWebhook records the webhook delivery from the provider and starts the job for its event, if any
+// The payloads of the deliveries that are not verified are not recorded
+// It responds with the status of the delivery
+func HandleWebhook(p vcs.Provider, w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxPayloadSize))
+	if err != nil {
+		fmt.Println("Error reading webhook", err)
+		http.Error(w, "Error reading webhook", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	d := newDelivery()
+	d.Provider = p.ID()
+	d.ID, d.Event = p.WebhookDelivery(req.Header, body)
+	d.Headers = req.Header
+	d.Payload = string(body)
+
+	fmt.Println("Received a ", p.Name(), d.Event, "event")
+
+	code := handleDelivery(p, d, req.Host, false)
+	if !d.Verified {
+		d.Headers = nil
+		d.Payload = ""
+	}
+	saveDelivery(d)
+
+	w.WriteHeader(code)
+	fmt.Fprint(w, d.Status)
+}
+
+// ReplayDelivery runs the delivery with the given key through the webhook handling path again
+// The secret headers are not saved, so the replay isn't verified again and keeps the verification of the delivery
+// The replay is saved as a new delivery
+func ReplayDelivery(key, host string) (*Delivery, error) {
+	original, err := FindDelivery(key)
+	if err != nil {
+		return nil, err
+	}
+
+	p := deliveryProvider(original)
+	if p == nil {
+		return nil, fmt.Errorf("provider %q is not set up", original.Provider)
+	}
+
+	d := newDelivery()
+	d.Provider = p.ID()
+	d.ID = original.ID
+	d.Event = original.Event
+	d.Headers = original.Headers
+	d.Payload = original.Payload
+	d.Verified = original.Verified
+	d.ReplayOf = original.Key
+
+	if d.Event == PollEvent {
+		handlePolledEvent(p, d, host, true)
+	} else {
+		handleDelivery(p, d, host, true)
+	}
+	saveDelivery(d)
+	return d, nil
+}
+
+// deliveryProvider returns the provider the delivery was received from
+// Deliveries saved before there were other providers are from Github
+func deliveryProvider(d *Delivery) vcs.Provider {
+	if d.Provider == "" {
+		return vcs.FindProvider(vcs.GithubID)
+	}
+	return vcs.FindProvider(d.Provider)
+}
+
+// handleDelivery verifies the delivery and starts the job for its event, if any
+// Deliveries with an ID that has been seen are dropped, unless replay is set
+// The ID of a failed delivery is forgotten, so that its redelivery is handled again
+// The replays are verified with their original delivery
+// It updates the delivery with the outcome and returns the matching HTTP status code
+func handleDelivery(p vcs.Provider, d *Delivery, host string, replay bool) int {
+	if d.ID == "" || d.Event == "" {
+		d.Status = DeliveryRejected
+		d.Error = "missing delivery ID or event header"
+		return http.StatusBadRequest
+	}
+
+	if !replay {
+		d.Verified = p.VerifyWebhook(http.Header(d.Headers), []byte(d.Payload))
+	}
+	if !d.Verified {
+		d.Status = DeliveryRejected
+		d.Error = "invalid signature"
+		return http.StatusUnauthorized
+	}
+
+	marked := markDeliverySeen(d)
+	if !marked && !replay {
+		fmt.Printf("Dropping duplicate delivery %s\n", d.ID)
+		d.Status = DeliveryDuplicate
+		return http.StatusOK
+	}
+	if marked {
+		defer forgetFailedDelivery(d)
+	}
+
+	evt, err := p.ParseWebhook(d.Event, []byte(d.Payload))
+	if err == vcs.ErrForkPullRequest {
+		fmt.Printf("Ignoring delivery %s: %s\n", d.ID, err)
+		d.Status = DeliveryIgnored
+		d.Error = err.Error()
+		return http.StatusOK
+	}
+	if err != nil {
+		fmt.Printf("Parse error for %s event. Error: %s\n", d.Event, err)
+		d.Status = DeliveryFailed
+		d.Error = err.Error()
+		return http.StatusUnprocessableEntity
+	}
+	if evt != nil && evt.Type == vcs.EventComment {
+		return runCommentCommand(p, d, evt, host)
+	}
+	return runEventJob(p, d, evt, host, replay)
+}
+
+// runEventJob starts the job for the event of the delivery, if any
+// Deliveries with a trigger whose job is still running, or started within the triggerWindow for pushes and tags,
+// are coalesced into that job, unless replay is set
+// It updates the delivery with the outcome and returns the matching HTTP status code
+func runEventJob(p vcs.Provider, d *Delivery, evt *vcs.Event, host string, replay bool) int {
+	var job *ci.JobDetails
+	var err error
+	if evt != nil {
+		job, err = buildEventJob(p, evt)
+		if job != nil && evt.Type != vcs.EventPing {
+			setServerCallbacks(p, job, host)
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("Build job error for %s event. Error: %s\n", d.Event, err)
+		d.Status = DeliveryFailed
+		d.Error = err.Error()
+		return http.StatusUnprocessableEntity
+	}
+
+	if job == nil {
+		fmt.Printf("No job to run for %s event\n", d.Event)
+		d.Status = DeliveryIgnored
+		return http.StatusOK
+	}
+
+	claimed := false
+	if evt.Type != vcs.EventPing {
+		var existing string
+		// tag pushes and the releases published for them build the same tag once
+		d.Trigger = fmt.Sprintf("%s@%s:%s", job.LogDirPath, job.ProjectBranch, evt.Type)
+		window := triggerWindow
+		if evt.Type == vcs.EventPullRequest {
+			window = 0
+		}
+		if existing, claimed = claimTrigger(d.Trigger, job.LogFileName, window); !claimed && !replay {
+			fmt.Printf("Coalescing delivery %s into job %s\n", d.ID, existing)
+			d.Status = DeliveryCoalesced
+			d.Job = existing
+			return http.StatusOK
+		}
+	}
+
+	if err := ci.Run(job); err != nil {
+		if err == ci.ErrJobSkipped {
+			d.Status = DeliverySkipped
+			d.Error = job.SkipReason
+			return http.StatusOK
+		}
+		if claimed {
+			releaseTrigger(d.Trigger)
+		}
+		d.Status = DeliveryFailed
+		d.Error = err.Error()
+		if err == ci.ErrJobInProgress || err == ci.ErrUnsupportedLanguage {
+			return http.StatusOK
+		}
+		return http.StatusInternalServerError
+	}
+
+	d.Status = DeliveryQueued
+	d.Job = job.LogFileName
+	return http.StatusAccepted
+}
+
+// setServerCallbacks sets the callbacks reporting the job's build on the provider with its server credentials,
+// and the token the job clones the repo with, if any
+func setServerCallbacks(p vcs.Provider, job *ci.JobDetails, host string) {
+	// the builds reported with a check run don't post a commit status as well
+	if job.UpdateCheckRun = serverCheckRunUpdater(p, job, host); job.UpdateCheckRun == nil {
+		job.UpdateBuildStatus = serverBuildStatusUpdater(p, job, host)
+	}
+	if job.PullRequest != 0 {
+		job.UpdatePullRequestComment = serverPullRequestCommentUpdater(p, job, host)
+	}
+	params := jobRequestParams(job)
+	job.CloneToken = p.CloneToken(params.Owner, params.Repo)
+}
+
+// jobRequestParams returns the request params for the job's project and commit
+func jobRequestParams(job *ci.JobDetails) vcs.RequestParams {
+	owner, repo := filepath.Split(job.LogDirPath)
+	return vcs.RequestParams{
+		Owner: filepath.Clean(owner),
+		Repo:  repo,
+		Ref:   job.ProjectBranch,
+	}
+}
+
+// serverBuildStatusUpdater returns a function that updates the status of the job's commit
+// using the provider's server credentials for the repo. It returns nil if there are none
+func serverBuildStatusUpdater(p vcs.Provider, job *ci.JobDetails, host string) func(string) {
+	params := jobRequestParams(job)
+	client := p.ServerClient(params.Owner, params.Repo)
+	if client == nil {
+		return nil
+	}
+
+	params.CallbackURL = fmt.Sprintf("http://%s/ci/%s", host, job.LogFileName)
+	return client.UpdateBuildStatus(params)
+}
+
+// serverCheckRunUpdater returns a function that updates the check run of the job's commit
+// using the provider's server credentials for the repo. It returns nil if they can't create check runs
+func serverCheckRunUpdater(p vcs.Provider, job *ci.JobDetails, host string) func(*ci.CheckRun) {
+	params := jobRequestParams(job)
+	client := p.ServerClient(params.Owner, params.Repo)
+	if client == nil {
+		return nil
+	}
+
+	params.CallbackURL = fmt.Sprintf("http://%s/ci/%s", host, job.LogFileName)
+	return client.UpdateCheckRun(params)
+}
+
+// serverPullRequestCommentUpdater returns a function that posts the summary of the job's build on its pull request
+// using the provider's server credentials for the repo. It returns nil if the project hasn't opted in to
+// the comments, or the provider can't post them
+func serverPullRequestCommentUpdater(p vcs.Provider, job *ci.JobDetails, host string) func(*ci.Build) {
+	settings, err := ci.LoadProjectSettings(job.LogDirPath)
+	if err != nil {
+		fmt.Println("Error while loading project settings: ", err)
+	}
+	if !settings.PullRequestComments {
+		return nil
+	}
+
+	params := jobRequestParams(job)
+	client := p.ServerClient(params.Owner, params.Repo)
+	if client == nil {
+		return nil
+	}
+	update := client.UpdatePullRequestComment(params, job.PullRequest)
+	if update == nil {
+		return nil
+	}
+	return func(build *ci.Build) {
+		update(ci.PullRequestComment(build, "http://"+host))
+	}
+}
+
+// ManualTrigger manually triggers the ci job
+func ManualTrigger(repo, owner, sha, language, revert, url, baseBranch, cloneToken string, updateBuildStatusFunc, updateCoverageStatusFunc func(string), updateCheckRunFunc func(*ci.CheckRun)) {
+	job := &ci.JobDetails{
+		LogFileName:            fmt.Sprintf("%s/%s/%s", owner, repo, sha),
+		LogDirPath:             fmt.Sprintf("%s/%s", owner, repo),
+		ProjectBranch:          sha,
+		BaseBranchName:         baseBranch,
+		ProjectRepositoryURL:   url,
+		CloneToken:             cloneToken,
+		ProjectLanguage:        language,
+		ProjectRespositoryName: repo,
+		IsRevert:               revert,
+		UpdateBuildStatus:      updateBuildStatusFunc,
+		UpdateCoverageStatus:   updateCoverageStatusFunc,
+		UpdateCheckRun:         updateCheckRunFunc,
+	}
+
+	fmt.Println("Here's the job details: ", job)
+	if err := ci.Run(job); err != nil {
+		fmt.Println("Job was not started: ", err)
+	}
+}
@@ -0,0 +1,135 @@
+package ci
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// analyticsPeriod is the layout of the day each build's duration is grouped by
+	analyticsPeriod = "2006-01-02"
+	// stepTrendWindow is the number of recent builds compared against the ones before them
+	stepTrendWindow = 5
+	// stepSlowdownThreshold is the ratio of the recent to the previous median step duration
+	// above which a step is considered to have slowed down
+	stepSlowdownThreshold = 1.2
+)
+
+// DurationStats are the build duration percentiles over a period
+type DurationStats struct {
+	Period string
+	Builds int
+	P50    time.Duration
+	P95    time.Duration
+}
+
+// BranchAnalytics are the build duration percentiles of a branch, oldest period first
+type BranchAnalytics struct {
+	Branch  string
+	Periods []DurationStats
+	// Max is the longest duration across the periods. It's used to scale the charts
+	Max time.Duration
+}
+
+// StepTrend compares the median duration of a step in the recent builds against the builds before them
+type StepTrend struct {
+	Name     string
+	Previous time.Duration
+	Recent   time.Duration
+	Slower   bool
+}
+
+// ProjectAnalytics returns the build duration percentiles of each branch of the project
+// and the trend of each step's duration
+func ProjectAnalytics(projectDir string) ([]BranchAnalytics, []StepTrend) {
+	builds := []*Build{}
+	for _, b := range ProjectBuilds(projectDir) {
+		if !b.StartedAt.IsZero() && !b.FinishedAt.IsZero() {
+			builds = append(builds, b)
+		}
+	}
+	return branchAnalytics(builds), stepTrends(builds)
+}
+
+func branchAnalytics(builds []*Build) []BranchAnalytics {
+	durations := map[string]map[string][]time.Duration{}
+	for _, b := range builds {
+		branch := b.Branch
+		if branch == "" {
+			branch = "(unknown)"
+		}
+		if durations[branch] == nil {
+			durations[branch] = map[string][]time.Duration{}
+		}
+		period := b.StartedAt.Format(analyticsPeriod)
+		durations[branch][period] = append(durations[branch][period], b.Duration())
+	}
+
+	analytics := []BranchAnalytics{}
+	for branch, periods := range durations {
+		a := BranchAnalytics{Branch: branch}
+		for period, d := range periods {
+			stats := DurationStats{
+				Period: period,
+				Builds: len(d),
+				P50:    percentile(d, 50),
+				P95:    percentile(d, 95),
+			}
+			if stats.P95 > a.Max {
+				a.Max = stats.P95
+			}
+			a.Periods = append(a.Periods, stats)
+		}
+		sort.Slice(a.Periods, func(i, j int) bool { return a.Periods[i].Period < a.Periods[j].Period })
+		analytics = append(analytics, a)
+	}
+	sort.Slice(analytics, func(i, j int) bool { return analytics[i].Branch < analytics[j].Branch })
+	return analytics
+}
+
+func stepTrends(builds []*Build) []StepTrend {
+	recent := map[string][]time.Duration{}
+	previous := map[string][]time.Duration{}
+	for i, b := range builds {
+		for _, step := range b.Steps {
+			switch {
+			case i >= len(builds)-stepTrendWindow:
+				recent[step.Name] = append(recent[step.Name], step.Duration)
+			case i >= len(builds)-2*stepTrendWindow:
+				previous[step.Name] = append(previous[step.Name], step.Duration)
+			}
+		}
+	}
+
+	trends := []StepTrend{}
+	for name, d := range recent {
+		trend := StepTrend{Name: name, Recent: percentile(d, 50)}
+		if p, ok := previous[name]; ok {
+			trend.Previous = percentile(p, 50)
+			trend.Slower = float64(trend.Recent) > float64(trend.Previous)*stepSlowdownThreshold
+		}
+		trends = append(trends, trend)
+	}
+	sort.Slice(trends, func(i, j int) bool {
+		if trends[i].Slower != trends[j].Slower {
+			return trends[i].Slower
+		}
+		return trends[i].Name < trends[j].Name
+	})
+	return trends
+}
+
+// percentile returns the nearest-rank percentile of the given durations
+func percentile(d []time.Duration, p int) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration{}, d...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
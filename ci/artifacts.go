@@ -0,0 +1,51 @@
+package ci
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// artifactsDirFor returns the directory the test container copies the release artifacts of the job to
+// It returns an empty string if the job is not for a tag
+func artifactsDirFor(job *JobDetails) string {
+	if job.TagName == "" {
+		return ""
+	}
+	return filepath.Join(LogDIR, job.LogDirPath, ArtifactsName, job.TagName)
+}
+
+// collectArtifacts returns the names of the files found in the given artifacts directory
+func collectArtifacts(dir string) (names []string) {
+	if dir == "" {
+		return
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error %s occurred while reading artifacts dir: %s\n", err, dir)
+		}
+		return
+	}
+	for _, file := range files {
+		if file.Mode().IsRegular() {
+			names = append(names, file.Name())
+		}
+	}
+	return
+}
+
+// ArtifactPath returns the absolute path to a release artifact of the given project and tag
+// It returns an empty string if the path would escape the project's artifacts directory
+// projectDir is the project's path relative to the LogDIR i.e owner/project
+func ArtifactPath(projectDir, tag, name string) string {
+	root := filepath.Join(LogDIR, projectDir, ArtifactsName)
+	path := filepath.Join(root, tag, name)
+	if !strings.HasPrefix(path, root+string(filepath.Separator)) || filepath.Base(path) != name {
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,223 @@
+package ci
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const buildFileExt = ".json"
+
+// Build is the record of a single run of a job
+// It's saved in the project's builds directory once the job completes
+type Build struct {
+	// ID uniquely identifies the build within the project
+	ID string
+	// Project is the project's path relative to the LogDIR i.e owner/project
+	Project string
+	// Commit is the commit hash or branch name the build ran against
+	Commit string
+	// Branch is the branch the commit was pushed to, if known
+	Branch string
+	// BaseBranch is the branch the build is compared against
+	BaseBranch string
+	// PullRequest is the number of the pull request the build is for, if any
+	PullRequest int
+	// Tag is the tag the build is for, if any
+	Tag string
+	// Artifacts are the names of the release artifacts the build produced for its tag
+	Artifacts []string
+	// LogFileName is the name of the log file for the build, relative to the LogDIR
+	LogFileName string
+	// Status is the final status of the build i.e success, flaky, failure, error, canceled or skipped
+	Status string
+	// SkipReason explains why the build was skipped, if it was
+	SkipReason string
+	// Tests are the test results collected from the build reports
+	Tests []TestResult
+	// FlakyTests are the tests that failed on the first run but passed when retried
+	FlakyTests []TestResult
+	// Coverage is the code coverage collected from the build reports, if any
+	Coverage *Coverage
+	// QueuedAt is when the job was accepted
+	QueuedAt time.Time
+	// StartedAt is when the test container started
+	StartedAt time.Time
+	// FinishedAt is when the test container exited
+	FinishedAt time.Time
+	// Steps are the timed steps of the build, in the order they ran
+	Steps []Step
+	// Pusher is the person who pushed the commits that triggered the build, if known
+	Pusher Person
+	// Authors are the authors of the commits that triggered the build, if known
+	Authors []Person
+	// Revert is true for the builds of the revert and bisect jobs
+	// They run against older commits, so they don't count as builds of their branch
+	Revert bool
+}
+
+func newBuild(job *JobDetails) *Build {
+	revert, _ := strconv.ParseBool(job.IsRevert)
+	return &Build{
+		ID:          strconv.FormatInt(time.Now().UnixNano(), 10),
+		Project:     job.LogDirPath,
+		Commit:      job.ProjectBranch,
+		Branch:      job.BranchName,
+		BaseBranch:  job.BaseBranchName,
+		PullRequest: job.PullRequest,
+		Tag:         job.TagName,
+		LogFileName: job.LogFileName,
+		QueuedAt:    job.queuedAt,
+		Pusher:      job.Pusher,
+		Authors:     job.CommitAuthors,
+		Revert:      revert,
+	}
+}
+
+// QueueWait returns how long the job waited before the test container started
+func (b *Build) QueueWait() time.Duration {
+	if b.QueuedAt.IsZero() {
+		return 0
+	}
+	return b.StartedAt.Sub(b.QueuedAt)
+}
+
+// Duration returns how long the test container ran for
+func (b *Build) Duration() time.Duration {
+	return b.FinishedAt.Sub(b.StartedAt)
+}
+
+// FailedTests returns the tests that failed in the build
+func (b *Build) FailedTests() (tests []TestResult) {
+	for _, t := range b.Tests {
+		if t.Status == TestFailed {
+			tests = append(tests, t)
+		}
+	}
+	return
+}
+
+// CoverageDelta returns the change in coverage percentage of the build against the given build
+func (b *Build) CoverageDelta(base *Build) float64 {
+	if b.Coverage == nil || base == nil || base.Coverage == nil {
+		return 0
+	}
+	return b.Coverage.Percent() - base.Coverage.Percent()
+}
+
+func buildsDir(projectDir string) string {
+	return filepath.Join(LogDIR, projectDir, BuildsName)
+}
+
+func saveBuild(projectDir string, b *Build) error {
+	dir := buildsDir(projectDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, b.ID+buildFileExt), data, 0644)
+}
+
+// ProjectBuilds returns the saved builds of the given project, oldest first
+// projectDir is the project's path relative to the LogDIR i.e owner/project
+func ProjectBuilds(projectDir string) []*Build {
+	builds := []*Build{}
+	dir := buildsDir(projectDir)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("An error: %s; occurred while reading builds from dir %s\n", err, dir)
+		}
+		return builds
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), buildFileExt) {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			log.Printf("An error: %s; occurred while reading build %s\n", err, file.Name())
+			continue
+		}
+
+		b := &Build{}
+		if err := json.Unmarshal(data, b); err != nil {
+			log.Printf("An error: %s; occurred while parsing build %s\n", err, file.Name())
+			continue
+		}
+		builds = append(builds, b)
+	}
+
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].ID < builds[j].ID
+	})
+	return builds
+}
+
+// LatestBuild returns the most recent build logged to the given log file of the project
+// e.g a commit hash, or the commit hash with a -bisect suffix for the bisect jobs
+// It returns nil if there's no such build yet
+func LatestBuild(projectDir, logName string) *Build {
+	logFileName := filepath.Join(projectDir, logName)
+	builds := ProjectBuilds(projectDir)
+	for i := len(builds) - 1; i >= 0; i-- {
+		if builds[i].LogFileName == logFileName {
+			return builds[i]
+		}
+	}
+	return nil
+}
+
+// BaseBuild returns the most recent build with coverage on the base branch of the given build
+// It returns nil if the base branch is unknown or has no such build
+func BaseBuild(b *Build) *Build {
+	if b.BaseBranch == "" {
+		return nil
+	}
+
+	builds := ProjectBuilds(b.Project)
+	for i := len(builds) - 1; i >= 0; i-- {
+		base := builds[i]
+		if base.ID != b.ID && base.Branch == b.BaseBranch && base.Coverage != nil {
+			return base
+		}
+	}
+	return nil
+}
+
+// skipBuild records the job as a skipped build and reports the skipped status
+// so that required status checks are not left pending
+func skipBuild(job *JobDetails) {
+	build := newBuild(job)
+	build.Status = BuildSkipped
+	build.SkipReason = job.SkipReason
+	if err := saveBuild(job.LogDirPath, build); err != nil {
+		log.Printf("Error %s occurred while saving skipped build for job: %v\n", err, job)
+	}
+	job.updateBuildStatus(BuildSkipped)
+	job.updateCheckRun(&CheckRun{Status: CheckCompleted, Conclusion: BuildSkipped, Title: "Skipped", Summary: checkRunSummary(build)})
+}
+
+// SkippedBuilds returns the skipped builds of the given project, newest first
+func SkippedBuilds(projectDir string) []*Build {
+	skipped := []*Build{}
+	builds := ProjectBuilds(projectDir)
+	for i := len(builds) - 1; i >= 0; i-- {
+		if builds[i].Status == BuildSkipped {
+			skipped = append(skipped, builds[i])
+		}
+	}
+	return skipped
+}
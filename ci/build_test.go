@@ -0,0 +1,61 @@
+package ci
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// useTestLogDIR points the LogDIR to a temporary directory for the duration of the test
+func useTestLogDIR(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logDIR := LogDIR
+	LogDIR = dir
+	t.Cleanup(func() {
+		LogDIR = logDIR
+		os.RemoveAll(dir)
+	})
+}
+
+// saveTestBuilds saves the given builds of the owner/repo project, in order
+func saveTestBuilds(t *testing.T, builds ...*Build) {
+	for _, b := range builds {
+		b.Project = "owner/repo"
+		if err := saveBuild(b.Project, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLatestBuild(t *testing.T) {
+	useTestLogDIR(t)
+	saveTestBuilds(t,
+		&Build{ID: "1", Commit: "abc123", LogFileName: "owner/repo/abc123", Status: "failure"},
+		&Build{ID: "2", Commit: "abc123", LogFileName: "owner/repo/abc123", Status: "success"},
+		&Build{ID: "3", Commit: "abc123", LogFileName: "owner/repo/abc123-bisect", Status: "success", Revert: true},
+	)
+
+	tests := []struct {
+		logName string
+		wantID  string
+	}{
+		{logName: "abc123", wantID: "2"},
+		{logName: "abc123-bisect", wantID: "3"},
+		{logName: "def456"},
+	}
+	for _, test := range tests {
+		build := LatestBuild("owner/repo", test.logName)
+		if test.wantID == "" {
+			if build != nil {
+				t.Errorf("LatestBuild(%q) = build %s, want nil", test.logName, build.ID)
+			}
+			continue
+		}
+		if build == nil || build.ID != test.wantID {
+			t.Errorf("LatestBuild(%q) = %+v, want build %s", test.logName, build, test.wantID)
+		}
+	}
+}
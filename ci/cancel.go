@@ -0,0 +1,179 @@
+package ci
+
+import (
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// BuildCanceled is the status of a build that was canceled before it completed
+const BuildCanceled = "canceled"
+
+const (
+	// cancelSuperseded is the cancel reason of the jobs replaced by a job for a newer commit
+	cancelSuperseded = "A newer commit has been pushed"
+	// cancelUnsubscribed is the cancel reason of the jobs of an unsubscribed project
+	cancelUnsubscribed = "The project was unsubscribed"
+)
+
+var (
+	// runningJobs holds the active jobs of each group
+	runningJobs = map[string]*JobDetails{}
+	// activeJobs holds all the active jobs, grouped or not
+	activeJobs    = map[*JobDetails]bool{}
+	runningJobsMu sync.Mutex
+)
+
+// startJob registers the job as the active job of its group
+// and cancels the job it replaces, if it's for a different commit
+func startJob(job *JobDetails) {
+	runningJobsMu.Lock()
+	job.done = make(chan struct{})
+	activeJobs[job] = true
+	if job.Group == "" {
+		runningJobsMu.Unlock()
+		return
+	}
+
+	stale, ok := runningJobs[job.Group]
+	runningJobs[job.Group] = job
+	runningJobsMu.Unlock()
+
+	// the stale job is canceled without holding the lock as removing its container can take a while
+	if ok && stale.ProjectBranch != job.ProjectBranch {
+		log.Printf("Canceling stale job %s superseded by %s\n", stale.LogFileName, job.LogFileName)
+		stale.cancel(cancelSuperseded)
+	}
+}
+
+// finishJob removes the job from the active jobs of its group
+func finishJob(job *JobDetails) {
+	runningJobsMu.Lock()
+	defer runningJobsMu.Unlock()
+
+	delete(activeJobs, job)
+	close(job.done)
+	if job.Group == "" {
+		return
+	}
+
+	if runningJobs[job.Group] == job {
+		delete(runningJobs, job.Group)
+	}
+}
+
+// cancel marks the job as canceled for the given reason and removes its test container, if it has started
+// The reason is shown in the build log
+func (job *JobDetails) cancel(reason string) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	if !job.canceled {
+		job.canceled = true
+		job.cancelReason = reason
+	}
+	if job.containerName == "" {
+		return
+	}
+
+	if err := exec.Command("docker", "rm", "-f", job.containerName).Run(); err != nil {
+		log.Printf("Error %s occurred while removing container %s\n", err, job.containerName)
+	}
+}
+
+func (job *JobDetails) isCanceled() bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.canceled
+}
+
+// canceledReason returns why the job was canceled, if it was
+func (job *JobDetails) canceledReason() (string, bool) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.cancelReason, job.canceled
+}
+
+// setContainer records the name of the job's current test container
+// It returns false if the job has been canceled and the container shouldn't be started
+func (job *JobDetails) setContainer(name string) bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	job.containerName = name
+	return !job.canceled
+}
+
+// JobActive returns true if the job with the given log file name is queued or running
+func JobActive(logFileName string) bool {
+	runningJobsMu.Lock()
+	defer runningJobsMu.Unlock()
+
+	for job := range activeJobs {
+		if job.LogFileName == logFileName {
+			return true
+		}
+	}
+	return false
+}
+
+// ProjectActiveJobs returns the number of active jobs of the given project
+func ProjectActiveJobs(projectDir string) (count int) {
+	runningJobsMu.Lock()
+	defer runningJobsMu.Unlock()
+
+	for job := range activeJobs {
+		if job.LogDirPath == projectDir {
+			count++
+		}
+	}
+	return
+}
+
+// CancelProjectJobs cancels the active jobs of the given project and waits for them to finish
+// for up to the given timeout. It returns the number of canceled jobs
+func CancelProjectJobs(projectDir string, timeout time.Duration) int {
+	runningJobsMu.Lock()
+	jobs := []*JobDetails{}
+	for job := range activeJobs {
+		if job.LogDirPath == projectDir {
+			jobs = append(jobs, job)
+		}
+	}
+	runningJobsMu.Unlock()
+
+	deadline := time.After(timeout)
+	for _, job := range jobs {
+		log.Printf("Canceling job %s of unsubscribed project %s\n", job.LogFileName, projectDir)
+		job.cancel(cancelUnsubscribed)
+	}
+	for _, job := range jobs {
+		select {
+		case <-job.done:
+		case <-deadline:
+			log.Printf("Timed out waiting for the canceled jobs of %s to finish\n", projectDir)
+			return len(jobs)
+		}
+	}
+	return len(jobs)
+}
+
+// CancelCommitJobs cancels the active jobs of the given commit of the project, without waiting for them to finish
+// The reason is shown in the build logs. It returns the number of canceled jobs
+func CancelCommitJobs(projectDir, commit, reason string) int {
+	runningJobsMu.Lock()
+	jobs := []*JobDetails{}
+	for job := range activeJobs {
+		if job.LogDirPath == projectDir && job.ProjectBranch == commit && !job.isCanceled() {
+			jobs = append(jobs, job)
+		}
+	}
+	runningJobsMu.Unlock()
+
+	for _, job := range jobs {
+		log.Printf("Canceling job %s\n", job.LogFileName)
+		job.cancel(reason)
+	}
+	return len(jobs)
+}
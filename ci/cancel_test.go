@@ -0,0 +1,65 @@
+package ci
+
+import "testing"
+
+func TestStartJobCancelsStaleJob(t *testing.T) {
+	tests := []struct {
+		name         string
+		commit       string
+		wantCanceled bool
+	}{
+		{name: "same commit", commit: "abc123"},
+		{name: "newer commit", commit: "def456", wantCanceled: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stale := &JobDetails{Group: "owner/repo#7", ProjectBranch: "abc123"}
+			job := &JobDetails{Group: "owner/repo#7", ProjectBranch: test.commit}
+			startJob(stale)
+			startJob(job)
+			t.Cleanup(func() {
+				finishJob(job)
+				finishJob(stale)
+			})
+
+			reason, canceled := stale.canceledReason()
+			if canceled != test.wantCanceled {
+				t.Fatalf("stale job canceled = %t, want %t", canceled, test.wantCanceled)
+			}
+			if canceled && reason != cancelSuperseded {
+				t.Errorf("stale job cancel reason = %q, want %q", reason, cancelSuperseded)
+			}
+			if _, canceled := job.canceledReason(); canceled {
+				t.Error("the new job was canceled")
+			}
+		})
+	}
+}
+
+func TestCancelCommitJobsReason(t *testing.T) {
+	job := &JobDetails{LogDirPath: "owner/repo", ProjectBranch: "abc123"}
+	other := &JobDetails{LogDirPath: "owner/repo", ProjectBranch: "def456"}
+	startJob(job)
+	startJob(other)
+	t.Cleanup(func() {
+		finishJob(job)
+		finishJob(other)
+	})
+
+	if n := CancelCommitJobs("owner/repo", "abc123", "It was canceled"); n != 1 {
+		t.Fatalf("CancelCommitJobs() = %d, want 1", n)
+	}
+	if reason, canceled := job.canceledReason(); !canceled || reason != "It was canceled" {
+		t.Errorf("job cancel reason = %q, %t, want %q", reason, canceled, "It was canceled")
+	}
+	if other.isCanceled() {
+		t.Error("the job of another commit was canceled")
+	}
+
+	// the first reason is kept
+	job.cancel(cancelUnsubscribed)
+	if reason, _ := job.canceledReason(); reason != "It was canceled" {
+		t.Errorf("job cancel reason after a second cancel = %q, want %q", reason, "It was canceled")
+	}
+}
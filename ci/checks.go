@@ -0,0 +1,256 @@
+package ci
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// CheckQueued is the status of the check run of a job waiting for its test container
+	CheckQueued = "queued"
+	// CheckInProgress is the status of the check run of a running job
+	CheckInProgress = "in_progress"
+	// CheckCompleted is the status of the check run of a finished job
+	CheckCompleted = "completed"
+
+	// maxAnnotations is the most annotations reported for a job, the most Github accepts in a request
+	maxAnnotations = 50
+)
+
+// CheckRun is the report of a job for the checks of the VCS e.g a Github Check Run
+type CheckRun struct {
+	// Status is one of queued, in_progress or completed
+	Status string
+	// Conclusion is the status of the completed build e.g success, flaky, failure, error, canceled or skipped
+	Conclusion string
+	// Title is a one line summary of the outcome
+	Title string
+	// Summary is the markdown report of the build
+	Summary string
+	// FailedTests is the number of tests that failed
+	FailedTests int
+	// Annotations point at the lines of the errors found in the build
+	Annotations []Annotation
+	StartedAt   time.Time
+	CompletedAt time.Time
+}
+
+// Annotation is an error found in the build output at a line of a file of the repository
+type Annotation struct {
+	// Path is the path of the file relative to the root of the repository
+	Path    string
+	Line    int
+	Title   string
+	Message string
+}
+
+var (
+	// goErrorRegex matches the errors of the go compiler and vet e.g ./pkg/file.go:12:5: undefined: x
+	goErrorRegex = regexp.MustCompile(`^(vet: )?(?:\./)?([^\s:]+\.go):(\d+):(?:\d+:)? (.+)$`)
+	// goTestErrorRegex matches the errors of go tests e.g "    file_test.go:12: got 1, want 2"
+	goTestErrorRegex = regexp.MustCompile(`^\s+([^\s:/]+\.go):(\d+): (.+)$`)
+	goModuleRegex    = regexp.MustCompile(`(?m)^module\s+"?([^"\s]+)"?`)
+)
+
+func (job *JobDetails) updateCheckRun(run *CheckRun) {
+	if job.UpdateCheckRun != nil {
+		job.UpdateCheckRun(run)
+	}
+}
+
+// repoDirFor returns the directory the test container checks the job's repository out to
+func repoDirFor(job *JobDetails) string {
+	return filepath.Join(LogDIR, job.LogDirPath, job.ProjectRespositoryName)
+}
+
+// completedCheckRun returns the check run of the finished build with the annotations
+// of the errors found in its log and test results
+func completedCheckRun(job *JobDetails, build *Build) *CheckRun {
+	run := &CheckRun{
+		Status:      CheckCompleted,
+		Conclusion:  build.Status,
+		FailedTests: len(build.FailedTests()),
+		StartedAt:   build.StartedAt,
+		CompletedAt: build.FinishedAt,
+	}
+	run.Title = checkRunTitle(build, run.FailedTests)
+	run.Summary = checkRunSummary(build)
+
+	if build.Status == "failure" {
+		repoDir := repoDirFor(job)
+		if f, err := os.Open(job.logFilePath); err == nil {
+			run.Annotations = parseBuildErrors(f, repoDir)
+			f.Close()
+		}
+		run.Annotations = append(run.Annotations, testFailureAnnotations(build.FailedTests(), repoDir)...)
+		run.Annotations = uniqueAnnotations(run.Annotations)
+	}
+	return run
+}
+
+func checkRunTitle(build *Build, failedTests int) string {
+	switch build.Status {
+	case "failure":
+		if failedTests == 1 {
+			return "1 test failed"
+		}
+		if failedTests > 0 {
+			return fmt.Sprintf("%d tests failed", failedTests)
+		}
+		return "The build failed"
+	case BuildFlaky:
+		return fmt.Sprintf("All %d tests passed, %d after a retry", len(build.Tests), len(build.FlakyTests))
+	case "error":
+		return "Sicuro couldn't run your tests"
+	case BuildCanceled:
+		return "Canceled, a newer commit has been pushed"
+	case BuildSkipped:
+		return "Skipped"
+	}
+	if len(build.Tests) > 0 {
+		return fmt.Sprintf("All %d tests passed", len(build.Tests))
+	}
+	return "The build passed"
+}
+
+// checkRunSummary returns the markdown report of the build's tests, coverage and steps
+func checkRunSummary(build *Build) string {
+	var b strings.Builder
+	if build.SkipReason != "" {
+		fmt.Fprintf(&b, "Skipped: %s\n", build.SkipReason)
+		return b.String()
+	}
+
+	passed, skipped := 0, 0
+	for _, t := range build.Tests {
+		switch t.Status {
+		case TestPassed:
+			passed++
+		case TestSkipped:
+			skipped++
+		}
+	}
+	failed := build.FailedTests()
+	fmt.Fprintf(&b, "| Tests | Passed | Failed | Skipped | Flaky |\n|---|---|---|---|---|\n| %d | %d | %d | %d | %d |\n",
+		len(build.Tests), passed, len(failed), skipped, len(build.FlakyTests))
+
+	if len(failed) > 0 {
+		b.WriteString("\n### Failed tests\n")
+		for _, t := range failed {
+			name := t.Name
+			if name == "" {
+				name = "(package)"
+			}
+			fmt.Fprintf(&b, "- `%s` %s\n", t.Package, name)
+		}
+	}
+	if len(build.FlakyTests) > 0 {
+		b.WriteString("\n### Flaky tests\n")
+		for _, t := range build.FlakyTests {
+			fmt.Fprintf(&b, "- `%s` %s\n", t.Package, t.Name)
+		}
+	}
+	if build.Coverage != nil {
+		fmt.Fprintf(&b, "\n**Coverage:** %.1f%%\n", build.Coverage.Percent())
+	}
+	if len(build.Steps) > 0 {
+		b.WriteString("\n| Step | Duration |\n|---|---|\n")
+		for _, s := range build.Steps {
+			fmt.Fprintf(&b, "| %s | %s |\n", s.Name, s.Duration.Round(time.Second))
+		}
+	}
+	return b.String()
+}
+
+// parseBuildErrors returns the annotations of the go compiler and vet errors in the build log
+// The errors of files that are not in the repository e.g of the dependencies are left out
+func parseBuildErrors(r io.Reader, repoDir string) (annotations []Annotation) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := html.UnescapeString(strings.TrimRight(scanner.Text(), "\r"))
+		match := goErrorRegex.FindStringSubmatch(line)
+		if match == nil || !repoFileExists(repoDir, match[2]) {
+			continue
+		}
+
+		title := "Build error"
+		if match[1] != "" {
+			title = "go vet"
+		}
+		lineNo, _ := strconv.Atoi(match[3])
+		annotations = append(annotations, Annotation{Path: match[2], Line: lineNo, Title: title, Message: match[4]})
+	}
+	return
+}
+
+// testFailureAnnotations returns the annotations of the errors the failed go tests logged
+// go test only prints the name of the file, so it's looked up in the directory of the test's package
+func testFailureAnnotations(tests []TestResult, repoDir string) (annotations []Annotation) {
+	module := ""
+	if data, err := ioutil.ReadFile(filepath.Join(repoDir, "go.mod")); err == nil {
+		if match := goModuleRegex.FindSubmatch(data); match != nil {
+			module = string(match[1])
+		}
+	}
+
+	for _, t := range tests {
+		pkgDir := t.Package
+		if pkgDir == module {
+			pkgDir = ""
+		} else if module != "" {
+			pkgDir = strings.TrimPrefix(pkgDir, module+"/")
+		}
+		for _, line := range strings.Split(t.Output, "\n") {
+			match := goTestErrorRegex.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			path := filepath.ToSlash(filepath.Join(pkgDir, match[1]))
+			if !repoFileExists(repoDir, path) {
+				continue
+			}
+
+			lineNo, _ := strconv.Atoi(match[2])
+			title := t.Name + " failed"
+			if t.Name == "" {
+				title = "Test failure"
+			}
+			annotations = append(annotations, Annotation{Path: path, Line: lineNo, Title: title, Message: match[3]})
+		}
+	}
+	return
+}
+
+// repoFileExists returns true if the given path is a file within the repository directory
+func repoFileExists(repoDir, path string) bool {
+	if filepath.IsAbs(path) || strings.HasPrefix(filepath.Clean(path), "..") {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(repoDir, path))
+	return err == nil && !info.IsDir()
+}
+
+// uniqueAnnotations drops the repeated annotations e.g of a compile error printed on a retry
+// and keeps at most maxAnnotations
+func uniqueAnnotations(annotations []Annotation) []Annotation {
+	seen := map[Annotation]bool{}
+	unique := []Annotation{}
+	for _, a := range annotations {
+		if seen[a] || len(unique) == maxAnnotations {
+			continue
+		}
+		seen[a] = true
+		unique = append(unique, a)
+	}
+	return unique
+}
@@ -0,0 +1,139 @@
+package ci
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestRepo returns a directory with the given files, removed at the end of the test
+func newTestRepo(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestParseBuildErrors(t *testing.T) {
+	repoDir := newTestRepo(t, map[string]string{"main.go": "", "pkg/file.go": ""})
+
+	tests := []struct {
+		name string
+		log  string
+		want []Annotation
+	}{
+		{
+			name: "compiler error",
+			log:  "# example.com/app/pkg\n./pkg/file.go:12:5: undefined: x\n",
+			want: []Annotation{{Path: "pkg/file.go", Line: 12, Title: "Build error", Message: "undefined: x"}},
+		},
+		{
+			name: "vet error without a column",
+			log:  "vet: main.go:3: unreachable code\r\n",
+			want: []Annotation{{Path: "main.go", Line: 3, Title: "go vet", Message: "unreachable code"}},
+		},
+		{
+			name: "escaped html",
+			log:  "main.go:7:2: cannot use &#34;x&#34; (untyped string constant)\n",
+			want: []Annotation{{Path: "main.go", Line: 7, Title: "Build error", Message: `cannot use "x" (untyped string constant)`}},
+		},
+		{
+			name: "error of a dependency",
+			log:  "/go/pkg/mod/example.com/dep/dep.go:1:1: syntax error\n",
+		},
+		{
+			name: "file outside of the repo",
+			log:  "../outside.go:1:1: syntax error\n",
+		},
+		{
+			name: "missing file",
+			log:  "missing.go:1:1: syntax error\n",
+		},
+		{
+			name: "other output",
+			log:  "ok  \texample.com/app\t0.01s\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parseBuildErrors(strings.NewReader(test.log), repoDir); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseBuildErrors() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTestFailureAnnotations(t *testing.T) {
+	repoDir := newTestRepo(t, map[string]string{
+		"go.mod":           "module example.com/app\n",
+		"app_test.go":      "",
+		"pkg/file_test.go": "",
+	})
+
+	tests := []struct {
+		name  string
+		tests []TestResult
+		want  []Annotation
+	}{
+		{
+			name: "test of the root package",
+			tests: []TestResult{{Package: "example.com/app", Name: "TestApp",
+				Output: "=== RUN   TestApp\n    app_test.go:12: got 1, want 2\n--- FAIL: TestApp (0.00s)\n"}},
+			want: []Annotation{{Path: "app_test.go", Line: 12, Title: "TestApp failed", Message: "got 1, want 2"}},
+		},
+		{
+			name:  "test of a sub package",
+			tests: []TestResult{{Package: "example.com/app/pkg", Name: "TestFile", Output: "    file_test.go:5: failed\n"}},
+			want:  []Annotation{{Path: "pkg/file_test.go", Line: 5, Title: "TestFile failed", Message: "failed"}},
+		},
+		{
+			name:  "package level failure",
+			tests: []TestResult{{Package: "example.com/app", Output: "    app_test.go:1: setup failed\n"}},
+			want:  []Annotation{{Path: "app_test.go", Line: 1, Title: "Test failure", Message: "setup failed"}},
+		},
+		{
+			name:  "file of another package",
+			tests: []TestResult{{Package: "example.com/app/other", Name: "TestOther", Output: "    file_test.go:5: failed\n"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := testFailureAnnotations(test.tests, repoDir); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("testFailureAnnotations() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUniqueAnnotations(t *testing.T) {
+	annotations := []Annotation{}
+	for i := 0; i < maxAnnotations+10; i++ {
+		annotations = append(annotations, Annotation{Path: "main.go", Line: i}, Annotation{Path: "main.go", Line: i})
+	}
+
+	got := uniqueAnnotations(annotations)
+	if len(got) != maxAnnotations {
+		t.Fatalf("uniqueAnnotations() kept %d annotations, want %d", len(got), maxAnnotations)
+	}
+	for i, a := range got {
+		if a.Line != i {
+			t.Errorf("annotation %d is of line %d", i, a.Line)
+		}
+	}
+}
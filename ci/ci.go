@@ -1,14 +1,21 @@
 package ci
 
 import (
+	"errors"
 	"fmt"
 	"github.com/joho/godotenv"
+	"html"
+	"io"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -16,13 +23,42 @@ const (
 	LogFileExt = "e14a5940cc6e873f53d82ce346e7ed6b8ecbdd1d.log"
 	BisectName = "bisect.txt"
 	BackupName = "backup"
+	// BuildsName is the project folder the build records are saved in
+	BuildsName = "builds"
+	// ReportsName is the project folder the test containers write machine readable reports to
+	ReportsName = "reports"
+	// ArtifactsName is the project folder the test containers copy the release artifacts of tag builds to
+	ArtifactsName = "artifacts"
+	// BuildFlaky is the status of a build that only passed after its failed tests were retried
+	BuildFlaky = "flaky"
+	// BuildSkipped is the status of a build that was not run e.g because of the branch or path filters
+	BuildSkipped = "skipped"
 )
 
 var (
+	// ErrJobInProgress is returned by Run when a job for the same log file is in progress
+	ErrJobInProgress = errors.New("a job is currently in progress")
+	// ErrUnsupportedLanguage is returned by Run when there's no image for the project language
+	ErrUnsupportedLanguage = errors.New("project language is currently not supported")
+	// ErrJobSkipped is returned by Run when the job has a SkipReason
+	ErrJobSkipped = errors.New("job skipped")
+
 	// ciDIR is the absolute path to the CI directory
 	ciDIR string
 	// LogDIR is the absolute path to the CI log directory
 	LogDIR string
+	// GithubHost is the host of the Github server e.g github.com or a Github Enterprise server
+	// The repos of the Github App installations are cloned from it over HTTPS, and the backups are pushed to it
+	GithubHost = "github.com"
+	// retryFailedTests reruns a failed build once when some of its tests failed
+	// It's enabled with the RETRY_FAILED_TESTS env variable
+	retryFailedTests bool
+	// localReposDIR is the directory the local repositories of the git projects must be in
+	// It's set with the LOCAL_REPOS_DIR env variable, local repositories are disabled without it
+	localReposDIR string
+	// deployKeySecret encrypts the private keys of the projects' deploy keys
+	// They are generated when it's set with the DEPLOY_KEY_SECRET env variable
+	deployKeySecret string
 	// List of supported languages
 	// and the available docker image version
 	availableImages = map[string]string{
@@ -33,14 +69,19 @@ var (
 )
 
 func init() {
+	// the env may be set without a .env file e.g when running the tests, the app requires one
 	err := godotenv.Load(".env")
 
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		log.Fatalf("Error loading .env file")
 	}
 	ciDIR = filepath.Join(os.Getenv("ROOT_DIR"), "ci")
 	LogDIR = filepath.Join(ciDIR, "logs")
-
+	retryFailedTests, _ = strconv.ParseBool(os.Getenv("RETRY_FAILED_TESTS"))
+	deployKeySecret = os.Getenv("DEPLOY_KEY_SECRET")
+	if dir := os.Getenv("LOCAL_REPOS_DIR"); dir != "" {
+		localReposDIR = filepath.Clean(dir)
+	}
 }
 
 // JobDetails contains necessary information required to run tests for a given project
@@ -51,16 +92,58 @@ type JobDetails struct {
 	LogDirPath  string
 	LogFileName string
 	logFilePath string
+	// queuedAt is when the job was accepted by Run
+	queuedAt time.Time
+	// mu guards canceled, cancelReason and containerName
+	mu            sync.Mutex
+	canceled      bool
+	cancelReason  string
+	containerName string
+	// done is closed once the job finishes
+	done chan struct{}
+	// goodCommit is the last commit that passed, the revert jobs bisect from it
+	goodCommit string
+	// retryTests and retryPackages limit the retry of a failed build to its failed go tests
+	// They're empty for the first run, in which case all the tests run
+	retryTests    string
+	retryPackages string
+	// Group identifies jobs that supersede each other e.g the builds of a pull request
+	// Starting a job cancels the active job of its group if it's for a different commit
+	Group string
+	// PullRequest is the number of the pull request the job is for, if any
+	PullRequest int
+	// SkipReason explains why the job should not run e.g the pushed branch is filtered out
+	// Jobs with a SkipReason are recorded as skipped builds instead of running
+	SkipReason string
+	// ProjectRef is a ref to fetch and check out instead of the ProjectBranch, if set
+	// e.g the merge ref of a pull request. The ProjectBranch is checked out if it can't be fetched
+	ProjectRef string
 	// IsRevert show that jov is running for commit revert
 	IsRevert string
+	// BisectOnly stops a revert job once it has found the first bad commit, without reverting it
+	BisectOnly bool
 	// ProjectRespositoryName is the name of the project's repository on the VCS
 	// It's used when cloning the project in test container
 	ProjectRespositoryName string
 	// ProjectBranch is the target branch to run the tests on
 	// It could also be a commit hash if the target is a particular commit
 	ProjectBranch string
+	// BranchName is the name of the branch the commit was pushed to, if known
+	BranchName string
+	// BaseBranchName is the branch the build is compared against e.g the repo's default branch
+	BaseBranchName string
+	// TagName is the tag the job is for, if it was triggered by a tag push or a release
+	// The release steps of the pipeline config only run for jobs with a tag
+	TagName string
+	// Pusher is the person who pushed the commits, if known
+	Pusher Person
+	// CommitAuthors are the authors of the pushed commits, if known
+	CommitAuthors []Person
 	// ProjectRespositoryURL is the SSH url for pull the code from the VCS
 	ProjectRepositoryURL string
+	// CloneToken is a token the test container clones the repository with over HTTPS instead, if set
+	// e.g the token of the Github App installation on the repository
+	CloneToken string
 	// ProjectLanguage is the programming language the project is written in
 	// This would be used to determine the docker image for running the tests
 	ProjectLanguage string
@@ -69,45 +152,63 @@ type JobDetails struct {
 	// Once the tests starts, it's executed with the pending status argument
 	// At test completion it would be executed again with the result status: success or failure
 	UpdateBuildStatus func(string)
-}
-
-func init() {
-	// set ci path env variable
-	os.Setenv("CI_DIR", ciDIR)
+	// UpdateCoverageStatus is a callback function that would be executed with a description
+	// of the build's coverage and its change against the base branch once the tests complete
+	UpdateCoverageStatus func(string)
+	// UpdateCheckRun is a callback function that would be executed with the check run of the job
+	// when it's queued, once the tests start and once they complete with the report of the build
+	UpdateCheckRun func(*CheckRun)
+	// UpdatePullRequestComment is a callback function that would be executed with the build
+	// of a pull request job once the tests complete, to post its summary on the pull request
+	UpdatePullRequestComment func(*Build)
 }
 
 // Run triggers the CI server for the given job
 // It builds the absolute path to the job log file, creating necessary parent directories
 // It terminates if a routine is currently active for the given job
 // Otherwise, sets up a new routine for the job
-func Run(job *JobDetails) {
+// Jobs with a SkipReason are recorded as skipped builds instead
+// The returned error explains why the job was not started, if it wasn't
+func Run(job *JobDetails) error {
+	if job.SkipReason != "" {
+		log.Printf("Skipping job %s: %s\n", job.LogFileName, job.SkipReason)
+		skipBuild(job)
+		return ErrJobSkipped
+	}
+
 	job.logFilePath = filepath.Join(LogDIR, fmt.Sprintf("%s%s", job.LogFileName, LogFileExt))
 	err := createDirFor(job.logFilePath)
 	if err != nil {
 		log.Println("Couldn't create directory for job: ", err)
-		return
+		return err
 	}
 
 	// ensure file is not being written to
 	if ActiveCISession(job.logFilePath) {
 		log.Println("A job is currently in progress: ", job.logFilePath)
-		return
+		return ErrJobInProgress
 	}
 
 	// prepare log file i.e clear file content or create new file
-	if err := exec.Command("bash", "-c", "> "+job.logFilePath).Run(); err != nil {
+	logFile, err := os.Create(job.logFilePath)
+	if err != nil {
 		log.Printf("Error: %s occurred while trying to clear logfile %s\n", err, job.logFilePath)
-		return
+		return err
 	}
+	logFile.Close()
 
 	job.ProjectLanguage = strings.ToLower(job.ProjectLanguage)
 	if !supportedLanguage(job.ProjectLanguage) {
 		log.Println("Project Language is currently not supported")
-		return
+		return ErrUnsupportedLanguage
 	}
 
 	log.Printf("Running job: %v\n", job)
+	job.queuedAt = time.Now()
+	job.updateCheckRun(&CheckRun{Status: CheckQueued})
+	startJob(job)
 	go runCI(job)
+	return nil
 }
 
 func createDirFor(fileName string) error {
@@ -117,10 +218,12 @@ func createDirFor(fileName string) error {
 }
 
 func runCI(job *JobDetails) {
+	defer finishJob(job)
 	logFile, err := os.OpenFile(job.logFilePath, os.O_RDWR|os.O_CREATE, 0755)
 	if err != nil {
 		log.Printf("Error %s occurred while opening log file: %s\n", err, job.logFilePath)
 		job.updateBuildStatus("error")
+		job.updateCheckRun(&CheckRun{Status: CheckCompleted, Conclusion: "error", Title: "Sicuro couldn't run your tests"})
 		return
 	}
 	err = os.MkdirAll(filepath.Join(LogDIR, job.LogDirPath, BackupName), 0755)
@@ -131,58 +234,151 @@ func runCI(job *JobDetails) {
 	if err != nil {
 		log.Printf("Error %s occurred while opening bisect file\n", err)
 		job.updateBuildStatus("error")
+		job.updateCheckRun(&CheckRun{Status: CheckCompleted, Conclusion: "error", Title: "Sicuro couldn't run your tests"})
 		return
 	}
 	bisectCont := readByte(bisectFile)
 	defer logFile.Close()
 	defer bisectFile.Close()
 	job.updateBuildStatus("pending")
+	job.updateCheckRun(&CheckRun{Status: CheckInProgress, StartedAt: time.Now()})
+
+	build := newBuild(job)
+	reportsDir := reportsDirFor(job)
+	if err := os.RemoveAll(reportsDir); err != nil {
+		log.Printf("Error %s occurred while clearing reports dir: %s\n", err, reportsDir)
+	}
+	artifactsDir := artifactsDirFor(job)
+	if artifactsDir != "" {
+		if err := os.RemoveAll(artifactsDir); err != nil {
+			log.Printf("Error %s occurred while clearing artifacts dir: %s\n", err, artifactsDir)
+		}
+	}
 
 	containerImg := availableImages[job.ProjectLanguage]
 	isRevert, err := strconv.ParseBool(job.IsRevert)
-	os.Setenv("PROJECT_DIR", filepath.Join(LogDIR, job.LogDirPath))
 	if err != nil {
 		log.Printf("Job will started for build")
 	}
 	if isRevert {
 		containerImg = strings.Join([]string{"backup_", containerImg}, "")
-		cmmt := parse(bisectCont)
-		if cmmt != "" {
-			os.Setenv("GOOD_COMMIT", cmmt)
-		}
+		job.goodCommit = parse(bisectCont)
 	}
-	cmd := exec.Command("bash", "-c", fmt.Sprintf("%s '%s' %s", filepath.Join(ciDIR, "run.sh"), prepareEnvVars(job), containerImg))
-	cmd.Stdout = logFile
-	cmd.Stderr = logFile
-	err = cmd.Run()
+	build.StartedAt = time.Now()
+	recorder := newStepRecorder(logFile)
+	err = runContainer(job, "sicuro-"+build.ID, containerImg, recorder)
 
 	msg := "Build completed successfully"
 	status := "success"
 	log.Println("Exit code: ", err)
+	build.Tests = collectTestResults(reportsDir)
+	build.Coverage = collectCoverage(reportsDir)
+	if err != nil && !isRevert && !job.isCanceled() && retryFailedTests && len(build.FailedTests()) > 0 {
+		failedTests := build.FailedTests()
+		logFile.WriteString("<h3>Retrying failed tests</h3>")
+		recorder.start("Retrying failed tests")
+		os.RemoveAll(reportsDir)
+		job.retryTests, job.retryPackages = retryArgs(failedTests)
+		if err = runContainer(job, "sicuro-"+build.ID, containerImg, recorder); err == nil {
+			msg = "Build completed successfully with flaky retries"
+			status = BuildFlaky
+			build.FlakyTests = failedTests
+		}
+		log.Println("Retry exit code: ", err)
+		build.Tests = mergeRetriedTests(build.Tests, collectTestResults(reportsDir))
+	}
 	if err != nil {
 		msg = fmt.Sprintf("Build failed with exit code: %s. You may revert changes <p><a href='/run?repo=%s&revert=1'>Revert commit</a><p>", err, job.LogFileName)
 		status = "failure"
 	}
+	if reason, canceled := job.canceledReason(); canceled {
+		msg = "Build was canceled. " + html.EscapeString(reason)
+		status = BuildCanceled
+	}
+
+	recorder.finish()
+	build.FinishedAt = time.Now()
+	build.Steps = recorder.steps
+	build.Status = status
+	build.Artifacts = collectArtifacts(artifactsDir)
+	if err := saveBuild(job.LogDirPath, build); err != nil {
+		log.Printf("Error %s occurred while saving build for job: %v\n", err, job)
+	}
 
 	job.updateBuildStatus(status)
+	job.updateCheckRun(completedCheckRun(job, build))
+	job.updateCoverageStatus(build)
+	job.updatePullRequestComment(build)
+	notify(build)
 	logFile.WriteString(fmt.Sprintf("<h4>%s</h4>", msg))
 	logFile.WriteString(fmt.Sprintf("<p><a href='/run?repo=%s'>Rebuild</a><p>", job.LogFileName))
-	findCommit(bisectFile, bisectCont, job.ProjectBranch, status)
+	if status == BuildFlaky {
+		status = "success"
+	}
+	if status != BuildCanceled && !job.BisectOnly {
+		findCommit(bisectFile, bisectCont, job.ProjectBranch, status)
+	}
 	logFile.Close()
 	bisectFile.Close()
 }
 
+// runContainer runs the tests for the job in the given container image
+// The project's deploy key is mounted in the container if it has one, the server's SSH keys otherwise
+// The container output is written to the given writer
+func runContainer(job *JobDetails, containerName, containerImg string, w io.Writer) error {
+	if !job.setContainer(containerName) {
+		return fmt.Errorf("job canceled")
+	}
+	sshDir, err := deployKeyDir(job, containerName)
+	if err != nil {
+		fmt.Fprintf(w, "<h4>The deploy key of the project couldn't be read: %s</h4>", err)
+		return fmt.Errorf("deploy key error: %s", err)
+	}
+	if sshDir == "" {
+		sshDir = filepath.Join(ciDIR, ".ssh")
+	} else {
+		defer os.RemoveAll(sshDir)
+	}
+	env := containerEnv(job)
+	cmd := exec.Command("docker", dockerRunArgs(job, env, containerImg, containerName, sshDir)...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	return cmd.Run()
+}
+
 func (job *JobDetails) updateBuildStatus(status string) {
 	if job.UpdateBuildStatus != nil {
 		job.UpdateBuildStatus(status)
 	}
 }
 
+func (job *JobDetails) updateCoverageStatus(build *Build) {
+	if job.UpdateCoverageStatus == nil || build.Coverage == nil {
+		return
+	}
+
+	description := fmt.Sprintf("%.1f%% coverage", build.Coverage.Percent())
+	if base := BaseBuild(build); base != nil {
+		description = fmt.Sprintf("%s (%+.1f%% compared to %s)", description, build.CoverageDelta(base), base.Branch)
+	}
+	job.UpdateCoverageStatus(description)
+}
+
 func supportedLanguage(lang string) (ok bool) {
 	_, ok = availableImages[lang]
 	return
 }
 
+// SupportedLanguages returns the languages there's a test container image for
+func SupportedLanguages() (languages []string) {
+	for lang := range availableImages {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+	return
+}
+
 // ActiveCISession returns true if a ci session is active
 // it returns false otherwise
 func ActiveCISession(logFile string) bool {
@@ -190,15 +386,82 @@ func ActiveCISession(logFile string) bool {
 	return cmd.Run() == nil
 }
 
-func prepareEnvVars(job *JobDetails) (vars string) {
-	vars = fmt.Sprintf("%s -e %s=%s", vars, "PROJECT_BRANCH", job.ProjectBranch)
-	vars = fmt.Sprintf("%s -e %s=%s", vars, "COMMIT", job.ProjectBranch[:7])
-	vars = fmt.Sprintf("%s -e %s=%s", vars, "PROJECT_REPOSITORY_URL", job.ProjectRepositoryURL)
-	vars = fmt.Sprintf("%s -e %s=%s", vars, "PROJECT_REPOSITORY_NAME", job.ProjectRespositoryName)
-	vars = fmt.Sprintf("%s -e %s=%s", vars, "PROJECT_LANGUAGE", job.ProjectLanguage)
-	vars = fmt.Sprintf("%s -e %s=%s", vars, "GITHUB_TOKEN", os.Getenv("GITHUB_TOKEN"))
-	vars = fmt.Sprintf("%s -e %s=%s", vars, "EMAIL", os.Getenv("EMAIL"))
-	vars = fmt.Sprintf("%s -e %s=%s", vars, "USER_NAME", os.Getenv("USER_NAME"))
-	vars = fmt.Sprintf("%s -e %s=%s", vars, "GOOD_COMMIT", os.Getenv("GOOD_COMMIT"))
-	return
+// containerEnv returns the env variables of the job's test container e.g PROJECT_BRANCH=master
+func containerEnv(job *JobDetails) []string {
+	return []string{
+		"PROJECT_BRANCH=" + job.ProjectBranch,
+		"COMMIT=" + shortCommit(job.ProjectBranch),
+		"PROJECT_REF=" + job.ProjectRef,
+		"PROJECT_TAG=" + job.TagName,
+		"PROJECT_REPOSITORY_URL=" + job.ProjectRepositoryURL,
+		"GIT_HOST=" + gitHost(job.ProjectRepositoryURL),
+		"GIT_PORT=" + gitPort(job.ProjectRepositoryURL),
+		"CLONE_TOKEN=" + job.CloneToken,
+		"GITHUB_HOST=" + GithubHost,
+		"PROJECT_REPOSITORY_NAME=" + job.ProjectRespositoryName,
+		"PROJECT_LANGUAGE=" + job.ProjectLanguage,
+		"GITHUB_TOKEN=" + os.Getenv("GITHUB_TOKEN"),
+		"EMAIL=" + os.Getenv("EMAIL"),
+		"USER_NAME=" + os.Getenv("USER_NAME"),
+		"GOOD_COMMIT=" + job.goodCommit,
+		"BISECT_ONLY=" + strconv.FormatBool(job.BisectOnly),
+		"RETRY_TESTS=" + job.retryTests,
+		"RETRY_PACKAGES=" + job.retryPackages,
+	}
+}
+
+// dockerRunArgs returns the arguments of the docker command running the job's test container
+// The project dir is shared with the container, and the SSH keys the repository is cloned with are mounted in it
+// The env variables are passed by name only and docker reads their values from its own env, so that
+// the values e.g the tag names are never parsed by a shell and the tokens are not in the process list
+func dockerRunArgs(job *JobDetails, env []string, containerImg, containerName, sshDir string) []string {
+	args := []string{
+		"run", "--rm", "--name", containerName,
+		"-v", sshDir + ":/tmp/.ssh",
+		"-v", filepath.Join(LogDIR, job.LogDirPath) + ":/shareddir",
+		"--network", "ci_default",
+	}
+	args = append(args, repoVolumes(job)...)
+	for _, v := range env {
+		args = append(args, "-e", v[:strings.Index(v, "=")])
+	}
+	return append(args, containerImg)
+}
+
+// shortCommit returns the abbreviated commit hash
+// Branch and tag names shorter than an abbreviated hash are returned as they are
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+// gitHost returns the host of the SSH url of a repository
+// e.g git@gitlab.com:owner/project.git or ssh://git@gitlab.example.com/owner/project.git
+// The test containers add it to their known hosts before cloning the repository
+func gitHost(repoURL string) string {
+	if LocalRepoPath(repoURL) != "" {
+		return ""
+	}
+	if u, err := url.Parse(repoURL); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	host := repoURL
+	if i := strings.Index(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	if i := strings.Index(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	return host
+}
+
+// gitPort returns the SSH port of a repository url, if it's not the default one
+// e.g ssh://git@gitea.example.com:2222/owner/project.git
+func gitPort(repoURL string) string {
+	if u, err := url.Parse(repoURL); err == nil && u.Host != "" {
+		return u.Port()
+	}
+	return ""
 }
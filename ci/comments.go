@@ -0,0 +1,92 @@
+package ci
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// maxCommentTests is the most failed tests listed in a pull request comment
+	maxCommentTests = 10
+	// maxCommentOutputLines is how many of the last lines of a failed test's output are quoted
+	maxCommentOutputLines = 15
+	// maxCommentOutputLen is the most characters of a failed test's output quoted
+	maxCommentOutputLen = 1500
+)
+
+// updatePullRequestComment posts the summary of the pull request build
+// The canceled builds are left out, the build of the newer commit updates the comment
+func (job *JobDetails) updatePullRequestComment(build *Build) {
+	if job.UpdatePullRequestComment != nil && build.PullRequest != 0 && build.Status != BuildCanceled {
+		job.UpdatePullRequestComment(build)
+	}
+}
+
+// PullRequestComment returns the markdown summary of the build for its pull request:
+// its final state, duration, failed tests with the end of their output, coverage delta against the base branch
+// and the links to the build page and the artifacts
+// baseURL is the URL the app is served at, the links are left out if it's empty
+func PullRequestComment(build *Build, baseURL string) string {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	var b strings.Builder
+	fmt.Fprintf(&b, "### SicuroCI: %s\n\n", checkRunTitle(build, len(build.FailedTests())))
+	fmt.Fprintf(&b, "| Commit | Status | Duration |\n|---|---|---|\n| %s | %s | %s |\n",
+		shortCommit(build.Commit), build.Status, build.Duration().Round(time.Second))
+	if build.SkipReason != "" {
+		fmt.Fprintf(&b, "\nSkipped: %s\n", build.SkipReason)
+	}
+
+	if build.Coverage != nil {
+		fmt.Fprintf(&b, "\n**Coverage:** %.1f%%", build.Coverage.Percent())
+		if base := BaseBuild(build); base != nil {
+			fmt.Fprintf(&b, " (%+.1f%% compared to %s)", build.CoverageDelta(base), base.Branch)
+		}
+		b.WriteString("\n")
+	}
+
+	failed := build.FailedTests()
+	if len(failed) > 0 {
+		b.WriteString("\n#### Failed tests\n")
+		for i, t := range failed {
+			if i == maxCommentTests {
+				fmt.Fprintf(&b, "\nand %d more, see the build page.\n", len(failed)-maxCommentTests)
+				break
+			}
+			name := t.Name
+			if name == "" {
+				name = "(package)"
+			}
+			fmt.Fprintf(&b, "\n<details><summary><code>%s</code> %s</summary>\n\n", t.Package, name)
+			if output := shortOutput(t.Output); output != "" {
+				fmt.Fprintf(&b, "```\n%s\n```\n", output)
+			}
+			b.WriteString("</details>\n")
+		}
+	}
+
+	if baseURL != "" {
+		fmt.Fprintf(&b, "\n[Build page](%s/ci/%s)\n", baseURL, build.LogFileName)
+		if len(build.Artifacts) > 0 {
+			b.WriteString("\n**Artifacts:**\n")
+			for _, name := range build.Artifacts {
+				fmt.Fprintf(&b, "- [%s](%s/artifacts/%s/%s/%s)\n", name, baseURL, build.Project, build.Tag, name)
+			}
+		}
+	}
+	return b.String()
+}
+
+// shortOutput returns the last lines of a test's output, trimmed to fit in a comment
+func shortOutput(output string) string {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) > maxCommentOutputLines {
+		lines = append([]string{"..."}, lines[len(lines)-maxCommentOutputLines:]...)
+	}
+	short := strings.Join(lines, "\n")
+	if len(short) > maxCommentOutputLen {
+		short = "..." + short[len(short)-maxCommentOutputLen:]
+	}
+	// the output can't close the code block it's quoted in
+	return strings.TrimSpace(strings.Replace(short, "```", "'''", -1))
+}
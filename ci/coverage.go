@@ -0,0 +1,260 @@
+package ci
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// goCoverageReportName is the file the go image writes the `-coverprofile` output to
+	goCoverageReportName = "coverage.out"
+	// coverageReportsDir is the folder other images copy the lcov and cobertura reports declared in the pipeline config to
+	coverageReportsDir = "coverage"
+)
+
+// Coverage is the code coverage of a build
+type Coverage struct {
+	// Covered is the number of statements (or lines) covered by the tests
+	Covered int
+	// Total is the number of statements (or lines) in the project
+	Total int
+	// Packages is the coverage of each package
+	Packages []CoverageEntry
+	// Files is the coverage of each file
+	Files []CoverageEntry
+}
+
+// CoverageEntry is the coverage of a single package or file
+type CoverageEntry struct {
+	Name    string
+	Covered int
+	Total   int
+}
+
+// Percent returns the percentage of the statements covered
+func (c *Coverage) Percent() float64 {
+	return percent(c.Covered, c.Total)
+}
+
+// Percent returns the percentage of the statements covered
+func (e CoverageEntry) Percent() float64 {
+	return percent(e.Covered, e.Total)
+}
+
+func percent(covered, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(covered) * 100 / float64(total)
+}
+
+// coverageCounter accumulates the covered and total statements of each file
+// and the package each file belongs to
+type coverageCounter struct {
+	files    map[string]*CoverageEntry
+	packages map[string]string
+}
+
+func newCoverageCounter() *coverageCounter {
+	return &coverageCounter{
+		files:    map[string]*CoverageEntry{},
+		packages: map[string]string{},
+	}
+}
+
+func (c *coverageCounter) add(pkg, file string, covered, total int) {
+	entry, ok := c.files[file]
+	if !ok {
+		entry = &CoverageEntry{Name: file}
+		c.files[file] = entry
+		c.packages[file] = pkg
+	}
+	entry.Covered += covered
+	entry.Total += total
+}
+
+func (c *coverageCounter) coverage() *Coverage {
+	if len(c.files) == 0 {
+		return nil
+	}
+
+	cov := &Coverage{}
+	packages := map[string]*CoverageEntry{}
+	for file, entry := range c.files {
+		cov.Covered += entry.Covered
+		cov.Total += entry.Total
+		cov.Files = append(cov.Files, *entry)
+
+		pkg := c.packages[file]
+		if _, ok := packages[pkg]; !ok {
+			packages[pkg] = &CoverageEntry{Name: pkg}
+		}
+		packages[pkg].Covered += entry.Covered
+		packages[pkg].Total += entry.Total
+	}
+	for _, entry := range packages {
+		cov.Packages = append(cov.Packages, *entry)
+	}
+
+	sort.Slice(cov.Files, func(i, j int) bool { return cov.Files[i].Name < cov.Files[j].Name })
+	sort.Slice(cov.Packages, func(i, j int) bool { return cov.Packages[i].Name < cov.Packages[j].Name })
+	return cov
+}
+
+// collectCoverage parses all the coverage reports found in the given directory
+// It returns nil if the build didn't produce any coverage report
+func collectCoverage(dir string) *Coverage {
+	counter := newCoverageCounter()
+
+	if f, err := os.Open(filepath.Join(dir, goCoverageReportName)); err == nil {
+		if err := parseGoCoverProfile(f, counter); err != nil {
+			log.Printf("Error %s occurred while parsing go coverage report in %s\n", err, dir)
+		}
+		f.Close()
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, coverageReportsDir, "*"))
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			log.Printf("Error %s occurred while opening coverage report %s\n", err, file)
+			continue
+		}
+
+		if strings.HasSuffix(file, ".xml") {
+			err = parseCobertura(f, counter)
+		} else {
+			err = parseLcov(f, counter)
+		}
+		if err != nil {
+			log.Printf("Error %s occurred while parsing coverage report %s\n", err, file)
+		}
+		f.Close()
+	}
+
+	return counter.coverage()
+}
+
+// parseGoCoverProfile parses the output of `go test -coverprofile`
+// Each line after the mode line is a block in the format
+// name.go:line.column,line.column numberOfStatements count
+func parseGoCoverProfile(r io.Reader, counter *coverageCounter) error {
+	type block struct {
+		stmts int
+		count int
+	}
+	blocks := map[string]*block{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+
+		// the block is the file name followed by its position
+		fields := strings.Fields(line)
+		if len(fields) != 3 || strings.LastIndex(fields[0], ":") <= 0 {
+			return fmt.Errorf("invalid coverage line: %s", line)
+		}
+		stmts, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return err
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return err
+		}
+
+		// the same block is listed once for every test binary that covers it
+		if b, ok := blocks[fields[0]]; ok {
+			if count > b.count {
+				b.count = count
+			}
+			continue
+		}
+		blocks[fields[0]] = &block{stmts, count}
+	}
+
+	for key, b := range blocks {
+		file := key[:strings.LastIndex(key, ":")]
+		covered := 0
+		if b.count > 0 {
+			covered = b.stmts
+		}
+		counter.add(path.Dir(file), file, covered, b.stmts)
+	}
+	return scanner.Err()
+}
+
+// parseLcov parses an lcov tracefile using its line data (DA) records
+func parseLcov(r io.Reader, counter *coverageCounter) error {
+	file := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			file = strings.TrimPrefix(line, "SF:")
+		case strings.HasPrefix(line, "DA:") && file != "":
+			fields := strings.Split(strings.TrimPrefix(line, "DA:"), ",")
+			if len(fields) < 2 {
+				return fmt.Errorf("invalid lcov line: %s", line)
+			}
+			hits, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return err
+			}
+			covered := 0
+			if hits > 0 {
+				covered = 1
+			}
+			counter.add(path.Dir(file), file, covered, 1)
+		case line == "end_of_record":
+			file = ""
+		}
+	}
+	return scanner.Err()
+}
+
+type coberturaReport struct {
+	Packages []struct {
+		Name    string `xml:"name,attr"`
+		Classes []struct {
+			Filename string `xml:"filename,attr"`
+			Lines    []struct {
+				Hits int `xml:"hits,attr"`
+			} `xml:"lines>line"`
+		} `xml:"classes>class"`
+	} `xml:"packages>package"`
+}
+
+// parseCobertura parses a cobertura XML report using its line hits
+func parseCobertura(r io.Reader, counter *coverageCounter) error {
+	report := coberturaReport{}
+	if err := xml.NewDecoder(r).Decode(&report); err != nil {
+		return err
+	}
+
+	for _, pkg := range report.Packages {
+		for _, class := range pkg.Classes {
+			for _, line := range class.Lines {
+				covered := 0
+				if line.Hits > 0 {
+					covered = 1
+				}
+				counter.add(pkg.Name, class.Filename, covered, 1)
+			}
+		}
+	}
+	return nil
+}
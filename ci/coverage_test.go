@@ -0,0 +1,148 @@
+package ci
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseGoCoverProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		want    *Coverage
+		wantErr bool
+	}{
+		{
+			name: "blocks of two packages",
+			profile: `mode: set
+example.com/app/main.go:10.2,12.3 2 1
+example.com/app/main.go:14.2,16.3 3 0
+example.com/app/lib/lib.go:5.2,7.3 4 1
+`,
+			want: &Coverage{
+				Covered:  6,
+				Total:    9,
+				Packages: []CoverageEntry{{"example.com/app", 2, 5}, {"example.com/app/lib", 4, 4}},
+				Files:    []CoverageEntry{{"example.com/app/lib/lib.go", 4, 4}, {"example.com/app/main.go", 2, 5}},
+			},
+		},
+		{
+			name: "block listed by several test binaries",
+			profile: `mode: count
+example.com/app/main.go:10.2,12.3 2 0
+example.com/app/main.go:10.2,12.3 2 3
+`,
+			want: &Coverage{
+				Covered:  2,
+				Total:    2,
+				Packages: []CoverageEntry{{"example.com/app", 2, 2}},
+				Files:    []CoverageEntry{{"example.com/app/main.go", 2, 2}},
+			},
+		},
+		{name: "empty profile", profile: "mode: set\n"},
+		{name: "block without a position", profile: "mode: set\nmain.go 2 1\n", wantErr: true},
+		{name: "block starting with its position", profile: "mode: set\n:10.2,12.3 2 1\n", wantErr: true},
+		{name: "missing count", profile: "mode: set\nmain.go:10.2,12.3 2\n", wantErr: true},
+		{name: "invalid count", profile: "mode: set\nmain.go:10.2,12.3 2 x\n", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counter := newCoverageCounter()
+			err := parseGoCoverProfile(strings.NewReader(test.profile), counter)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseGoCoverProfile() returned error %v, want an error: %t", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if got := counter.coverage(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseGoCoverProfile() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseLcov(t *testing.T) {
+	tests := []struct {
+		name    string
+		report  string
+		want    *Coverage
+		wantErr bool
+	}{
+		{
+			name: "two files",
+			report: `TN:
+SF:src/app.js
+DA:1,1
+DA:2,0
+DA:3,5
+end_of_record
+SF:src/util/math.js
+DA:1,0
+end_of_record
+`,
+			want: &Coverage{
+				Covered:  2,
+				Total:    4,
+				Packages: []CoverageEntry{{"src", 2, 3}, {"src/util", 0, 1}},
+				Files:    []CoverageEntry{{"src/app.js", 2, 3}, {"src/util/math.js", 0, 1}},
+			},
+		},
+		{name: "line data outside of a file", report: "DA:1,1\n"},
+		{name: "missing hits", report: "SF:app.js\nDA:1\n", wantErr: true},
+		{name: "invalid hits", report: "SF:app.js\nDA:1,x\n", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counter := newCoverageCounter()
+			err := parseLcov(strings.NewReader(test.report), counter)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseLcov() returned error %v, want an error: %t", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+			if got := counter.coverage(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseLcov() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseCobertura(t *testing.T) {
+	report := `<?xml version="1.0" ?>
+<coverage>
+	<packages>
+		<package name="app">
+			<classes>
+				<class filename="app/main.py">
+					<lines>
+						<line number="1" hits="1"/>
+						<line number="2" hits="0"/>
+					</lines>
+				</class>
+			</classes>
+		</package>
+	</packages>
+</coverage>`
+	counter := newCoverageCounter()
+	if err := parseCobertura(strings.NewReader(report), counter); err != nil {
+		t.Fatalf("parseCobertura() returned %s", err)
+	}
+	want := &Coverage{
+		Covered:  1,
+		Total:    2,
+		Packages: []CoverageEntry{{"app", 1, 2}},
+		Files:    []CoverageEntry{{"app/main.py", 1, 2}},
+	}
+	if got := counter.coverage(); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseCobertura() = %+v, want %+v", got, want)
+	}
+
+	if err := parseCobertura(strings.NewReader("<coverage>"), newCoverageCounter()); err == nil {
+		t.Errorf("parseCobertura() of a truncated report didn't fail")
+	}
+}
@@ -0,0 +1,184 @@
+package ci
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// deployKeysName is the directory the deploy keys of the running jobs are written to, one directory per container
+	deployKeysName = ".deploy-keys"
+	// deployKeyFileName is the name of the private key in the SSH directory mounted in the test container
+	deployKeyFileName = "id_ed25519"
+)
+
+// ErrDeployKeysDisabled is returned when generating a deploy key without the DEPLOY_KEY_SECRET set
+var ErrDeployKeysDisabled = errors.New("deploy keys are disabled, DEPLOY_KEY_SECRET is not set")
+
+// DeployKey is the SSH key the test containers of a project clone its repository with
+// Its public key is registered on the repository as a read-only deploy key
+type DeployKey struct {
+	// ID is the ID of the deploy key on the provider
+	ID int64
+	// PublicKey is the public key in the authorized_keys format
+	PublicKey string
+	// Fingerprint is the SHA256 fingerprint of the public key, as shown by the provider
+	Fingerprint string
+	// PrivateKey is the private key in the OpenSSH format, encrypted with the DEPLOY_KEY_SECRET
+	PrivateKey string
+	CreatedAt  time.Time
+}
+
+// DeployKeysEnabled returns true if the projects get their own deploy key i.e the DEPLOY_KEY_SECRET is set
+func DeployKeysEnabled() bool {
+	return deployKeySecret != ""
+}
+
+// NewDeployKey generates an ed25519 keypair with the given comment and returns it with its private key encrypted
+// The returned key has no ID until its public key is registered on the repository
+func NewDeployKey(comment string) (*DeployKey, error) {
+	if !DeployKeysEnabled() {
+		return nil, ErrDeployKeysDisabled
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	blob := sshPublicKeyBlob(pub)
+	encrypted, err := encryptDeployKey(marshalOpenSSHPrivateKey(pub, priv, comment))
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(blob)
+	return &DeployKey{
+		PublicKey:   "ssh-ed25519 " + base64.StdEncoding.EncodeToString(blob) + " " + comment,
+		Fingerprint: "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]),
+		PrivateKey:  encrypted,
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+// sshPublicKeyBlob returns the public key in the SSH wire format
+func sshPublicKeyBlob(pub ed25519.PublicKey) []byte {
+	var b bytes.Buffer
+	writeSSHString(&b, []byte("ssh-ed25519"))
+	writeSSHString(&b, pub)
+	return b.Bytes()
+}
+
+// marshalOpenSSHPrivateKey returns the unencrypted private key in the PEM encoded OpenSSH format ssh reads
+func marshalOpenSSHPrivateKey(pub ed25519.PublicKey, priv ed25519.PrivateKey, comment string) []byte {
+	var private bytes.Buffer
+	check := make([]byte, 4)
+	rand.Read(check)
+	private.Write(check)
+	private.Write(check)
+	writeSSHString(&private, []byte("ssh-ed25519"))
+	writeSSHString(&private, pub)
+	writeSSHString(&private, priv)
+	writeSSHString(&private, []byte(comment))
+	// the private section is padded to the block size of the cipher, 8 for none
+	for i := byte(1); private.Len()%8 != 0; i++ {
+		private.WriteByte(i)
+	}
+
+	var key bytes.Buffer
+	key.WriteString("openssh-key-v1\x00")
+	writeSSHString(&key, []byte("none"))
+	writeSSHString(&key, []byte("none"))
+	writeSSHString(&key, nil)
+	binary.Write(&key, binary.BigEndian, uint32(1))
+	writeSSHString(&key, sshPublicKeyBlob(pub))
+	writeSSHString(&key, private.Bytes())
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: key.Bytes()})
+}
+
+func writeSSHString(b *bytes.Buffer, s []byte) {
+	binary.Write(b, binary.BigEndian, uint32(len(s)))
+	b.Write(s)
+}
+
+// deployKeyCipher returns the AES-GCM cipher of the deploy keys, keyed with the hash of the DEPLOY_KEY_SECRET
+func deployKeyCipher() (cipher.AEAD, error) {
+	if !DeployKeysEnabled() {
+		return nil, ErrDeployKeysDisabled
+	}
+	key := sha256.Sum256([]byte(deployKeySecret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptDeployKey returns the base64 encoded nonce followed by the encrypted key
+func encryptDeployKey(key []byte) (string, error) {
+	gcm, err := deployKeyCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, key, nil)), nil
+}
+
+// decryptDeployKey returns the private key encrypted by encryptDeployKey
+func decryptDeployKey(encrypted string) ([]byte, error) {
+	gcm, err := deployKeyCipher()
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("the encrypted deploy key is too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+// deployKeyDir writes the project's deploy key to a directory of its own for the given container, and returns it
+// The directory is mounted in the container instead of the server's SSH keys, and must be removed once it exits
+// It returns an empty string if the project has no deploy key
+func deployKeyDir(job *JobDetails, containerName string) (string, error) {
+	settings, err := LoadProjectSettings(job.LogDirPath)
+	if err != nil || settings.DeployKey == nil {
+		return "", err
+	}
+	key, err := decryptDeployKey(settings.DeployKey.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(ciDIR, deployKeysName, containerName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, deployKeyFileName), key, 0600); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, deployKeyFileName+".pub"), []byte(settings.DeployKey.PublicKey+"\n"), 0644); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
@@ -0,0 +1,208 @@
+package ci
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// GitSource is the plain git repository of a project that's not hosted on a provider
+// e.g a repository on an internal SSH server or a bare repository on the server's disk
+type GitSource struct {
+	// URL is the SSH url or the local path the repository is cloned from
+	URL           string
+	Language      string
+	DefaultBranch string
+	// Secret signs the trigger requests sent by the repository's post-receive hook
+	Secret string
+}
+
+// GitProjects returns the projects built from plain git repositories i.e owner/project
+func GitProjects() []string {
+	return projectsWith(func(settings *ProjectSettings) bool { return settings.Git != nil })
+}
+
+// projectsWith returns the projects whose settings match the given func i.e owner/project
+func projectsWith(match func(*ProjectSettings) bool) (projects []string) {
+	files, _ := filepath.Glob(filepath.Join(LogDIR, "*", "*", settingsFileName))
+	for _, file := range files {
+		projectDir, _ := filepath.Rel(LogDIR, filepath.Dir(file))
+		if settings, err := LoadProjectSettings(projectDir); err == nil && match(settings) {
+			projects = append(projects, projectDir)
+		}
+	}
+	return
+}
+
+// ValidateRepoURL returns an error if the repository url can't be built from
+// The url can't start with a dash, which git would read as an option, and remote urls must be SSH, HTTPS or git urls
+// Local repositories must be bare repositories inside the LOCAL_REPOS_DIR, as they are mounted into the test containers
+func ValidateRepoURL(repoURL string) error {
+	if repoURL == "" {
+		return errors.New("the repository url is required")
+	}
+	if strings.HasPrefix(repoURL, "-") || strings.ContainsAny(repoURL, " \t\r\n'\"`$\\") {
+		return errors.New("the repository url has invalid characters")
+	}
+
+	if dir := LocalRepoPath(repoURL); dir != "" {
+		return validateLocalRepo(dir)
+	}
+	if strings.Contains(repoURL, "::") {
+		return errors.New("the repository url uses an unsupported transport")
+	}
+	// ssh would read a host starting with a dash as an option
+	if strings.HasPrefix(gitHost(repoURL), "-") {
+		return errors.New("the repository url has an invalid host")
+	}
+	if u, err := url.Parse(repoURL); err == nil && u.Scheme != "" {
+		switch u.Scheme {
+		case "ssh", "https", "http", "git":
+		default:
+			return fmt.Errorf("the repository url scheme %s is not supported", u.Scheme)
+		}
+		if u.Host == "" {
+			return errors.New("the repository url has no host")
+		}
+		return nil
+	}
+	// scp-like SSH urls e.g git@git.example.com:owner/project.git
+	if i := strings.Index(repoURL, ":"); i <= 0 || strings.Contains(repoURL[:i], "/") {
+		return errors.New("the repository url must be an SSH, HTTPS or git url, or the path of a local bare repository")
+	}
+	return nil
+}
+
+// validateLocalRepo returns an error if the directory is not a bare repository inside the LOCAL_REPOS_DIR
+// The symlinks are resolved so that a link inside the directory can't point outside of it
+func validateLocalRepo(dir string) error {
+	if localReposDIR == "" {
+		return errors.New("local repositories are disabled, LOCAL_REPOS_DIR is not set")
+	}
+	root, err := filepath.EvalSymlinks(localReposDIR)
+	if err != nil {
+		return fmt.Errorf("the LOCAL_REPOS_DIR can't be read: %s", err)
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return errors.New("the local repository doesn't exist")
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("local repositories must be inside %s", localReposDIR)
+	}
+
+	out, err := exec.Command("git", "--git-dir", resolved, "rev-parse", "--is-bare-repository").Output()
+	if err != nil || strings.TrimSpace(string(out)) != "true" {
+		return errors.New("the local repository must be a bare repository")
+	}
+	return nil
+}
+
+// LocalRepoPath returns the path of a local repository url e.g /srv/git/project.git
+// or file:///srv/git/project.git. It returns an empty string for remote urls
+func LocalRepoPath(repoURL string) string {
+	if strings.HasPrefix(repoURL, "/") {
+		return filepath.Clean(repoURL)
+	}
+	if u, err := url.Parse(repoURL); err == nil && u.Scheme == "file" {
+		return filepath.Clean(u.Path)
+	}
+	return ""
+}
+
+// remoteTimeout is how long git is given to list the refs of a remote repository
+const remoteTimeout = time.Minute
+
+// gitCommand returns a git command that reaches remote repositories with the server's SSH key
+// The command is killed once the context is done
+func gitCommand(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	// ssh may keep the output open once git is killed
+	cmd.WaitDelay = time.Second
+	sshCommand := fmt.Sprintf("ssh -i %s -o StrictHostKeyChecking=accept-new -o BatchMode=yes -o ConnectTimeout=30", filepath.Join(ciDIR, ".ssh", "id_rsa"))
+	// the repository urls come from the users, git mustn't run the commands of the ext transport
+	cmd.Env = append(os.Environ(), "GIT_SSH_COMMAND="+sshCommand, "GIT_PROTOCOL_FROM_USER=0")
+	return cmd
+}
+
+// RemoteHeads returns the commits the given refs of the repository point to, keyed by ref
+// Refs that don't exist are left out. Annotated tags are keyed by their ref followed by ^{}
+func RemoteHeads(repoURL string, refs ...string) (map[string]string, error) {
+	if err := ValidateRepoURL(repoURL); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := gitCommand(ctx, append([]string{"ls-remote", "--", repoURL}, refs...)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-remote %s: %s %s", repoURL, err, strings.TrimSpace(stderr.String()))
+	}
+
+	heads := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			heads[fields[1]] = fields[0]
+		}
+	}
+	return heads, nil
+}
+
+// RemoteBranches returns the commits the branches of the repository point to, keyed by branch
+func RemoteBranches(repoURL string) (map[string]string, error) {
+	refs, err := RemoteHeads(repoURL, "refs/heads/*")
+	if err != nil {
+		return nil, err
+	}
+
+	heads := map[string]string{}
+	for ref, commit := range refs {
+		heads[strings.TrimPrefix(ref, "refs/heads/")] = commit
+	}
+	return heads, nil
+}
+
+// RepoFileContent returns the content of the file at the given path and ref of a local repository
+// It returns an os.ErrNotExist error for remote repositories, whose files can't be read without a clone
+func RepoFileContent(repoURL, ref, path string) ([]byte, error) {
+	dir := LocalRepoPath(repoURL)
+	// the ref can't be read as an option of git show
+	if dir == "" || strings.HasPrefix(ref, "-") || validateLocalRepo(dir) != nil {
+		return nil, os.ErrNotExist
+	}
+
+	out, err := gitCommand(context.Background(), "--git-dir", dir, "show", ref+":"+path).Output()
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	return out, nil
+}
+
+// repoVolumes returns the docker volume flags mounting the job's repository into the test container
+// when it's a local repository, so that it's cloned from the same path
+// Nothing is mounted for the local paths that are not bare repositories inside the LOCAL_REPOS_DIR
+func repoVolumes(job *JobDetails) []string {
+	dir := LocalRepoPath(job.ProjectRepositoryURL)
+	if dir == "" {
+		return nil
+	}
+	if err := validateLocalRepo(dir); err != nil {
+		log.Printf("Not mounting local repository %s: %s\n", dir, err)
+		return nil
+	}
+	return []string{"-v", fmt.Sprintf("%s:%s:ro", dir, dir)}
+}
@@ -0,0 +1,75 @@
+package ci
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateRepoURL(t *testing.T) {
+	root, err := ioutil.TempDir("", "repos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+	bare := filepath.Join(root, "project.git")
+	worktree := filepath.Join(root, "worktree")
+	for _, args := range [][]string{{"init", "--bare", bare}, {"init", worktree}} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %s", args, out)
+		}
+	}
+	outside, err := ioutil.TempDir("", "outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(outside) })
+	if out, err := exec.Command("git", "init", "--bare", outside).CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %s", out)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link.git")); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(dir string) { localReposDIR = dir }(localReposDIR)
+	localReposDIR = root
+
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"git@github.com:owner/repo.git", false},
+		{"ssh://git@git.example.com:2222/owner/repo.git", false},
+		{"https://gitlab.com/group/repo.git", false},
+		{"git://git.example.com/repo.git", false},
+		{bare, false},
+		{"file://" + bare, false},
+		{"", true},
+		{"--upload-pack=touch /tmp/x", true},
+		{"-oProxyCommand=x", true},
+		{"ssh://-oProxyCommand=x/repo", true},
+		{"git@github.com:owner/repo.git; rm -rf /", true},
+		{"https://github.com/$(id)", true},
+		{"ext::sh -c touch% /tmp/x", true},
+		{"ftp://example.com/repo.git", true},
+		{"https:///repo.git", true},
+		{"owner/repo", true},
+		{worktree, true},
+		{filepath.Join(root, "missing.git"), true},
+		{filepath.Join(root, "link.git"), true},
+		{outside, true},
+		{root, true},
+	}
+	for _, test := range tests {
+		if err := ValidateRepoURL(test.url); (err != nil) != test.wantErr {
+			t.Errorf("ValidateRepoURL(%q) returned %v, want an error: %t", test.url, err, test.wantErr)
+		}
+	}
+
+	localReposDIR = ""
+	if err := ValidateRepoURL(bare); err == nil {
+		t.Errorf("ValidateRepoURL() accepted a local repository without a LOCAL_REPOS_DIR")
+	}
+}
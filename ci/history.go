@@ -0,0 +1,105 @@
+package ci
+
+import (
+	"sort"
+)
+
+const (
+	// testHistoryWindow is the number of recent builds considered for the test history
+	testHistoryWindow = 20
+	// flakyFlips is the number of times a test has to flip between pass and fail
+	// across the history window to be considered flaky
+	flakyFlips = 3
+)
+
+// TestHistory is the outcome of a test across the recent builds of a project
+type TestHistory struct {
+	Package string
+	Name    string
+	// Runs holds the status of the test in each build, in the same order as the builds
+	// It's empty for the builds the test didn't run in
+	Runs []string
+	// Flaky is true if the test flipped status on the same commit,
+	// passed on retry or kept flipping across commits
+	Flaky bool
+}
+
+// ProjectTestHistory returns the recent builds of the given project, oldest first,
+// along with the history of every test that ran in them. Flaky tests are listed first.
+func ProjectTestHistory(projectDir string) ([]*Build, []TestHistory) {
+	builds := []*Build{}
+	for _, b := range ProjectBuilds(projectDir) {
+		if b.Status != BuildSkipped && !b.Revert {
+			builds = append(builds, b)
+		}
+	}
+	if len(builds) > testHistoryWindow {
+		builds = builds[len(builds)-testHistoryWindow:]
+	}
+
+	index := map[string]int{}
+	history := []TestHistory{}
+	for i, b := range builds {
+		for _, t := range b.Tests {
+			key := t.Package + "/" + t.Name
+			if _, ok := index[key]; !ok {
+				index[key] = len(history)
+				history = append(history, TestHistory{
+					Package: t.Package,
+					Name:    t.Name,
+					Runs:    make([]string, len(builds)),
+				})
+			}
+			history[index[key]].Runs[i] = t.Status
+		}
+	}
+
+	for i := range history {
+		history[i].Flaky = isFlaky(builds, &history[i])
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		if history[i].Flaky != history[j].Flaky {
+			return history[i].Flaky
+		}
+		if history[i].Package != history[j].Package {
+			return history[i].Package < history[j].Package
+		}
+		return history[i].Name < history[j].Name
+	})
+	return builds, history
+}
+
+func isFlaky(builds []*Build, test *TestHistory) bool {
+	statusesByCommit := map[string]map[string]bool{}
+	flips := 0
+	last := ""
+
+	for i, status := range test.Runs {
+		if status != TestPassed && status != TestFailed {
+			continue
+		}
+
+		for _, t := range builds[i].FlakyTests {
+			if t.Package == test.Package && t.Name == test.Name {
+				return true
+			}
+		}
+
+		commit := builds[i].Commit
+		if statusesByCommit[commit] == nil {
+			statusesByCommit[commit] = map[string]bool{}
+		}
+		statusesByCommit[commit][status] = true
+		if len(statusesByCommit[commit]) > 1 {
+			return true
+		}
+
+		if last != "" && last != status {
+			flips++
+		}
+		last = status
+	}
+
+	return flips >= flakyFlips
+}
@@ -0,0 +1,94 @@
+package ci
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testBuilds returns a build for each of the given commits
+func testBuilds(commits ...string) []*Build {
+	builds := make([]*Build, len(commits))
+	for i, commit := range commits {
+		builds[i] = &Build{ID: string(rune('a' + i)), Commit: commit}
+	}
+	return builds
+}
+
+// testRuns returns the runs of a test from a string of p (pass), f (fail), s (skip) and - (didn't run)
+func testRuns(runs string) []string {
+	statuses := map[rune]string{'p': TestPassed, 'f': TestFailed, 's': TestSkipped, '-': ""}
+	result := []string{}
+	for _, r := range runs {
+		result = append(result, statuses[r])
+	}
+	return result
+}
+
+func TestIsFlaky(t *testing.T) {
+	result := TestResult{Package: "pkg", Name: "TestA"}
+	distinctCommits := func(n int) []string {
+		commits := []string{}
+		for i := 0; i < n; i++ {
+			commits = append(commits, strings.Repeat(string(rune('a'+i)), 7))
+		}
+		return commits
+	}
+
+	tests := []struct {
+		name    string
+		commits []string
+		runs    string
+		retried int
+		want    bool
+	}{
+		{name: "always passes", commits: distinctCommits(5), runs: "ppppp", retried: -1},
+		{name: "broken then fixed", commits: distinctCommits(5), runs: "ppffp", retried: -1},
+		{name: "two flips", commits: distinctCommits(6), runs: "pppffp", retried: -1},
+		{name: "three flips", commits: distinctCommits(5), runs: "ppfpf", retried: -1, want: true},
+		{name: "flips across skips", commits: distinctCommits(7), runs: "pfspsfp", retried: -1, want: true},
+		{name: "skips and missing runs don't flip", commits: distinctCommits(6), runs: "psp-sp", retried: -1},
+		{name: "status change on the same commit", commits: []string{"aaa", "bbb", "bbb"}, runs: "pfp", retried: -1, want: true},
+		{name: "same status on the same commit", commits: []string{"aaa", "aaa", "bbb"}, runs: "ffp", retried: -1},
+		{name: "passed retry", commits: distinctCommits(3), runs: "ppp", retried: 1, want: true},
+		{name: "retried in a build it didn't run in", commits: distinctCommits(3), runs: "p-p", retried: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			builds := testBuilds(test.commits...)
+			if test.retried >= 0 {
+				builds[test.retried].FlakyTests = []TestResult{result}
+			}
+			history := &TestHistory{Package: result.Package, Name: result.Name, Runs: testRuns(test.runs)}
+			if got := isFlaky(builds, history); got != test.want {
+				t.Errorf("isFlaky(%s) = %t, want %t", test.runs, got, test.want)
+			}
+		})
+	}
+}
+
+func TestProjectTestHistoryWindow(t *testing.T) {
+	useTestLogDIR(t)
+	// the flips of the oldest builds fall out of the window
+	for i := 0; i < testHistoryWindow+4; i++ {
+		status := TestPassed
+		if i < 4 && i%2 == 1 {
+			status = TestFailed
+		}
+		saveTestBuilds(t, &Build{
+			ID:     fmt.Sprintf("%03d", i),
+			Commit: fmt.Sprintf("commit%d", i),
+			Status: "success",
+			Tests:  []TestResult{{Package: "pkg", Name: "TestA", Status: status}},
+		})
+	}
+
+	builds, history := ProjectTestHistory("owner/repo")
+	if len(builds) != testHistoryWindow {
+		t.Fatalf("ProjectTestHistory() returned %d builds, want %d", len(builds), testHistoryWindow)
+	}
+	if len(history) != 1 || history[0].Flaky {
+		t.Errorf("ProjectTestHistory() = %+v, want a single test that isn't flaky", history)
+	}
+}
@@ -0,0 +1,101 @@
+package ci
+
+import (
+	"log"
+	"strings"
+)
+
+const (
+	// NotifyFailed is the event sent when a build fails
+	NotifyFailed = "failed"
+	// NotifyRecovered is the event sent when a build passes after the previous build failed
+	NotifyRecovered = "recovered"
+)
+
+// Person is a commit author or the pusher of the commits
+type Person struct {
+	Name  string
+	Email string
+}
+
+// Notification is sent to the people involved in a build when it fails or recovers
+type Notification struct {
+	// Event is either NotifyFailed or NotifyRecovered
+	Event string
+	// Build is the build the notification is about
+	Build *Build
+	// Recipients are the email addresses of the commit authors and the pusher
+	Recipients []string
+}
+
+// Notifier sends the notifications about builds e.g by email
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+var notifiers []Notifier
+
+// RegisterNotifier adds the given notifier to the ones executed when a build fails or recovers
+func RegisterNotifier(n Notifier) {
+	notifiers = append(notifiers, n)
+}
+
+// notify sends a notification for the given build through all the registered notifiers
+// if the build failed or recovered from a failure on the same branch
+// The builds of the revert and bisect jobs are only reported in their logs
+func notify(build *Build) {
+	if len(notifiers) == 0 || build.Revert {
+		return
+	}
+
+	event := ""
+	switch build.Status {
+	case "failure", "error":
+		event = NotifyFailed
+	case "success", BuildFlaky:
+		if prev := previousBuild(build); prev != nil && (prev.Status == "failure" || prev.Status == "error") {
+			event = NotifyRecovered
+		}
+	}
+	if event == "" {
+		return
+	}
+
+	n := Notification{Event: event, Build: build, Recipients: build.recipients()}
+	if len(n.Recipients) == 0 {
+		log.Printf("No one to notify about %s build: %s\n", event, build.LogFileName)
+		return
+	}
+
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(n); err != nil {
+			log.Printf("Error %s occurred while sending %s notification for build: %s\n", err, event, build.LogFileName)
+		}
+	}
+}
+
+// recipients returns the unique email addresses of the build's commit authors and pusher
+func (b *Build) recipients() (emails []string) {
+	seen := map[string]bool{}
+	for _, p := range append(b.Authors, b.Pusher) {
+		email := strings.ToLower(strings.TrimSpace(p.Email))
+		if email == "" || seen[email] {
+			continue
+		}
+		seen[email] = true
+		emails = append(emails, p.Email)
+	}
+	return
+}
+
+// previousBuild returns the build that completed before the given one on the same branch
+func previousBuild(b *Build) *Build {
+	builds := ProjectBuilds(b.Project)
+	for i := len(builds) - 1; i >= 0; i-- {
+		prev := builds[i]
+		if prev.ID < b.ID && prev.Branch == b.Branch && !prev.Revert && prev.Status != BuildSkipped && prev.Status != BuildCanceled {
+			return prev
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package ci
+
+import (
+	"reflect"
+	"testing"
+)
+
+// fakeNotifier records the notifications it's sent
+type fakeNotifier struct {
+	sent []Notification
+}
+
+func (f *fakeNotifier) Notify(n Notification) error {
+	f.sent = append(f.sent, n)
+	return nil
+}
+
+// useFakeNotifier registers a fakeNotifier as the only notifier for the duration of the test
+func useFakeNotifier(t *testing.T) *fakeNotifier {
+	registered := notifiers
+	fake := &fakeNotifier{}
+	notifiers = []Notifier{fake}
+	t.Cleanup(func() { notifiers = registered })
+	return fake
+}
+
+func TestNotifySkipsRevertBuilds(t *testing.T) {
+	useTestLogDIR(t)
+	fake := useFakeNotifier(t)
+	pusher := Person{Name: "pusher", Email: "pusher@example.com"}
+	saveTestBuilds(t, &Build{ID: "1", Branch: "master", Status: "failure", Pusher: pusher})
+
+	bisect := &Build{ID: "2", Project: "owner/repo", Branch: "master", Status: "success", Pusher: pusher, Revert: true}
+	saveTestBuilds(t, bisect)
+	notify(bisect)
+	if len(fake.sent) != 0 {
+		t.Fatalf("notify() of a bisect build sent %+v, want no notifications", fake.sent)
+	}
+
+	build := &Build{ID: "3", Project: "owner/repo", Branch: "master", Status: "success", Pusher: pusher}
+	notify(build)
+	if len(fake.sent) != 1 || fake.sent[0].Event != NotifyRecovered {
+		t.Errorf("notify() after a bisect build sent %+v, want a %s notification", fake.sent, NotifyRecovered)
+	}
+}
+
+func TestNotifyTransitions(t *testing.T) {
+	pusher := Person{Name: "pusher", Email: "pusher@example.com"}
+	tests := []struct {
+		name     string
+		previous []*Build
+		status   string
+		want     string
+	}{
+		{name: "first build failed", status: "failure", want: NotifyFailed},
+		{name: "errored", previous: []*Build{{ID: "1", Branch: "master", Status: "success"}}, status: "error", want: NotifyFailed},
+		{name: "failed again", previous: []*Build{{ID: "1", Branch: "master", Status: "failure"}}, status: "failure", want: NotifyFailed},
+		{name: "first build passed", status: "success"},
+		{name: "passed again", previous: []*Build{{ID: "1", Branch: "master", Status: "success"}}, status: "success"},
+		{name: "recovered", previous: []*Build{{ID: "1", Branch: "master", Status: "failure"}}, status: "success", want: NotifyRecovered},
+		{name: "recovered with flaky tests", previous: []*Build{{ID: "1", Branch: "master", Status: "error"}}, status: BuildFlaky, want: NotifyRecovered},
+		{
+			name: "recovered after canceled and skipped builds",
+			previous: []*Build{
+				{ID: "1", Branch: "master", Status: "failure"},
+				{ID: "2", Branch: "master", Status: BuildCanceled},
+				{ID: "3", Branch: "master", Status: BuildSkipped},
+			},
+			status: "success",
+			want:   NotifyRecovered,
+		},
+		{name: "failure on another branch", previous: []*Build{{ID: "1", Branch: "feature", Status: "failure"}}, status: "success"},
+		{name: "canceled", previous: []*Build{{ID: "1", Branch: "master", Status: "failure"}}, status: BuildCanceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestLogDIR(t)
+			fake := useFakeNotifier(t)
+			saveTestBuilds(t, test.previous...)
+
+			build := &Build{ID: "9", Project: "owner/repo", Branch: "master", Status: test.status, Pusher: pusher}
+			notify(build)
+			if test.want == "" {
+				if len(fake.sent) != 0 {
+					t.Errorf("notify() sent %+v, want no notifications", fake.sent)
+				}
+				return
+			}
+			if len(fake.sent) != 1 {
+				t.Fatalf("notify() sent %d notifications, want 1", len(fake.sent))
+			}
+			if n := fake.sent[0]; n.Event != test.want || n.Build != build {
+				t.Errorf("notify() sent a %s notification for %+v, want a %s notification for the build", n.Event, n.Build, test.want)
+			}
+		})
+	}
+}
+
+func TestNotifyWithoutRecipients(t *testing.T) {
+	useTestLogDIR(t)
+	fake := useFakeNotifier(t)
+	notify(&Build{ID: "1", Project: "owner/repo", Branch: "master", Status: "failure", Authors: []Person{{Name: "author"}}})
+	if len(fake.sent) != 0 {
+		t.Errorf("notify() sent %+v, want no notifications", fake.sent)
+	}
+}
+
+func TestRecipients(t *testing.T) {
+	build := &Build{
+		Pusher: Person{Name: "pusher", Email: " Author@Example.com "},
+		Authors: []Person{
+			{Name: "author", Email: "Author@Example.com"},
+			{Name: "bot"},
+			{Name: "other", Email: "other@example.com"},
+			{Name: "author", Email: "author@example.com"},
+		},
+	}
+	want := []string{"Author@Example.com", "other@example.com"}
+	if got := build.recipients(); !reflect.DeepEqual(got, want) {
+		t.Errorf("recipients() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,113 @@
+package ci
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// PipelineConfigFile is the name of the pipeline config file in a project's repository
+const PipelineConfigFile = "sicuro.json"
+
+// PipelineConfig is the part of the pipeline config, and of the project settings,
+// that decides on the server whether a push triggers a build
+// The rest of the pipeline config is run by the test images
+type PipelineConfig struct {
+	// Branches filters the branches that are built
+	Branches Filter `json:"branches"`
+	// Paths filters the changed files that trigger a build
+	Paths Filter `json:"paths"`
+}
+
+// Filter is a list of globs to include and to exclude
+// "*" matches within a path segment and "**" matches across segments
+type Filter struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+// ParsePipelineConfig parses the contents of a pipeline config file
+func ParsePipelineConfig(data []byte) (*PipelineConfig, error) {
+	config := &PipelineConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// SkipReason returns why a push of the given files to the branch should not be built
+// It returns an empty string if the push should be built
+// The paths filter is not applied when the changed files are unknown
+func (c *PipelineConfig) SkipReason(branch string, files []string) string {
+	if branch != "" && !c.Branches.Match(branch) {
+		return fmt.Sprintf("branch %s is filtered out", branch)
+	}
+
+	if len(files) == 0 || c.Paths.IsEmpty() {
+		return ""
+	}
+	for _, file := range files {
+		if c.Paths.Match(file) {
+			return ""
+		}
+	}
+	return "none of the changed files match the paths filter"
+}
+
+// IsEmpty returns true if the filter has no globs
+func (f Filter) IsEmpty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// Match returns true if the value matches any of the include globs, or there are none,
+// and it matches none of the exclude globs
+func (f Filter) Match(value string) bool {
+	for _, pattern := range f.Exclude {
+		if globMatch(pattern, value) {
+			return false
+		}
+	}
+
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, pattern := range f.Include {
+		if globMatch(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch reports whether the value matches the glob pattern
+// Each path segment of the pattern is matched with path.Match, and a ** segment matches any number of segments
+// A trailing ** only matches the paths under its directory e.g docs/** doesn't match docs
+func globMatch(pattern, value string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(value, "/"))
+}
+
+func matchSegments(pattern, value []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return len(value) > 0
+			}
+			for i := 0; i <= len(value); i++ {
+				if matchSegments(pattern[1:], value[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(value) == 0 {
+			return false
+		}
+		if matched, err := path.Match(pattern[0], value[0]); err != nil || !matched {
+			return false
+		}
+		pattern, value = pattern[1:], value[1:]
+	}
+	return len(value) == 0
+}
@@ -0,0 +1,119 @@
+package ci
+
+import "testing"
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"master", "master", true},
+		{"master", "main", false},
+		{"release/*", "release/1.0", true},
+		{"release/*", "release/1.0/hotfix", false},
+		{"release/*", "release", false},
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/main.go", false},
+		{"v?", "v1", true},
+		{"v?", "v10", false},
+		{"wip/**", "wip/feature", true},
+		{"wip/**", "wip/feature/part", true},
+		{"wip/**", "wip", false},
+		{"**/*.md", "README.md", true},
+		{"**/*.md", "docs/guide/intro.md", true},
+		{"**/*.md", "docs/guide/intro.go", false},
+		{"docs/**/index.md", "docs/index.md", true},
+		{"docs/**/index.md", "docs/a/b/index.md", true},
+		{"docs/**/index.md", "site/docs/index.md", false},
+		{"**", "any/file.go", true},
+		{"[a-c]*.go", "build.go", true},
+		{"[", "[", false},
+		{"", "", true},
+		{"", "main.go", false},
+	}
+
+	for _, test := range tests {
+		if got := globMatch(test.pattern, test.value); got != test.want {
+			t.Errorf("globMatch(%q, %q) = %t, want %t", test.pattern, test.value, got, test.want)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		value  string
+		want   bool
+	}{
+		{name: "empty filter", value: "master", want: true},
+		{name: "included", filter: Filter{Include: []string{"master", "release/*"}}, value: "release/1.0", want: true},
+		{name: "not included", filter: Filter{Include: []string{"master", "release/*"}}, value: "feature", want: false},
+		{name: "excluded", filter: Filter{Exclude: []string{"wip/**"}}, value: "wip/feature", want: false},
+		{name: "not excluded", filter: Filter{Exclude: []string{"wip/**"}}, value: "feature", want: true},
+		{
+			name:   "exclude takes precedence",
+			filter: Filter{Include: []string{"release/*"}, Exclude: []string{"release/old"}},
+			value:  "release/old",
+			want:   false,
+		},
+		{
+			name:   "included and not excluded",
+			filter: Filter{Include: []string{"release/*"}, Exclude: []string{"release/old"}},
+			value:  "release/new",
+			want:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.filter.Match(test.value); got != test.want {
+				t.Errorf("Match(%q) = %t, want %t", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSkipReason(t *testing.T) {
+	config := &PipelineConfig{
+		Branches: Filter{Include: []string{"master", "release/*"}},
+		Paths:    Filter{Exclude: []string{"docs/**", "**/*.md"}},
+	}
+
+	tests := []struct {
+		name   string
+		config *PipelineConfig
+		branch string
+		files  []string
+		want   string
+	}{
+		{name: "empty config", config: &PipelineConfig{}, branch: "feature", files: []string{"README.md"}},
+		{name: "built", config: config, branch: "master", files: []string{"README.md", "main.go"}},
+		{name: "branch filtered out", config: config, branch: "feature", files: []string{"main.go"}, want: "branch feature is filtered out"},
+		{
+			name:   "only filtered out files",
+			config: config,
+			branch: "release/1.0",
+			files:  []string{"docs/index.html", "README.md"},
+			want:   "none of the changed files match the paths filter",
+		},
+		{name: "unknown files", config: config, branch: "master"},
+		{name: "unknown branch", config: config, files: []string{"main.go"}},
+		{
+			name:   "included paths",
+			config: &PipelineConfig{Paths: Filter{Include: []string{"cmd/**"}}},
+			branch: "master",
+			files:  []string{"pkg/util.go"},
+			want:   "none of the changed files match the paths filter",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.config.SkipReason(test.branch, test.files); got != test.want {
+				t.Errorf("SkipReason(%q, %q) = %q, want %q", test.branch, test.files, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package ci
+
+import (
+	"time"
+)
+
+// Polling is the polling state of a project whose branches are polled for new commits,
+// for projects the server can't create a webhook for or that can't reach the server
+type Polling struct {
+	// Branches are the polled branches, all the branches are polled if it's empty
+	Branches []string `json:",omitempty"`
+	// URL is the url the branches are listed from with git ls-remote
+	// when the provider's server token is not set
+	URL string `json:",omitempty"`
+	// Language and DefaultBranch are those of the repo when the project was subscribed,
+	// for the builds of the projects polled with git ls-remote
+	Language      string `json:",omitempty"`
+	DefaultBranch string `json:",omitempty"`
+	// Heads are the commits the branches pointed to at the last check, keyed by branch
+	// It's nil until the first check, which records the heads without building them
+	Heads map[string]string `json:",omitempty"`
+	// ETag is the ETag of the last branches response, the next request is conditional on it
+	ETag string `json:",omitempty"`
+	// LastCheck is when the branches were last checked
+	LastCheck time.Time
+	// Error explains why the last check failed, if it did
+	Error string `json:",omitempty"`
+}
+
+// PolledProjects returns the projects whose branches are polled i.e owner/project
+func PolledProjects() []string {
+	return projectsWith(func(settings *ProjectSettings) bool { return settings.Polling != nil })
+}
+
+// Polls returns true if the given branch is polled
+func (p *Polling) Polls(branch string) bool {
+	if len(p.Branches) == 0 {
+		return true
+	}
+	for _, b := range p.Branches {
+		if b == branch {
+			return true
+		}
+	}
+	return false
+}
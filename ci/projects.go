@@ -0,0 +1,159 @@
+package ci
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArchiveDIR is the folder the projects are archived to when they are unsubscribed
+var ArchiveDIR = filepath.Join(filepath.Dir(LogDIR), "archives")
+
+// StoredData is a kind of data stored for a project, such as its logs or its artifacts
+type StoredData struct {
+	Name  string
+	Files int
+	Bytes int64
+	// Cache is set for the data that's only kept to speed up builds e.g the checked out repository
+	// It's deleted even when the project is archived
+	Cache bool
+}
+
+// Size returns the size of the data in a human readable unit e.g 1.5 MB
+func (d StoredData) Size() string {
+	size, units := float64(d.Bytes), []string{"B", "KB", "MB", "GB"}
+	i := 0
+	for ; size >= 1024 && i < len(units)-1; i++ {
+		size /= 1024
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", d.Bytes)
+	}
+	return fmt.Sprintf("%.1f %s", size, units[i])
+}
+
+// ProjectStoredData returns the data stored for the given project, by kind
+// projectDir is the project's path relative to the LogDIR i.e owner/project
+func ProjectStoredData(projectDir string) []StoredData {
+	kinds := []StoredData{
+		{Name: "Build logs"},
+		{Name: "Build records"},
+		{Name: "Release artifacts"},
+		{Name: "Settings and bisect state"},
+		{Name: "Test and coverage reports", Cache: true},
+		{Name: "Checked out repository", Cache: true},
+	}
+
+	dir := filepath.Join(LogDIR, projectDir)
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		i := storedDataKind(rel)
+		kinds[i].Files++
+		kinds[i].Bytes += info.Size()
+		return nil
+	})
+	return kinds
+}
+
+// storedDataKind returns the index of the kind of the file at the given path relative to the project dir
+// in the kinds listed by ProjectStoredData
+func storedDataKind(rel string) int {
+	top := strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+	switch {
+	case strings.HasSuffix(rel, LogFileExt):
+		return 0
+	case top == BuildsName:
+		return 1
+	case top == ArtifactsName:
+		return 2
+	case top == settingsFileName || top == BackupName:
+		return 3
+	case top == ReportsName:
+		return 4
+	}
+	return 5
+}
+
+// isCacheFile returns true if the file at the given path relative to the project dir is cached data
+func isCacheFile(rel string) bool {
+	return storedDataKind(rel) >= 4
+}
+
+// ArchiveProject writes the logs, build records, artifacts and settings of the given project
+// to a gzipped tarball in the ArchiveDIR, then deletes the project's data
+// It returns the path of the archive
+func ArchiveProject(projectDir string) (string, error) {
+	dir := filepath.Join(LogDIR, projectDir)
+	archivePath := filepath.Join(ArchiveDIR, fmt.Sprintf("%s-%s.tar.gz", projectDir, time.Now().Format("20060102150405")))
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0755); err != nil {
+		return "", err
+	}
+
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		if info.IsDir() || !info.Mode().IsRegular() || isCacheFile(rel) {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join(filepath.Base(projectDir), rel))
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		os.Remove(archivePath)
+		return "", err
+	}
+	return archivePath, DeleteProject(projectDir)
+}
+
+// DeleteProject deletes all the data stored for the given project
+func DeleteProject(projectDir string) error {
+	dir := filepath.Join(LogDIR, projectDir)
+	if rel, err := filepath.Rel(LogDIR, dir); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("invalid project %q", projectDir)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	// remove the owner's folder once its last project is gone
+	os.Remove(filepath.Dir(dir))
+	return nil
+}
@@ -0,0 +1,139 @@
+package ci
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// newTestProject writes a file of each kind of stored data for the owner/repo project
+// and points the ArchiveDIR to a temporary directory for the duration of the test
+func newTestProject(t *testing.T) {
+	useTestLogDIR(t)
+	archiveDIR := ArchiveDIR
+	ArchiveDIR = filepath.Join(LogDIR, "..", filepath.Base(LogDIR)+"-archives")
+	t.Cleanup(func() {
+		os.RemoveAll(ArchiveDIR)
+		ArchiveDIR = archiveDIR
+	})
+
+	files := map[string]string{
+		"abc123" + LogFileExt:                "<h3>Test</h3>",
+		BuildsName + "/1.json":               "{}",
+		ArtifactsName + "/v1.0.0/app":        "binary",
+		settingsFileName:                     "{}",
+		BackupName + "/" + BisectName:        "abc123",
+		ReportsName + "/abc123/go-test.json": "{}",
+		"repo/main.go":                       "package main",
+	}
+	for name, content := range files {
+		path := filepath.Join(LogDIR, "owner", "repo", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProjectStoredData(t *testing.T) {
+	newTestProject(t)
+
+	want := map[string]int{
+		"Build logs":                1,
+		"Build records":             1,
+		"Release artifacts":         1,
+		"Settings and bisect state": 2,
+		"Test and coverage reports": 1,
+		"Checked out repository":    1,
+	}
+	for _, data := range ProjectStoredData("owner/repo") {
+		if data.Files != want[data.Name] {
+			t.Errorf("ProjectStoredData() has %d files of %s, want %d", data.Files, data.Name, want[data.Name])
+		}
+	}
+}
+
+func TestArchiveProject(t *testing.T) {
+	newTestProject(t)
+
+	path, err := ArchiveProject("owner/repo")
+	if err != nil {
+		t.Fatalf("ArchiveProject() returned %s", err)
+	}
+	if !strings.HasPrefix(path, filepath.Join(ArchiveDIR, "owner", "repo-")) {
+		t.Errorf("ArchiveProject() = %s, want an archive of the project in %s", path, ArchiveDIR)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+	sort.Strings(names)
+
+	// the reports and the checked out repository are left out
+	want := []string{
+		"repo/" + BuildsName + "/1.json",
+		"repo/" + ArtifactsName + "/v1.0.0/app",
+		"repo/" + BackupName + "/" + BisectName,
+		"repo/abc123" + LogFileExt,
+		"repo/" + settingsFileName,
+	}
+	sort.Strings(want)
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("archive has %q, want %q", names, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(LogDIR, "owner")); !os.IsNotExist(err) {
+		t.Errorf("the owner's folder is left after archiving its only project: %v", err)
+	}
+}
+
+func TestDeleteProject(t *testing.T) {
+	newTestProject(t)
+	other := filepath.Join(LogDIR, "owner", "other")
+	if err := os.MkdirAll(other, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, projectDir := range []string{"", ".", "..", "../owner", "owner/../.."} {
+		if err := DeleteProject(projectDir); err == nil {
+			t.Errorf("DeleteProject(%q) didn't fail", projectDir)
+		}
+	}
+
+	if err := DeleteProject("owner/repo"); err != nil {
+		t.Fatalf("DeleteProject() returned %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(LogDIR, "owner", "repo")); !os.IsNotExist(err) {
+		t.Errorf("the project's folder is left after deleting it: %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("the owner's other project was deleted: %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+package ci
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// TestPassed is the status of a test that passed
+	TestPassed = "pass"
+	// TestFailed is the status of a test that failed
+	TestFailed = "fail"
+	// TestSkipped is the status of a test that was skipped
+	TestSkipped = "skip"
+
+	// goTestReportName is the file the go image writes the `go test -json` output to
+	goTestReportName = "go-test.json"
+	// junitReportsDir is the folder other images copy the JUnit XML reports declared in the pipeline config to
+	junitReportsDir = "junit"
+)
+
+// TestResult is the outcome of a single test in a build
+type TestResult struct {
+	// Package is the go package or JUnit suite the test belongs to
+	Package string
+	// Name is the name of the test. It's empty for package level failures e.g build errors
+	Name string
+	// Status is one of pass, fail or skip
+	Status string
+	// Duration is how long the test took to run
+	Duration time.Duration
+	// Output is everything the test printed
+	Output string
+}
+
+// reportsDirFor returns the directory the test container writes the reports for the job to
+func reportsDirFor(job *JobDetails) string {
+	return filepath.Join(LogDIR, job.LogDirPath, ReportsName, job.ProjectBranch)
+}
+
+// collectTestResults parses all the reports found in the given directory
+func collectTestResults(dir string) (results []TestResult) {
+	if f, err := os.Open(filepath.Join(dir, goTestReportName)); err == nil {
+		res, err := parseGoTestJSON(f)
+		if err != nil {
+			log.Printf("Error %s occurred while parsing go test report in %s\n", err, dir)
+		}
+		results = append(results, res...)
+		f.Close()
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, junitReportsDir, "*.xml"))
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			log.Printf("Error %s occurred while opening junit report %s\n", err, file)
+			continue
+		}
+		res, err := parseJUnitXML(f)
+		if err != nil {
+			log.Printf("Error %s occurred while parsing junit report %s\n", err, file)
+		}
+		results = append(results, res...)
+		f.Close()
+	}
+	return
+}
+
+// goTestEvent is a single line of the `go test -json` output
+// See `go doc test2json` for details
+type goTestEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+// parseGoTestJSON parses the output of `go test -json`
+// Package level failures, such as build errors, are reported as a result without a test name
+// if none of the tests in the package failed
+func parseGoTestJSON(r io.Reader) ([]TestResult, error) {
+	results := []TestResult{}
+	index := map[string]int{}
+	output := map[string]*strings.Builder{}
+	failedPkgs := map[string]bool{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		evt := goTestEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), &evt); err != nil {
+			// go test prints build errors as plain text
+			continue
+		}
+
+		key := evt.Package + "/" + evt.Test
+		switch evt.Action {
+		case "output":
+			if output[key] == nil {
+				output[key] = &strings.Builder{}
+			}
+			output[key].WriteString(evt.Output)
+		case TestPassed, TestFailed, TestSkipped:
+			if evt.Test == "" {
+				if evt.Action == TestFailed {
+					failedPkgs[evt.Package] = true
+				}
+				continue
+			}
+			index[key] = len(results)
+			results = append(results, TestResult{
+				Package:  evt.Package,
+				Name:     evt.Test,
+				Status:   evt.Action,
+				Duration: time.Duration(evt.Elapsed * float64(time.Second)),
+			})
+		}
+	}
+
+	for key, i := range index {
+		if out, ok := output[key]; ok {
+			results[i].Output = out.String()
+		}
+	}
+
+	for pkg := range failedPkgs {
+		if packageHasFailedTest(results, pkg) {
+			continue
+		}
+		res := TestResult{Package: pkg, Status: TestFailed}
+		if out, ok := output[pkg+"/"]; ok {
+			res.Output = out.String()
+		}
+		results = append(results, res)
+	}
+
+	return results, scanner.Err()
+}
+
+func packageHasFailedTest(results []TestResult, pkg string) bool {
+	for _, res := range results {
+		if res.Package == pkg && res.Status == TestFailed {
+			return true
+		}
+	}
+	return false
+}
+
+type junitSuite struct {
+	Name   string       `xml:"name,attr"`
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Error     *junitMessage `xml:"error"`
+	Skipped   *junitMessage `xml:"skipped"`
+	SystemOut string        `xml:"system-out"`
+	SystemErr string        `xml:"system-err"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// parseJUnitXML parses a JUnit XML report
+// The root element may be either <testsuites> or a single <testsuite>
+func parseJUnitXML(r io.Reader) ([]TestResult, error) {
+	root := junitSuite{}
+	if err := xml.NewDecoder(r).Decode(&root); err != nil {
+		return nil, err
+	}
+	return junitSuiteResults(root), nil
+}
+
+func junitSuiteResults(suite junitSuite) (results []TestResult) {
+	for _, c := range suite.Cases {
+		res := TestResult{
+			Package: c.ClassName,
+			Name:    c.Name,
+			Status:  TestPassed,
+			Output:  strings.TrimSpace(c.SystemOut + "\n" + c.SystemErr),
+		}
+		if res.Package == "" {
+			res.Package = suite.Name
+		}
+		if secs, err := strconv.ParseFloat(c.Time, 64); err == nil {
+			res.Duration = time.Duration(secs * float64(time.Second))
+		}
+
+		for _, msg := range []*junitMessage{c.Failure, c.Error} {
+			if msg != nil {
+				res.Status = TestFailed
+				res.Output = strings.TrimSpace(strings.Join([]string{msg.Message, msg.Body, res.Output}, "\n"))
+			}
+		}
+		if res.Status != TestFailed && c.Skipped != nil {
+			res.Status = TestSkipped
+		}
+		results = append(results, res)
+	}
+
+	for _, s := range suite.Suites {
+		results = append(results, junitSuiteResults(s)...)
+	}
+	return
+}
+
+// retryArgs returns the `go test -run` pattern and the packages that rerun only the given failed tests
+// Subtests are rerun with their top level test. Both are empty if a package failed as a whole
+// e.g with a build error, in which case the retry runs all the tests
+func retryArgs(failed []TestResult) (run, packages string) {
+	names := []string{}
+	pkgs := []string{}
+	seenNames := map[string]bool{}
+	seenPkgs := map[string]bool{}
+	for _, t := range failed {
+		if t.Name == "" || strings.ContainsAny(t.Package, " \t") {
+			return "", ""
+		}
+		name := strings.SplitN(t.Name, "/", 2)[0]
+		if !seenNames[name] {
+			seenNames[name] = true
+			names = append(names, regexp.QuoteMeta(name))
+		}
+		if !seenPkgs[t.Package] {
+			seenPkgs[t.Package] = true
+			pkgs = append(pkgs, t.Package)
+		}
+	}
+	if len(names) == 0 {
+		return "", ""
+	}
+	return "^(" + strings.Join(names, "|") + ")$", strings.Join(pkgs, " ")
+}
+
+// mergeRetriedTests replaces the results of the first run with the results of the retried tests
+func mergeRetriedTests(first, retried []TestResult) []TestResult {
+	retriedByKey := map[string]TestResult{}
+	for _, t := range retried {
+		retriedByKey[t.Package+"/"+t.Name] = t
+	}
+
+	merged := make([]TestResult, len(first))
+	for i, t := range first {
+		if r, ok := retriedByKey[t.Package+"/"+t.Name]; ok {
+			t = r
+		}
+		merged[i] = t
+	}
+	return merged
+}
@@ -0,0 +1,224 @@
+package ci
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGoTestJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		report string
+		want   []TestResult
+	}{
+		{
+			name: "pass fail and skip",
+			report: `{"Action":"run","Package":"pkg","Test":"TestPass"}
+{"Action":"pass","Package":"pkg","Test":"TestPass","Elapsed":0.5}
+{"Action":"run","Package":"pkg","Test":"TestFail"}
+{"Action":"output","Package":"pkg","Test":"TestFail","Output":"    main_test.go:10: got 1, want 2\n"}
+{"Action":"fail","Package":"pkg","Test":"TestFail","Elapsed":1}
+{"Action":"run","Package":"pkg","Test":"TestSkip"}
+{"Action":"skip","Package":"pkg","Test":"TestSkip"}
+{"Action":"fail","Package":"pkg","Elapsed":1.5}`,
+			want: []TestResult{
+				{Package: "pkg", Name: "TestPass", Status: TestPassed, Duration: 500 * time.Millisecond},
+				{Package: "pkg", Name: "TestFail", Status: TestFailed, Duration: time.Second, Output: "    main_test.go:10: got 1, want 2\n"},
+				{Package: "pkg", Name: "TestSkip", Status: TestSkipped},
+			},
+		},
+		{
+			name: "interleaved output",
+			report: `{"Action":"run","Package":"pkg","Test":"TestA"}
+{"Action":"run","Package":"pkg","Test":"TestB"}
+{"Action":"output","Package":"pkg","Test":"TestA","Output":"a1\n"}
+{"Action":"output","Package":"pkg","Test":"TestB","Output":"b1\n"}
+{"Action":"output","Package":"pkg","Test":"TestA","Output":"a2\n"}
+{"Action":"pass","Package":"pkg","Test":"TestB"}
+{"Action":"fail","Package":"pkg","Test":"TestA"}`,
+			want: []TestResult{
+				{Package: "pkg", Name: "TestB", Status: TestPassed, Output: "b1\n"},
+				{Package: "pkg", Name: "TestA", Status: TestFailed, Output: "a1\na2\n"},
+			},
+		},
+		{
+			name: "subtests",
+			report: `{"Action":"run","Package":"pkg","Test":"TestTable"}
+{"Action":"run","Package":"pkg","Test":"TestTable/case_1"}
+{"Action":"pass","Package":"pkg","Test":"TestTable/case_1"}
+{"Action":"run","Package":"pkg","Test":"TestTable/case_2"}
+{"Action":"output","Package":"pkg","Test":"TestTable/case_2","Output":"wrong\n"}
+{"Action":"fail","Package":"pkg","Test":"TestTable/case_2"}
+{"Action":"fail","Package":"pkg","Test":"TestTable"}`,
+			want: []TestResult{
+				{Package: "pkg", Name: "TestTable/case_1", Status: TestPassed},
+				{Package: "pkg", Name: "TestTable/case_2", Status: TestFailed, Output: "wrong\n"},
+				{Package: "pkg", Name: "TestTable", Status: TestFailed},
+			},
+		},
+		{
+			name: "build error",
+			report: `# pkg [pkg.test]
+./main.go:3:1: syntax error
+{"Action":"output","Package":"pkg","Output":"FAIL\tpkg [build failed]\n"}
+{"Action":"fail","Package":"pkg","Elapsed":0}`,
+			want: []TestResult{
+				{Package: "pkg", Status: TestFailed, Output: "FAIL\tpkg [build failed]\n"},
+			},
+		},
+		{
+			name:   "malformed lines",
+			report: "{\"Action\":\"pass\",\"Package\":\"pkg\",\"Test\":\"TestA\"}\n{\"Action\":\"fail\",\n\nnot json",
+			want:   []TestResult{{Package: "pkg", Name: "TestA", Status: TestPassed}},
+		},
+		{
+			name: "empty",
+			want: []TestResult{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseGoTestJSON(strings.NewReader(test.report))
+			if err != nil {
+				t.Fatalf("parseGoTestJSON() returned %s", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseGoTestJSON() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseJUnitXML(t *testing.T) {
+	tests := []struct {
+		name    string
+		report  string
+		want    []TestResult
+		wantErr bool
+	}{
+		{
+			name: "pass fail error and skip",
+			report: `<testsuite name="suite">
+				<testcase classname="app.UserTest" name="creates" time="0.25"/>
+				<testcase classname="app.UserTest" name="updates" time="1"><failure message="expected 2">at UserTest.java:10</failure></testcase>
+				<testcase classname="app.UserTest" name="deletes"><error message="NullPointerException"/></testcase>
+				<testcase classname="app.UserTest" name="archives"><skipped/></testcase>
+			</testsuite>`,
+			want: []TestResult{
+				{Package: "app.UserTest", Name: "creates", Status: TestPassed, Duration: 250 * time.Millisecond},
+				{Package: "app.UserTest", Name: "updates", Status: TestFailed, Duration: time.Second, Output: "expected 2\nat UserTest.java:10"},
+				{Package: "app.UserTest", Name: "deletes", Status: TestFailed, Output: "NullPointerException"},
+				{Package: "app.UserTest", Name: "archives", Status: TestSkipped},
+			},
+		},
+		{
+			name: "nested suites and output",
+			report: `<testsuites>
+				<testsuite name="models">
+					<testcase name="saves"><system-out>saving</system-out><system-err>warning</system-err></testcase>
+				</testsuite>
+				<testsuite name="views">
+					<testsuite name="views.users">
+						<testcase name="renders"/>
+					</testsuite>
+				</testsuite>
+			</testsuites>`,
+			want: []TestResult{
+				{Package: "models", Name: "saves", Status: TestPassed, Output: "saving\nwarning"},
+				{Package: "views.users", Name: "renders", Status: TestPassed},
+			},
+		},
+		{
+			name:    "malformed",
+			report:  `<testsuite name="suite"><testcase name="creates">`,
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseJUnitXML(strings.NewReader(test.report))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseJUnitXML() returned error %v, want error %t", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseJUnitXML() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRetryArgs(t *testing.T) {
+	tests := []struct {
+		name         string
+		failed       []TestResult
+		wantRun      string
+		wantPackages string
+	}{
+		{
+			name: "tests of several packages",
+			failed: []TestResult{
+				{Package: "example.com/repo/a", Name: "TestOne"},
+				{Package: "example.com/repo/b", Name: "TestTwo"},
+				{Package: "example.com/repo/a", Name: "TestThree"},
+			},
+			wantRun:      "^(TestOne|TestTwo|TestThree)$",
+			wantPackages: "example.com/repo/a example.com/repo/b",
+		},
+		{
+			name: "subtests",
+			failed: []TestResult{
+				{Package: "example.com/repo", Name: "TestTable/case_2"},
+				{Package: "example.com/repo", Name: "TestTable"},
+			},
+			wantRun:      "^(TestTable)$",
+			wantPackages: "example.com/repo",
+		},
+		{
+			name: "package failure",
+			failed: []TestResult{
+				{Package: "example.com/repo/a", Name: "TestOne"},
+				{Package: "example.com/repo/b"},
+			},
+		},
+		{
+			name: "none",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			run, packages := retryArgs(test.failed)
+			if run != test.wantRun || packages != test.wantPackages {
+				t.Errorf("retryArgs() = %q, %q, want %q, %q", run, packages, test.wantRun, test.wantPackages)
+			}
+		})
+	}
+}
+
+func TestMergeRetriedTests(t *testing.T) {
+	first := []TestResult{
+		{Package: "pkg", Name: "TestPass", Status: TestPassed},
+		{Package: "pkg", Name: "TestFlaky", Status: TestFailed, Output: "timeout"},
+		{Package: "pkg", Name: "TestBroken", Status: TestFailed},
+	}
+	retried := []TestResult{
+		{Package: "pkg", Name: "TestFlaky", Status: TestPassed},
+		{Package: "pkg", Name: "TestBroken", Status: TestFailed, Output: "still broken"},
+	}
+	want := []TestResult{
+		{Package: "pkg", Name: "TestPass", Status: TestPassed},
+		{Package: "pkg", Name: "TestFlaky", Status: TestPassed},
+		{Package: "pkg", Name: "TestBroken", Status: TestFailed, Output: "still broken"},
+	}
+	if got := mergeRetriedTests(first, retried); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeRetriedTests() = %+v, want %+v", got, want)
+	}
+}
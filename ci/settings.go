@@ -0,0 +1,89 @@
+package ci
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const settingsFileName = "settings.json"
+
+// settingsMu serializes the updates of the projects' settings
+var settingsMu sync.Mutex
+
+// ProjectSettings are the settings of a project saved on the server
+type ProjectSettings struct {
+	// Provider is the ID of the VCS provider the project is hosted on
+	// It's empty for the projects subscribed before there were other providers i.e Github
+	Provider string `json:",omitempty"`
+	// Git is the repository of the projects built from plain git repositories
+	Git *GitSource `json:",omitempty"`
+	// Polling is the polling state of the projects whose branches are polled instead of
+	// being pushed by a webhook or a post-receive hook
+	Polling *Polling `json:",omitempty"`
+	// WebhookSecretID identifies the server's webhook secret the project's webhook was last set with,
+	// to tell whether the secret has been rotated since. It's a hash, the secret itself isn't saved
+	WebhookSecretID string `json:",omitempty"`
+	// DeployKey is the SSH key the test containers clone the repository with, instead of the server's key
+	// It's nil for the projects subscribed without deploy keys
+	DeployKey *DeployKey `json:",omitempty"`
+	// PullRequestComments is set if the summary of each pull request build is posted on the pull request,
+	// in a single comment updated by the later builds
+	PullRequestComments bool `json:",omitempty"`
+	// Filters decide whether a push triggers a build, along with the pipeline config
+	Filters PipelineConfig
+}
+
+// LoadProjectSettings returns the saved settings of the given project
+// It returns the default settings if none have been saved
+// projectDir is the project's path relative to the LogDIR i.e owner/project
+func LoadProjectSettings(projectDir string) (*ProjectSettings, error) {
+	settings := &ProjectSettings{}
+	data, err := ioutil.ReadFile(filepath.Join(LogDIR, projectDir, settingsFileName))
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+
+	err = json.Unmarshal(data, settings)
+	return settings, err
+}
+
+// UpdateProjectSettings loads the settings of the given project, applies the update and saves them
+// The settings can't be saved by another update in between, so that the update doesn't undo its changes
+func UpdateProjectSettings(projectDir string, update func(*ProjectSettings)) error {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	settings, err := LoadProjectSettings(projectDir)
+	if err != nil {
+		return err
+	}
+	update(settings)
+	return saveProjectSettings(projectDir, settings)
+}
+
+// SaveProjectSettings saves the settings of the given project
+func SaveProjectSettings(projectDir string, settings *ProjectSettings) error {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	return saveProjectSettings(projectDir, settings)
+}
+
+// saveProjectSettings writes the settings to a temporary file that replaces the settings file,
+// so that they are never read half written
+func saveProjectSettings(projectDir string, settings *ProjectSettings) error {
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(LogDIR, projectDir, settingsFileName)
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
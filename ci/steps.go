@@ -0,0 +1,62 @@
+package ci
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"time"
+)
+
+// Step is a timed section of a build
+// The entrypoints of the test images announce each step with a <h3> header
+type Step struct {
+	Name     string
+	Duration time.Duration
+}
+
+var stepHeaderRegex = regexp.MustCompile(`<h3>(.*?)</h3>`)
+
+// stepRecorder passes the container output through to the underlying writer
+// and times the steps announced in it
+type stepRecorder struct {
+	w       io.Writer
+	line    []byte
+	steps   []Step
+	started time.Time
+}
+
+func newStepRecorder(w io.Writer) *stepRecorder {
+	return &stepRecorder{w: w}
+}
+
+func (r *stepRecorder) Write(p []byte) (int, error) {
+	n, err := r.w.Write(p)
+	r.line = append(r.line, p[:n]...)
+	for {
+		i := bytes.IndexByte(r.line, '\n')
+		if i < 0 {
+			break
+		}
+		if match := stepHeaderRegex.FindSubmatch(r.line[:i]); match != nil {
+			r.start(string(match[1]))
+		}
+		r.line = r.line[i+1:]
+	}
+	return n, err
+}
+
+// start finishes the current step and starts timing the named one
+func (r *stepRecorder) start(name string) {
+	r.finish()
+	r.steps = append(r.steps, Step{Name: name})
+	r.started = time.Now()
+}
+
+// finish sets the duration of the current step, if any
+func (r *stepRecorder) finish() {
+	if len(r.steps) == 0 || r.started.IsZero() {
+		return
+	}
+	r.steps[len(r.steps)-1].Duration = time.Since(r.started)
+	r.started = time.Time{}
+}
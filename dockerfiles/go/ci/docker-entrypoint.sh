@@ -2,19 +2,36 @@
 trap 'exit' ERR
 cp -R /tmp/.ssh /root/.ssh
 chmod 700 /root/.ssh
-chmod 644 /root/.ssh/id_rsa.pub
-chmod 600 /root/.ssh/id_rsa
-ssh-keyscan github.com > /root/.ssh/known_hosts
+# the keys are the project's deploy key id_ed25519, or the server's id_rsa
+chmod 600 /root/.ssh/id_*
+chmod 644 /root/.ssh/id_*.pub
+ssh-keyscan ${GITHUB_HOST:-github.com} > /root/.ssh/known_hosts
+# repositories hosted elsewhere e.g on GitLab or the SSH host of a Github Enterprise server
+# are cloned from GIT_HOST, on GIT_PORT if set
+if [ -n "${GIT_HOST}" ] && [ "${GIT_HOST}" != "${GITHUB_HOST:-github.com}" ]; then
+  ssh-keyscan -p ${GIT_PORT:-22} ${GIT_HOST} >> /root/.ssh/known_hosts
+fi
+# repositories of Github App installations are cloned over HTTPS with the installation token
+if [ -n "${CLONE_TOKEN}" ]; then
+  git config --global url."https://x-access-token:${CLONE_TOKEN}@${GITHUB_HOST:-github.com}/".insteadOf "git@${GIT_HOST:-github.com}:"
+  git config --global --add url."https://x-access-token:${CLONE_TOKEN}@${GITHUB_HOST:-github.com}/".insteadOf "ssh://git@${GIT_HOST}${GIT_PORT:+:${GIT_PORT}}/"
+fi
 echo
 
 echo "<h3>Checkout source code</h3>"
 cd /shareddir/
 if [ -d "$PROJECT_REPOSITORY_NAME" ];
   then cd "${PROJECT_REPOSITORY_NAME}" && git checkout master && git pull --all;
-  else git clone -b master ${PROJECT_REPOSITORY_URL} ${PROJECT_REPOSITORY_NAME} && cd ${PROJECT_REPOSITORY_NAME};
+  else git clone -b master -- "${PROJECT_REPOSITORY_URL}" "${PROJECT_REPOSITORY_NAME}" && cd ${PROJECT_REPOSITORY_NAME};
 fi
 git stash clear
-git checkout ${PROJECT_BRANCH}
+# pull requests are built from their merge ref, falling back to the head commit
+if [ -n "${PROJECT_REF}" ] && git fetch origin "+${PROJECT_REF}:sicuro-ref"; then
+  git checkout sicuro-ref
+else
+  git fetch origin ${PROJECT_BRANCH} || true
+  git checkout ${PROJECT_BRANCH}
+fi
 echo hi
 git stash
 cd .
@@ -28,6 +45,38 @@ echo "stage 2: go mod build"
 go build ./...
 
 echo "<h3>Test</h3>"
-go test ./...
+# keep the machine readable test output in the reports dir for the server
+# and print the human readable part of it to the log
+REPORTS_DIR=/shareddir/reports/${PROJECT_BRANCH}
+mkdir -p ${REPORTS_DIR}
+TEST_EXIT=0
+# the retry of a failed build only reruns the failed tests of their packages, when the server sets them
+TEST_PACKAGES=${RETRY_PACKAGES:-./...}
+set -o pipefail
+go test -json -run "${RETRY_TESTS:-.}" -coverprofile=${REPORTS_DIR}/coverage.out ${TEST_PACKAGES} | tee ${REPORTS_DIR}/go-test.json \
+  | sed -n 's/^{.*"Action":"output",.*"Output":"\(.*\)"}$/\1/p' \
+  | sed -e 's/\\n$//' -e 's/\\t/\t/g' -e 's/\\"/"/g' \
+      -e 's/\\u003c/\&lt;/g' -e 's/\\u003e/\&gt;/g' -e 's/\\u0026/\&amp;/g' -e 's/\\\\/\\/g' \
+  || TEST_EXIT=$?
+set +o pipefail
+[ $TEST_EXIT -eq 0 ] || exit $TEST_EXIT
+
+# tag builds run the release steps of the pipeline config and copy the declared artifacts
+# to the artifacts dir for the server e.g
+# "release": { "custom": ["go build -o dist/app ./cmd/app"], "artifacts": ["dist/*"] }
+SICURO_CONFIG_FILE=./sicuro.json
+if [ -n "${PROJECT_TAG}" ] && [ -r $SICURO_CONFIG_FILE ]; then
+  echo "<h3>Release</h3>"
+  source <(cat $SICURO_CONFIG_FILE | jq --raw-output '. | .release.custom[]?')
+  ARTIFACTS_DIR=/shareddir/artifacts/${PROJECT_TAG}
+  mkdir -p ${ARTIFACTS_DIR}
+  for pattern in $(cat $SICURO_CONFIG_FILE | jq --raw-output '. | .release.artifacts[]?'); do
+    for artifact in $pattern; do
+      if [ -f "$artifact" ]; then
+        cp "$artifact" "${ARTIFACTS_DIR}/"
+      fi
+    done
+  done
+fi
 
 exec "$@"
@@ -9,14 +9,30 @@ echo "<h3>Starting the build</h3>"
 echo "<h3>Adding SSH keys</h3>"
 mkdir -p /root/.ssh/ && cp -R .ssh/* "$_"
 chmod 400 /root/.ssh/* &&\
-    ssh-keyscan github.com > /root/.ssh/known_hosts &&\
+    ssh-keyscan ${GITHUB_HOST:-github.com} > /root/.ssh/known_hosts &&\
     ssh-keyscan bitbucket.com >> /root/.ssh/known_hosts
+# repositories hosted elsewhere e.g on GitLab or the SSH host of a Github Enterprise server
+# are cloned from GIT_HOST, on GIT_PORT if set
+if [ -n "${GIT_HOST}" ] && [ "${GIT_HOST}" != "${GITHUB_HOST:-github.com}" ]; then
+    ssh-keyscan -p ${GIT_PORT:-22} ${GIT_HOST} >> /root/.ssh/known_hosts
+fi
+# repositories of Github App installations are cloned over HTTPS with the installation token
+if [ -n "${CLONE_TOKEN}" ]; then
+    git config --global url."https://x-access-token:${CLONE_TOKEN}@${GITHUB_HOST:-github.com}/".insteadOf "git@${GIT_HOST:-github.com}:"
+    git config --global --add url."https://x-access-token:${CLONE_TOKEN}@${GITHUB_HOST:-github.com}/".insteadOf "ssh://git@${GIT_HOST}${GIT_PORT:+:${GIT_PORT}}/"
+fi
 echo 
 
 echo "<h3>Checkout source code</h3>"
-git clone ${PROJECT_REPOSITORY_URL} ${PROJECT_REPOSITORY_NAME} 
+git clone -- "${PROJECT_REPOSITORY_URL}" "${PROJECT_REPOSITORY_NAME}" 
 cd ${PROJECT_REPOSITORY_NAME}
-git checkout ${PROJECT_BRANCH}
+# pull requests are built from their merge ref, falling back to the head commit
+if [ -n "${PROJECT_REF}" ] && git fetch origin "+${PROJECT_REF}:sicuro-ref"; then
+  git checkout sicuro-ref
+else
+  git fetch origin ${PROJECT_BRANCH} || true
+  git checkout ${PROJECT_BRANCH}
+fi
 # Have rvm recheck ruby version
 cd .
 echo
@@ -56,12 +72,50 @@ if $SICURO_CONFIG_PRESENT ; then
 fi
 
 echo "<h3>Test</h3>"
+TEST_EXIT=0
 if ! ($SICURO_CONFIG_PRESENT && $(cat $SICURO_CONFIG_FILE | jq --raw-output '. | .test.override//false')); then
     # default language test
-    bundle exec rake test
+    bundle exec rake test || TEST_EXIT=$?
+fi
+if $SICURO_CONFIG_PRESENT ; then
+    source <(cat $SICURO_CONFIG_FILE | jq --raw-output '. | .test.custom[]?') || TEST_EXIT=$?
 fi
+
+# copy the reports declared in the pipeline config to the reports dir for the server e.g
+# "reports": { "junit": ["test/reports/*.xml"], "coverage": ["coverage/lcov.info"] }
+# coverage reports can either be lcov tracefiles or cobertura XML reports
+copy_reports() {
+    REPORTS_DIR=/shareddir/reports/${PROJECT_BRANCH}/$2
+    mkdir -p ${REPORTS_DIR}
+    for pattern in $(cat $SICURO_CONFIG_FILE | jq --raw-output ". | .reports.$1[]?"); do
+        for report in $pattern; do
+            if [ -f "$report" ]; then
+                cp "$report" "${REPORTS_DIR}/$(echo $report | tr '/' '_')"
+            fi
+        done
+    done
+}
 if $SICURO_CONFIG_PRESENT ; then
-    source <(cat $SICURO_CONFIG_FILE | jq --raw-output '. | .test.custom[]?')
+    copy_reports junit junit
+    copy_reports coverage coverage
+fi
+[ $TEST_EXIT -eq 0 ] || exit $TEST_EXIT
+
+# tag builds run the release steps of the pipeline config and copy the declared artifacts
+# to the artifacts dir for the server e.g
+# "release": { "custom": ["make dist"], "artifacts": ["dist/*"] }
+if [ -n "${PROJECT_TAG}" ] && $SICURO_CONFIG_PRESENT ; then
+    echo "<h3>Release</h3>"
+    source <(cat $SICURO_CONFIG_FILE | jq --raw-output '. | .release.custom[]?')
+    ARTIFACTS_DIR=/shareddir/artifacts/${PROJECT_TAG}
+    mkdir -p ${ARTIFACTS_DIR}
+    for pattern in $(cat $SICURO_CONFIG_FILE | jq --raw-output '. | .release.artifacts[]?'); do
+        for artifact in $pattern; do
+            if [ -f "$artifact" ]; then
+                cp "$artifact" "${ARTIFACTS_DIR}/"
+            fi
+        done
+    done
 fi
 
 exec "$@"